@@ -9,6 +9,9 @@ import (
 	"github.com/AKoricansky/wac-be-xkoricansky/api"
 	"github.com/AKoricansky/wac-be-xkoricansky/internal/ambulance_counseling_wl"
 	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/file_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -25,44 +28,140 @@ func main() {
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "If-Match"},
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
 
-	userDbService := db_service.NewMongoService[ambulance_counseling_wl.User](db_service.MongoServiceConfig{
-		DbName:     "ambulance-counseling",
-		Collection: "users",
-	})
+	userDbService := newDbService[ambulance_counseling_wl.User]("users")
+	storedUserDbService := newDbService[ambulance_counseling_wl.StoredUserFields]("users")
+	questionDbService := newDbService[ambulance_counseling_wl.Question]("questions")
+	embeddedReplyDbService := newDbService[ambulance_counseling_wl.EmbeddedReplies]("questions")
+	replyDbService := newDbService[ambulance_counseling_wl.Reply]("replies")
+	refreshTokenDbService := newDbService[ambulance_counseling_wl.RefreshToken]("refresh_tokens")
+	revokedTokenDbService := newDbService[ambulance_counseling_wl.RevokedToken]("revoked_tokens")
+	knowledgeBaseDbService := newDbService[ambulance_counseling_wl.KnowledgeBaseArticle]("knowledge_base")
+	categoryDbService := newDbService[ambulance_counseling_wl.Category]("categories")
+	notificationDbService := newDbService[ambulance_counseling_wl.PendingNotification]("notifications")
+	webhookDbService := newDbService[ambulance_counseling_wl.Webhook]("webhooks")
+	webhookDeliveryDbService := newDbService[ambulance_counseling_wl.WebhookDelivery]("webhook_deliveries")
+	outboxDbService := newDbService[ambulance_counseling_wl.OutboxEvent]("outbox")
+	processedEventDbService := newDbService[ambulance_counseling_wl.ProcessedEvent]("processed_events")
 
-	questionDbService := db_service.NewMongoService[ambulance_counseling_wl.Question](db_service.MongoServiceConfig{
-		DbName:     "ambulance-counseling",
-		Collection: "questions",
-	})
-
-	replyDbService := db_service.NewMongoService[ambulance_counseling_wl.Reply](db_service.MongoServiceConfig{
-		DbName:     "ambulance-counseling",
-		Collection: "replies",
-	})
+	transactor := newTransactor()
+	fileStore := newFileStore()
+	mailer := newMailer()
+	replyEventBus := event_service.NewMemoryBus[ambulance_counseling_wl.ReplyEvent]()
+	questionEventBus := event_service.NewMemoryBus[ambulance_counseling_wl.QuestionEvent]()
 
 	ctx := context.Background()
 	defer func() {
 		if err := userDbService.Disconnect(ctx); err != nil {
 			log.Printf("Error disconnecting from user database: %v", err)
 		}
+		if err := storedUserDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from user database: %v", err)
+		}
 		if err := questionDbService.Disconnect(ctx); err != nil {
 			log.Printf("Error disconnecting from question database: %v", err)
 		}
+		if err := embeddedReplyDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from question database: %v", err)
+		}
 		if err := replyDbService.Disconnect(ctx); err != nil {
 			log.Printf("Error disconnecting from reply database: %v", err)
 		}
+		if err := refreshTokenDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from refresh token database: %v", err)
+		}
+		if err := revokedTokenDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from revoked token database: %v", err)
+		}
+		if err := knowledgeBaseDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from knowledge base database: %v", err)
+		}
+		if err := categoryDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from category database: %v", err)
+		}
+		if err := notificationDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from notification database: %v", err)
+		}
+		if err := webhookDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from webhook database: %v", err)
+		}
+		if err := webhookDeliveryDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from webhook delivery database: %v", err)
+		}
+		if err := outboxDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from outbox database: %v", err)
+		}
+		if err := processedEventDbService.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from processed event database: %v", err)
+		}
+		if err := transactor.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting the transactor: %v", err)
+		}
+		if err := fileStore.Disconnect(ctx); err != nil {
+			log.Printf("Error disconnecting from file store: %v", err)
+		}
 	}()
 
+	// units of work are atomic or the service does not start, see the deployment manifests
+	if err := transactor.Verify(ctx); err != nil {
+		log.Fatalf("Storage cannot run transactions: %v", err)
+	}
+
+	// accounts stored by earlier versions are completed before requests are served
+	if _, err := ambulance_counseling_wl.MigrateUsers(ctx, userDbService, storedUserDbService); err != nil {
+		log.Fatalf("Failed to migrate user accounts: %v", err)
+	}
+
+	if adminEmail := os.Getenv("AMBULANCE_COUNSELING_ADMIN_EMAIL"); adminEmail != "" {
+		adminPassword := os.Getenv("AMBULANCE_COUNSELING_ADMIN_PASSWORD")
+		if err := ambulance_counseling_wl.BootstrapAdmin(ctx, userDbService, adminEmail, adminPassword); err != nil {
+			log.Printf("Failed to bootstrap the administrator account: %v", err)
+		}
+	}
+
+	// replies are looked up by their question and listed oldest first
+	if err := replyDbService.EnsureIndex(ctx, "questionid", "createdat", "id"); err != nil {
+		log.Printf("Failed to index replies by question: %v", err)
+	}
+	// the doctor queue pages through the questions of a priority by their waiting time
+	if err := questionDbService.EnsureIndex(ctx, "status", "priority", "waitingsince", "id"); err != nil {
+		log.Printf("Failed to index the doctor queue: %v", err)
+	}
+	// a question written before its embedded replies moved would drop them, they link the replies to it
+	if _, err := ambulance_counseling_wl.MigrateReplies(ctx, questionDbService, embeddedReplyDbService, replyDbService, transactor); err != nil {
+		log.Fatalf("Failed to migrate replies: %v", err)
+	}
+	// the doctor queue is paged by the stored waiting time, questions stored before it get it here
+	if _, err := ambulance_counseling_wl.MigrateQueue(ctx, questionDbService, replyDbService); err != nil {
+		log.Fatalf("Failed to migrate the doctor queue: %v", err)
+	}
+
+	// events stay in the outbox until the notifier and the webhook dispatcher acknowledged them
+	outbox := ambulance_counseling_wl.NewOutbox(outboxDbService, questionDbService, processedEventDbService, replyEventBus, questionEventBus, ambulance_counseling_wl.OutboxConsumers...)
+	go outbox.Run(ctx)
+	notifier := ambulance_counseling_wl.NewNotifier(userDbService, notificationDbService, processedEventDbService, mailer, replyEventBus, questionEventBus)
+	go notifier.Run(ctx)
+	webhookDispatcher := ambulance_counseling_wl.NewWebhookDispatcher(webhookDbService, webhookDeliveryDbService, processedEventDbService, replyEventBus, questionEventBus)
+	go webhookDispatcher.Run(ctx)
+
 	handleFunctions := &ambulance_counseling_wl.ApiHandleFunctions{
-		AmbulanceCounselingAPI:     ambulance_counseling_wl.NewAmbulanceCounselingApi(questionDbService, replyDbService),
-		AmbulanceCounselingAuthAPI: ambulance_counseling_wl.NewAmbulanceCounselingAuthApi(userDbService),
+		AmbulanceCounselingAPI:              ambulance_counseling_wl.NewAmbulanceCounselingApi(questionDbService, replyDbService, categoryDbService, transactor, fileStore, outbox),
+		AmbulanceCounselingAdminAPI:         ambulance_counseling_wl.NewAmbulanceCounselingAdminApi(userDbService, refreshTokenDbService, mailer),
+		AmbulanceCounselingAssignmentAPI:    ambulance_counseling_wl.NewAmbulanceCounselingAssignmentApi(userDbService, questionDbService, replyDbService),
+		AmbulanceCounselingAttachmentAPI:    ambulance_counseling_wl.NewAmbulanceCounselingAttachmentApi(questionDbService, replyDbService, fileStore),
+		AmbulanceCounselingAuthAPI:          ambulance_counseling_wl.NewAmbulanceCounselingAuthApi(userDbService, refreshTokenDbService, revokedTokenDbService, mailer),
+		AmbulanceCounselingCategoryAPI:      ambulance_counseling_wl.NewAmbulanceCounselingCategoryApi(categoryDbService, questionDbService),
+		AmbulanceCounselingChatAPI:          ambulance_counseling_wl.NewAmbulanceCounselingChatApi(questionDbService, replyDbService, transactor, replyEventBus, outbox),
+		AmbulanceCounselingEventsAPI:        ambulance_counseling_wl.NewAmbulanceCounselingEventsApi(replyEventBus),
+		AmbulanceCounselingKnowledgeBaseAPI: ambulance_counseling_wl.NewAmbulanceCounselingKnowledgeBaseApi(knowledgeBaseDbService, userDbService, questionDbService, replyDbService),
+		AmbulanceCounselingProfileAPI:       ambulance_counseling_wl.NewAmbulanceCounselingProfileApi(userDbService, categoryDbService, questionDbService, replyDbService),
+		AmbulanceCounselingWebhookAPI:       ambulance_counseling_wl.NewAmbulanceCounselingWebhookApi(webhookDbService, webhookDeliveryDbService),
 	}
 	ambulance_counseling_wl.NewRouterWithGinEngine(engine, *handleFunctions)
 
@@ -70,3 +169,50 @@ func main() {
 	api.RegisterSwaggerRoutes(engine)
 	engine.Run(":" + port)
 }
+
+// Creates the storage for a collection, AMBULANCE_COUNSELING_API_STORAGE=memory
+// keeps all data in process memory instead of MongoDB
+func newDbService[DocType interface{}](collection string) db_service.DbService[DocType] {
+	if os.Getenv("AMBULANCE_COUNSELING_API_STORAGE") == "memory" {
+		log.Printf("Using in-memory storage for collection %v", collection)
+		return db_service.NewMemoryService[DocType]()
+	}
+	return db_service.NewMongoService[DocType](db_service.MongoServiceConfig{
+		DbName:     "ambulance-counseling",
+		Collection: collection,
+	})
+}
+
+// Transactions span the collections of the database, the storage decides how they are run.
+// MongoDB needs a replica set, AMBULANCE_COUNSELING_API_MONGODB_ALLOW_NON_ATOMIC_WRITES=true
+// accepts a standalone server whose multi-document writes are not atomic.
+func newTransactor() db_service.Transactor {
+	if os.Getenv("AMBULANCE_COUNSELING_API_STORAGE") == "memory" {
+		return db_service.NewMemoryTransactor()
+	}
+	return db_service.NewMongoTransactor(db_service.MongoServiceConfig{
+		DbName: "ambulance-counseling",
+	})
+}
+
+// Attachments are stored in MongoDB GridFS, AMBULANCE_COUNSELING_API_FILE_STORAGE=disk
+// or the in-memory storage of documents keep them in a local directory instead
+func newFileStore() file_service.FileStore {
+	if os.Getenv("AMBULANCE_COUNSELING_API_FILE_STORAGE") == "disk" || os.Getenv("AMBULANCE_COUNSELING_API_STORAGE") == "memory" {
+		return file_service.NewDiskStore(file_service.DiskStoreConfig{})
+	}
+	// the bucket lives in the database of the documents and shares their connection
+	return file_service.NewGridFsStore(file_service.GridFsStoreConfig{
+		Database: db_service.NewMongoDatabase(db_service.MongoServiceConfig{
+			DbName: "ambulance-counseling",
+		}),
+	})
+}
+
+// AMBULANCE_COUNSELING_API_MAILER=smtp delivers emails, otherwise they are written to files or the log
+func newMailer() mail_service.Mailer {
+	if os.Getenv("AMBULANCE_COUNSELING_API_MAILER") == "smtp" {
+		return mail_service.NewSmtpMailer(mail_service.SmtpMailerConfig{})
+	}
+	return mail_service.NewFileMailer(mail_service.FileMailerConfig{})
+}
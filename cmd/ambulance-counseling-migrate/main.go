@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/ambulance_counseling_wl"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// Moves the replies embedded in questions to the reply collection ahead of a rollout, the API
+// service runs the same migration before it serves requests. The MongoDB connection is
+// configured by the same environment variables as the API service.
+func main() {
+	ctx := context.Background()
+	questionDbService := newDbService[ambulance_counseling_wl.Question]("questions")
+	embeddedDbService := newDbService[ambulance_counseling_wl.EmbeddedReplies]("questions")
+	replyDbService := newDbService[ambulance_counseling_wl.Reply]("replies")
+	transactor := db_service.NewMongoTransactor(db_service.MongoServiceConfig{
+		DbName: "ambulance-counseling",
+	})
+	defer func() {
+		for _, disconnect := range []func(context.Context) error{questionDbService.Disconnect, embeddedDbService.Disconnect, replyDbService.Disconnect, transactor.Disconnect} {
+			if err := disconnect(ctx); err != nil {
+				log.Printf("Error disconnecting from database: %v", err)
+			}
+		}
+	}()
+
+	if err := replyDbService.EnsureIndex(ctx, "questionid", "createdat", "id"); err != nil {
+		log.Fatalf("Failed to index replies by question: %v", err)
+	}
+	migrated, err := ambulance_counseling_wl.MigrateReplies(ctx, questionDbService, embeddedDbService, replyDbService, transactor)
+	if err != nil {
+		log.Fatalf("Migration stopped after %d replies: %v", migrated, err)
+	}
+	log.Printf("Migrated %d replies", migrated)
+}
+
+func newDbService[DocType interface{}](collection string) db_service.DbService[DocType] {
+	return db_service.NewMongoService[DocType](db_service.MongoServiceConfig{
+		DbName:     "ambulance-counseling",
+		Collection: collection,
+	})
+}
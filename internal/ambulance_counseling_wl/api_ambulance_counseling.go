@@ -17,6 +17,10 @@ import (
 type AmbulanceCounselingAPI interface {
 
 
+    // CloseQuestion Post /ak-ambulance-counseling-api/questions/:id/close
+    // Close a question 
+     CloseQuestion(c *gin.Context)
+
     // CreateQuestion Post /ak-ambulance-counseling-api/questions/new
     // Create a new question 
      CreateQuestion(c *gin.Context)
@@ -37,6 +41,10 @@ type AmbulanceCounselingAPI interface {
     // Get all question summaries 
      GetQuestions(c *gin.Context)
 
+    // GetQueue Get /ak-ambulance-counseling-api/queue
+    // Get the questions waiting for a doctor ordered by priority 
+     GetQueue(c *gin.Context)
+
     // GetRepliesByQuestionId Get /ak-ambulance-counseling-api/questions/:id/replies
     // Get all replies for a specific question 
      GetRepliesByQuestionId(c *gin.Context)
@@ -45,6 +53,10 @@ type AmbulanceCounselingAPI interface {
     // Get a specific reply by ID 
      GetReplyById(c *gin.Context)
 
+    // ReopenQuestion Post /ak-ambulance-counseling-api/questions/:id/reopen
+    // Reopen a closed or resolved question 
+     ReopenQuestion(c *gin.Context)
+
     // ReplyToQuestion Post /ak-ambulance-counseling-api/questions/:id/reply
     // Reply to a question 
      ReplyToQuestion(c *gin.Context)
@@ -53,6 +65,14 @@ type AmbulanceCounselingAPI interface {
     // Update a question by ID 
      UpdateQuestionById(c *gin.Context)
 
+    // UpdateQuestionPriority Put /ak-ambulance-counseling-api/update/question/:id/priority
+    // Change the triage priority of a question 
+     UpdateQuestionPriority(c *gin.Context)
+
+    // UpdateQuestionVisibility Put /ak-ambulance-counseling-api/update/question/:id/visibility
+    // Change who may read a question 
+     UpdateQuestionVisibility(c *gin.Context)
+
     // UpdateReplyById Put /ak-ambulance-counseling-api/update/reply/:id
     // Update a reply by ID 
      UpdateReplyById(c *gin.Context)
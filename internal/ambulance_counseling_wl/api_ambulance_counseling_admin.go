@@ -0,0 +1,52 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingAdminAPI interface {
+
+
+    // ApproveDoctor Post /ak-ambulance-counseling-api/admin/doctors/:userId/approve
+    // Approve the credentials of a doctor 
+     ApproveDoctor(c *gin.Context)
+
+    // ChangeUserType Put /ak-ambulance-counseling-api/admin/users/:userId/type
+    // Promote or demote a user 
+     ChangeUserType(c *gin.Context)
+
+    // DisableUser Post /ak-ambulance-counseling-api/admin/users/:userId/disable
+    // Disable a user account 
+     DisableUser(c *gin.Context)
+
+    // EnableUser Post /ak-ambulance-counseling-api/admin/users/:userId/enable
+    // Enable a disabled user account 
+     EnableUser(c *gin.Context)
+
+    // GetPendingDoctors Get /ak-ambulance-counseling-api/admin/doctors/pending
+    // List doctors awaiting approval 
+     GetPendingDoctors(c *gin.Context)
+
+    // GetUsers Get /ak-ambulance-counseling-api/admin/users
+    // List and search users 
+     GetUsers(c *gin.Context)
+
+    // RejectDoctor Post /ak-ambulance-counseling-api/admin/doctors/:userId/reject
+    // Reject the credentials of a doctor 
+     RejectDoctor(c *gin.Context)
+
+    // ResetUserPassword Post /ak-ambulance-counseling-api/admin/users/:userId/password-reset
+    // Send a password reset email to a user 
+     ResetUserPassword(c *gin.Context)
+
+}
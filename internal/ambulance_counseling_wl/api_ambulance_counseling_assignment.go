@@ -0,0 +1,32 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingAssignmentAPI interface {
+
+
+    // AssignQuestion Put /ak-ambulance-counseling-api/questions/:id/assignee
+    // Reassign a question to another doctor 
+     AssignQuestion(c *gin.Context)
+
+    // ClaimQuestion Post /ak-ambulance-counseling-api/questions/:id/claim
+    // Claim a question for the current doctor 
+     ClaimQuestion(c *gin.Context)
+
+    // ReleaseQuestion Post /ak-ambulance-counseling-api/questions/:id/release
+    // Release a claimed question back to the queue 
+     ReleaseQuestion(c *gin.Context)
+
+}
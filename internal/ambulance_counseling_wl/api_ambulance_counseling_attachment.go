@@ -0,0 +1,36 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingAttachmentAPI interface {
+
+
+    // DeleteAttachment Delete /ak-ambulance-counseling-api/questions/:id/attachments/:attachmentId
+    // Delete an attachment 
+     DeleteAttachment(c *gin.Context)
+
+    // DownloadAttachment Get /ak-ambulance-counseling-api/questions/:id/attachments/:attachmentId
+    // Download an attachment of a question or of its replies 
+     DownloadAttachment(c *gin.Context)
+
+    // UploadQuestionAttachment Post /ak-ambulance-counseling-api/questions/:id/attachments
+    // Attach a file to a question 
+     UploadQuestionAttachment(c *gin.Context)
+
+    // UploadReplyAttachment Post /ak-ambulance-counseling-api/questions/:id/reply/:replyId/attachments
+    // Attach a file to a reply 
+     UploadReplyAttachment(c *gin.Context)
+
+}
@@ -17,12 +17,36 @@ import (
 type AmbulanceCounselingAuthAPI interface {
 
 
+    // ConfirmPasswordReset Post /ak-ambulance-counseling-api/password-reset/confirm
+    // Set a new password using a reset token 
+     ConfirmPasswordReset(c *gin.Context)
+
+    // RefreshToken Post /ak-ambulance-counseling-api/refresh
+    // Exchange a refresh token for new tokens 
+     RefreshToken(c *gin.Context)
+
+    // RequestPasswordReset Post /ak-ambulance-counseling-api/password-reset/request
+    // Request a password reset email 
+     RequestPasswordReset(c *gin.Context)
+
+    // ResendVerificationEmail Post /ak-ambulance-counseling-api/verify-email/resend
+    // Send a new email verification link 
+     ResendVerificationEmail(c *gin.Context)
+
     // UserLogin Post /ak-ambulance-counseling-api/login
     // User login 
      UserLogin(c *gin.Context)
 
+    // UserLogout Post /ak-ambulance-counseling-api/logout
+    // User logout 
+     UserLogout(c *gin.Context)
+
     // UserRegister Post /ak-ambulance-counseling-api/register
     // User registration 
      UserRegister(c *gin.Context)
 
+    // VerifyEmail Get /ak-ambulance-counseling-api/verify-email
+    // Verify the email address of an account 
+     VerifyEmail(c *gin.Context)
+
 }
\ No newline at end of file
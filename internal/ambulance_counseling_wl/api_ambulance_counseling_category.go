@@ -0,0 +1,36 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingCategoryAPI interface {
+
+
+    // CreateCategory Post /ak-ambulance-counseling-api/admin/categories
+    // Create a question category 
+     CreateCategory(c *gin.Context)
+
+    // DeleteCategory Delete /ak-ambulance-counseling-api/admin/categories/:categoryId
+    // Delete an unused question category 
+     DeleteCategory(c *gin.Context)
+
+    // GetCategories Get /ak-ambulance-counseling-api/categories
+    // Get all question categories 
+     GetCategories(c *gin.Context)
+
+    // UpdateCategory Put /ak-ambulance-counseling-api/admin/categories/:categoryId
+    // Update a question category 
+     UpdateCategory(c *gin.Context)
+
+}
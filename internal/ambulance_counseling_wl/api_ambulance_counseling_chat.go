@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingChatAPI interface {
+
+
+    // JoinQuestionChat Get /ak-ambulance-counseling-api/questions/:questionId/chat
+    // Open the live chat of a question over WebSocket 
+     JoinQuestionChat(c *gin.Context)
+
+}
@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingEventsAPI interface {
+
+
+    // GetEvents Get /ak-ambulance-counseling-api/events
+    // Stream reply changes of own questions or of the doctor queue 
+     GetEvents(c *gin.Context)
+
+}
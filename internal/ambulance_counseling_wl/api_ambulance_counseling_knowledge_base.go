@@ -0,0 +1,36 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingKnowledgeBaseAPI interface {
+
+
+    // GetKnowledgeBaseArticleById Get /ak-ambulance-counseling-api/knowledge-base/:articleId
+    // Get an anonymized article 
+     GetKnowledgeBaseArticleById(c *gin.Context)
+
+    // GetKnowledgeBaseArticles Get /ak-ambulance-counseling-api/knowledge-base
+    // List anonymized articles 
+     GetKnowledgeBaseArticles(c *gin.Context)
+
+    // PublishQuestion Post /ak-ambulance-counseling-api/questions/:id/publish
+    // Publish an answered question to the knowledge base 
+     PublishQuestion(c *gin.Context)
+
+    // UnpublishQuestion Delete /ak-ambulance-counseling-api/questions/:id/publish
+    // Remove a question from the knowledge base 
+     UnpublishQuestion(c *gin.Context)
+
+}
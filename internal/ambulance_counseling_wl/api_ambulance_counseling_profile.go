@@ -0,0 +1,28 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingProfileAPI interface {
+
+
+    // GetProfile Get /ak-ambulance-counseling-api/profile
+    // Get the profile of the current user 
+     GetProfile(c *gin.Context)
+
+    // UpdateProfile Put /ak-ambulance-counseling-api/profile
+    // Update the profile of the current user 
+     UpdateProfile(c *gin.Context)
+
+}
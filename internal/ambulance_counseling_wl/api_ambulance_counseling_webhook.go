@@ -0,0 +1,40 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AmbulanceCounselingWebhookAPI interface {
+
+
+    // CreateWebhook Post /ak-ambulance-counseling-api/admin/webhooks
+    // Subscribe an endpoint to events 
+     CreateWebhook(c *gin.Context)
+
+    // DeleteWebhook Delete /ak-ambulance-counseling-api/admin/webhooks/:webhookId
+    // Delete a webhook subscription 
+     DeleteWebhook(c *gin.Context)
+
+    // GetWebhookDeliveries Get /ak-ambulance-counseling-api/admin/webhooks/:webhookId/deliveries
+    // Get the delivery log of a webhook 
+     GetWebhookDeliveries(c *gin.Context)
+
+    // GetWebhooks Get /ak-ambulance-counseling-api/admin/webhooks
+    // Get all webhook subscriptions 
+     GetWebhooks(c *gin.Context)
+
+    // UpdateWebhook Put /ak-ambulance-counseling-api/admin/webhooks/:webhookId
+    // Update a webhook subscription 
+     UpdateWebhook(c *gin.Context)
+
+}
@@ -0,0 +1,54 @@
+package ambulance_counseling_wl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// refresh tokens are rotated on every use, a session ends after this period of inactivity
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// RefreshToken is the stored counterpart of an issued refresh token. The token itself is
+// never stored, the document id is its SHA-256 hash.
+type RefreshToken struct {
+	Id string `bson:"id"`
+
+	UserId string `bson:"userId"`
+
+	// All tokens rotated from the same login share the family, reuse of a rotated
+	// token revokes the whole family
+	FamilyId string `bson:"familyId"`
+
+	CreatedAt time.Time `bson:"createdAt"`
+
+	ExpiresAt time.Time `bson:"expiresAt"`
+
+	// Set once the token was exchanged for a new one
+	Used bool `bson:"used"`
+
+	Version int64 `bson:"version"`
+}
+
+func (t *RefreshToken) GetVersion() int64 {
+	return t.Version
+}
+
+func (t *RefreshToken) SetVersion(version int64) {
+	t.Version = version
+}
+
+// RevokedToken records the jti of an access token invalidated before its expiry
+type RevokedToken struct {
+	Id string `bson:"id"`
+
+	UserId string `bson:"userId"`
+
+	// Revocation records are only needed until the access token expires anyway
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
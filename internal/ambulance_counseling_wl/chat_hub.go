@@ -0,0 +1,114 @@
+package ambulance_counseling_wl
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Time a participant has to accept a chat message before the connection is considered dead
+const chatWriteTimeout = 10 * time.Second
+
+const (
+	chatMessageText     = "message"
+	chatMessageTyping   = "typing"
+	chatMessagePresence = "presence"
+	chatMessageError    = "error"
+)
+
+// chatConnection is one open WebSocket of a participant, writes are serialized
+// because replies, typing indicators and presence changes are sent from different goroutines
+type chatConnection struct {
+	conn        *websocket.Conn
+	participant ChatParticipant
+	lock        sync.Mutex
+}
+
+func (cc *chatConnection) send(message ChatMessage) error {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if err := cc.conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout)); err != nil {
+		return err
+	}
+	return websocket.JSON.Send(cc.conn, message)
+}
+
+// chatHub tracks the connections of every question chat in this process, replies themselves
+// are distributed by the event bus
+type chatHub struct {
+	lock  sync.Mutex
+	rooms map[string]map[*chatConnection]struct{}
+}
+
+func newChatHub() *chatHub {
+	return &chatHub{
+		rooms: map[string]map[*chatConnection]struct{}{},
+	}
+}
+
+func (h *chatHub) join(questionId string, connection *chatConnection) {
+	h.lock.Lock()
+	if h.rooms[questionId] == nil {
+		h.rooms[questionId] = map[*chatConnection]struct{}{}
+	}
+	h.rooms[questionId][connection] = struct{}{}
+	h.lock.Unlock()
+
+	h.broadcastPresence(questionId)
+}
+
+func (h *chatHub) leave(questionId string, connection *chatConnection) {
+	h.lock.Lock()
+	delete(h.rooms[questionId], connection)
+	if len(h.rooms[questionId]) == 0 {
+		delete(h.rooms, questionId)
+	}
+	h.lock.Unlock()
+
+	h.broadcastPresence(questionId)
+}
+
+// Sends the message to every connection of the chat except the given one
+func (h *chatHub) broadcast(questionId string, message ChatMessage, except *chatConnection) {
+	for _, connection := range h.connections(questionId) {
+		if connection != except {
+			connection.send(message)
+		}
+	}
+}
+
+func (h *chatHub) broadcastPresence(questionId string) {
+	connections := h.connections(questionId)
+
+	// a participant connected from several devices is listed once
+	participants := []ChatParticipant{}
+	seen := map[string]bool{}
+	for _, connection := range connections {
+		if !seen[connection.participant.UserId] {
+			seen[connection.participant.UserId] = true
+			participants = append(participants, connection.participant)
+		}
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].UserId < participants[j].UserId
+	})
+
+	for _, connection := range connections {
+		connection.send(ChatMessage{Type: chatMessagePresence, Participants: participants})
+	}
+}
+
+// Snapshot of the connections, sending happens outside of the lock
+func (h *chatHub) connections(questionId string) []*chatConnection {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	connections := make([]*chatConnection, 0, len(h.rooms[questionId]))
+	for connection := range h.rooms[questionId] {
+		connections = append(connections, connection)
+	}
+	return connections
+}
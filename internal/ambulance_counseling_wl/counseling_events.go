@@ -0,0 +1,94 @@
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+const (
+	replyEventCreated = "reply.created"
+	replyEventUpdated = "reply.updated"
+	replyEventDeleted = "reply.deleted"
+)
+
+const (
+	questionEventCreated  = "question.created"
+	questionEventClosed   = "question.closed"
+	questionEventResolved = "question.resolved"
+	questionEventReopened = "question.reopened"
+)
+
+// questionStatusEvents names the event published when a question moves to the status
+var questionStatusEvents = map[string]string{
+	statusClosed:           questionEventClosed,
+	statusResolved:         questionEventResolved,
+	statusWaitingForDoctor: questionEventReopened,
+}
+
+const (
+	// changes of questions the user created or holds as the assigned doctor
+	eventScopeQuestions = "questions"
+	// changes of unclaimed questions in the categories the doctor handles
+	eventScopeQueue = "queue"
+)
+
+func newReplyEvent(eventType string, question *Question, reply *Reply) ReplyEvent {
+	return ReplyEvent{
+		Type:             eventType,
+		QuestionId:       question.Id,
+		Reply:            *reply,
+		PatientId:        question.PatientId,
+		AssignedDoctorId: question.AssignedDoctorId,
+		Category:         question.Category,
+		Summary:          question.Summary,
+	}
+}
+
+// Adds the reply event to the events written together with the question, call it after the change is applied
+func recordReplyEvent(question *Question, eventType string, reply *Reply) error {
+	id, err := generateRandomID()
+	if err != nil {
+		return err
+	}
+	event := newReplyEvent(eventType, question, reply)
+	event.Id = id
+	question.PendingEvents = append(question.PendingEvents, OutboxEvent{
+		Id:         id,
+		QuestionId: question.Id,
+		Type:       eventType,
+		ReplyEvent: &event,
+		CreatedAt:  time.Now(),
+	})
+	return nil
+}
+
+// Adds the question event to the events written together with the question, call it after the change is applied
+func recordQuestionEvent(question *Question, eventType string) error {
+	id, err := generateRandomID()
+	if err != nil {
+		return err
+	}
+	snapshot := *question
+	snapshot.EventIds = nil
+	snapshot.PendingEvents = nil
+	// the write storing the event increments the version
+	snapshot.Version = question.Version + 1
+	question.PendingEvents = append(question.PendingEvents, OutboxEvent{
+		Id:            id,
+		QuestionId:    question.Id,
+		Type:          eventType,
+		QuestionEvent: &QuestionEvent{Id: id, Type: eventType, Question: snapshot},
+		CreatedAt:     time.Now(),
+	})
+	return nil
+}
+
+// Helper function to check if a subscriber of the scope receives the event
+func receivesReplyEvent(scope string, userId string, specialties []string, event ReplyEvent) bool {
+	switch scope {
+	case eventScopeQuestions:
+		return event.PatientId == userId || event.AssignedDoctorId == userId
+	case eventScopeQueue:
+		return (event.AssignedDoctorId == "" || event.AssignedDoctorId == userId) && handlesCategory(specialties, event.Category)
+	}
+	return false
+}
@@ -3,22 +3,40 @@ package ambulance_counseling_wl
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/file_service"
 	"github.com/gin-gonic/gin"
 )
 
-// Helper function to check if user is a doctor
+// Helper function to check if user is a doctor whose credentials were approved
 func isDoctor(c *gin.Context) bool {
 	userType, exists := c.Get("userType")
 	if !exists {
 		return false
 	}
-	return userType == "doctor"
+	return userType == "doctor" && c.GetString("approvalStatus") == doctorApproved
+}
+
+// Helper function to format the signature of doctor replies, tokens issued
+// before names were part of the claims fall back to a generic label
+func doctorDisplayName(title string, name string) string {
+	displayName := strings.TrimSpace(title + " " + name)
+	if displayName == "" {
+		return "Doctor"
+	}
+	return displayName
 }
 
 // Helper function to check if user is creator of content
@@ -30,15 +48,153 @@ func isCreator(c *gin.Context, creatorId string) bool {
 	return userId == creatorId
 }
 
+const (
+	defaultQuestionPageSize = 20
+	maxQuestionPageSize     = 100
+)
+
+// Question has no bson tags, so the MongoDB driver stores its fields under lowercased names
+var questionSortFields = map[string]string{
+	"createdAt":   "createdat",
+	"lastUpdated": "lastupdated",
+}
+
+type questionCursor struct {
+	SortValue time.Time `json:"v"`
+	Id        string    `json:"id"`
+}
+
+func encodeQuestionCursor(question *Question, sortField string) string {
+	cursor := questionCursor{SortValue: question.CreatedAt, Id: question.Id}
+	if sortField == questionSortFields["lastUpdated"] {
+		cursor.SortValue = question.LastUpdated
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeQuestionCursor(value string) (*db_service.QueryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor questionCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &db_service.QueryCursor{SortValue: cursor.SortValue, Id: cursor.Id}, nil
+}
+
+// Translates the list query parameters of GetQuestions into a database query
+func parseQuestionQuery(c *gin.Context) (db_service.Query, error) {
+	query := db_service.Query{
+		SortField:      questionSortFields["createdAt"],
+		SortDescending: true,
+		Limit:          defaultQuestionPageSize,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxQuestionPageSize {
+			return query, fmt.Errorf("limit must be a number between 1 and %d", maxQuestionPageSize)
+		}
+		query.Limit = limit
+	}
+
+	if value := c.Query("sortBy"); value != "" {
+		field, ok := questionSortFields[value]
+		if !ok {
+			return query, fmt.Errorf("sortBy must be one of createdAt, lastUpdated")
+		}
+		query.SortField = field
+	}
+
+	switch c.DefaultQuery("sortOrder", "desc") {
+	case "asc":
+		query.SortDescending = false
+	case "desc":
+		query.SortDescending = true
+	default:
+		return query, fmt.Errorf("sortOrder must be one of asc, desc")
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeQuestionCursor(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor")
+		}
+		query.After = cursor
+	}
+
+	if value := c.Query("repliedTo"); value != "" {
+		repliedTo, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("repliedTo must be true or false")
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "repliedto", Operator: db_service.OpEq, Value: repliedTo})
+	}
+
+	if value := c.Query("status"); value != "" {
+		if _, ok := questionStatusTransitions[value]; !ok {
+			return query, fmt.Errorf("status must be one of waiting_for_doctor, waiting_for_patient, resolved, closed")
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "status", Operator: db_service.OpEq, Value: value})
+	}
+
+	if value := c.Query("category"); value != "" {
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "category", Operator: db_service.OpEq, Value: value})
+	}
+
+	if value := c.Query("patientId"); value != "" {
+		// patients list only their own questions, restrictQuestionQuery limits doctors
+		if !isDoctor(c) && value != c.GetString("userId") {
+			return query, fmt.Errorf("patientId must be your own user id")
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "patientid", Operator: db_service.OpEq, Value: value})
+	}
+
+	if value := c.Query("createdAfter"); value != "" {
+		createdAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("createdAfter must be an RFC 3339 timestamp")
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "createdat", Operator: db_service.OpGte, Value: createdAfter})
+	}
+
+	if value := c.Query("createdBefore"); value != "" {
+		createdBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("createdBefore must be an RFC 3339 timestamp")
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "createdat", Operator: db_service.OpLt, Value: createdBefore})
+	}
+
+	if value := c.Query("includeReplies"); value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return query, fmt.Errorf("includeReplies must be true or false")
+		}
+	}
+
+	return query, nil
+}
+
 type implAmbulanceCounselingAPI struct {
 	questionDbService db_service.DbService[Question]
 	replyDbService    db_service.DbService[Reply]
+	categoryDbService db_service.DbService[Category]
+	transactor        db_service.Transactor
+	fileStore         file_service.FileStore
+	outbox            *Outbox
 }
 
-func NewAmbulanceCounselingApi(questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply]) AmbulanceCounselingAPI {
+func NewAmbulanceCounselingApi(questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply], categoryDbService db_service.DbService[Category], transactor db_service.Transactor, fileStore file_service.FileStore, outbox *Outbox) AmbulanceCounselingAPI {
 	return &implAmbulanceCounselingAPI{
 		questionDbService: questionDbService,
 		replyDbService:    replyDbService,
+		categoryDbService: categoryDbService,
+		transactor:        transactor,
+		fileStore:         fileStore,
+		outbox:            outbox,
 	}
 }
 
@@ -57,20 +213,70 @@ func (o *implAmbulanceCounselingAPI) CreateQuestion(c *gin.Context) {
 		return
 	}
 
+	if question.Visibility == "" {
+		question.Visibility = visibilityPrivate
+	} else if !questionVisibilities[question.Visibility] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be one of private, public, anonymized"})
+		return
+	}
+
+	if question.Priority == "" {
+		question.Priority = priorityNormal
+	} else if _, ok := questionPriorities[question.Priority]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of low, normal, high, urgent"})
+		return
+	}
+
+	ctx := context.Background()
+	if question.Category == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category is required"})
+		return
+	}
+	if _, err := o.categoryDbService.FindDocument(ctx, question.Category); err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	id, err := o.generateDocumentID()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate question ID"})
 		return
 	}
 
+	// questions always belong to the patient who asked them
+	question.PatientId = c.GetString("userId")
 	question.Id = id
 	question.CreatedAt = time.Now()
 	question.LastUpdated = time.Now()
 	question.RepliedTo = false
 	question.Replies = []Reply{}
+	question.Attachments = nil
+	question.KnowledgeBaseArticleId = ""
+	question.TriagedBy = ""
+	question.WaitingSince = nil
+	releaseQuestion(&question)
+	question.Status = ""
+	question.StatusHistory = nil
+	if err := transitionQuestion(&question, statusWaitingForDoctor, question.CreatedAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create question"})
+		return
+	}
 
-	ctx := context.Background()
-	err = o.questionDbService.CreateDocument(ctx, question.Id, &question)
+	if err := recordQuestionEvent(&question, questionEventCreated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create question"})
+		return
+	}
+	// the created event is stored together with the question it announces
+	err = o.inTransaction(ctx, func(ctx context.Context) error {
+		if err := o.outbox.store(ctx, &question); err != nil {
+			return err
+		}
+		return o.questionDbService.CreateDocument(ctx, question.Id, &question)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create question"})
 		return
@@ -80,15 +286,109 @@ func (o *implAmbulanceCounselingAPI) CreateQuestion(c *gin.Context) {
 }
 
 func (o *implAmbulanceCounselingAPI) GetQuestions(c *gin.Context) {
-	ctx := context.Background()
+	query, err := parseQuestionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	restrictQuestionQuery(c, &query)
 
-	questions, err := o.questionDbService.FindAllDocuments(ctx)
+	// fetch one extra document to find out whether there is a next page
+	limit := query.Limit
+	query.Limit = limit + 1
+
+	ctx := context.Background()
+	questions, err := o.questionDbService.FindDocumentsByQuery(ctx, query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve questions"})
 		return
 	}
 
-	c.JSON(http.StatusOK, questions)
+	page := QuestionPage{Items: []Question{}}
+	if int64(len(questions)) > limit {
+		page.NextCursor = encodeQuestionCursor(questions[limit-1], query.SortField)
+		questions = questions[:limit]
+	}
+	// validated by parseQuestionQuery
+	if includeReplies, _ := strconv.ParseBool(c.Query("includeReplies")); includeReplies {
+		if err := o.loadReplies(ctx, questions...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve questions"})
+			return
+		}
+	}
+	for _, question := range questions {
+		redactQuestion(c, question)
+		page.Items = append(page.Items, *question)
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (o *implAmbulanceCounselingAPI) GetQueue(c *gin.Context) {
+	if !isDoctor(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can access the queue"})
+		return
+	}
+
+	limit := int64(defaultQuestionPageSize)
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxQuestionPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxQuestionPageSize)})
+			return
+		}
+		limit = parsed
+	}
+	cursor := &queueCursor{Rank: questionPriorities[priorityUrgent]}
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = decodeQueueCursor(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	// questions claimed by other doctors or outside the caller's specialties are left out,
+	// questions stored without a status have no replies and wait for a doctor
+	filters := []db_service.FieldFilter{
+		{Field: "status", Operator: db_service.OpIn, Value: []interface{}{statusWaitingForDoctor, "", nil}},
+	}
+	if specialties := c.GetStringSlice("userSpecialties"); len(specialties) > 0 {
+		filters = append(filters, db_service.FieldFilter{Field: "category", Operator: db_service.OpIn, Value: categoriesOf(specialties)})
+	}
+	claims := append(unclaimedFilters(time.Now()), db_service.FieldFilter{Field: "assigneddoctorid", Operator: db_service.OpEq, Value: c.GetString("userId")})
+
+	// the priorities are read one after another, each of them ordered by the waiting time,
+	// one extra question tells whether there is a next page
+	ctx := context.Background()
+	queue := []*Question{}
+	for rank := cursor.Rank; rank >= 0 && int64(len(queue)) <= limit; rank-- {
+		query := db_service.Query{
+			Filters:   append(slices.Clone(filters), db_service.FieldFilter{Field: "priority", Operator: db_service.OpIn, Value: prioritiesOfRank(rank)}),
+			AnyOf:     claims,
+			SortField: "waitingsince",
+			Limit:     limit + 1 - int64(len(queue)),
+		}
+		if rank == cursor.Rank && cursor.Id != "" {
+			query.After = &db_service.QueryCursor{SortValue: cursor.WaitingSince, Id: cursor.Id}
+		}
+		questions, err := o.questionDbService.FindDocumentsByQuery(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve questions"})
+			return
+		}
+		queue = append(queue, questions...)
+	}
+
+	page := QuestionPage{Items: []Question{}}
+	if int64(len(queue)) > limit {
+		page.NextCursor = encodeQueueCursor(queue[limit-1])
+		queue = queue[:limit]
+	}
+	for _, question := range queue {
+		page.Items = append(page.Items, *question)
+	}
+	c.JSON(http.StatusOK, page)
 }
 
 func (o *implAmbulanceCounselingAPI) GetQuestionById(c *gin.Context) {
@@ -99,7 +399,7 @@ func (o *implAmbulanceCounselingAPI) GetQuestionById(c *gin.Context) {
 	}
 
 	ctx := context.Background()
-	question, err := o.questionDbService.FindDocument(ctx, id)
+	question, err := o.findQuestion(ctx, id)
 	if err != nil {
 		if err == db_service.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
@@ -109,6 +409,14 @@ func (o *implAmbulanceCounselingAPI) GetQuestionById(c *gin.Context) {
 		return
 	}
 
+	// private questions are indistinguishable from missing ones
+	if !canReadQuestion(c, question) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	redactQuestion(c, question)
+
+	c.Header("ETag", versionETag(question.Version))
 	c.JSON(http.StatusOK, question)
 }
 
@@ -120,7 +428,7 @@ func (o *implAmbulanceCounselingAPI) UpdateQuestionById(c *gin.Context) {
 	}
 
 	ctx := context.Background()
-	existingQuestion, err := o.questionDbService.FindDocument(ctx, id)
+	existingQuestion, err := o.findQuestion(ctx, id)
 	if err != nil {
 		if err == db_service.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
@@ -141,6 +449,11 @@ func (o *implAmbulanceCounselingAPI) UpdateQuestionById(c *gin.Context) {
 		return
 	}
 
+	if !ifMatchSatisfied(c, existingQuestion.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
 	var updateData Question
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question data"})
@@ -151,12 +464,13 @@ func (o *implAmbulanceCounselingAPI) UpdateQuestionById(c *gin.Context) {
 	existingQuestion.Question = updateData.Question
 	existingQuestion.LastUpdated = time.Now()
 
-	err = o.questionDbService.UpdateDocument(ctx, id, existingQuestion)
+	err = o.questionDbService.UpdateDocumentIfVersion(ctx, id, existingQuestion.Version, existingQuestion)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update question"})
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question")
 		return
 	}
 
+	c.Header("ETag", versionETag(existingQuestion.Version))
 	c.JSON(http.StatusOK, existingQuestion)
 }
 
@@ -168,7 +482,7 @@ func (o *implAmbulanceCounselingAPI) DeleteQuestionById(c *gin.Context) {
 	}
 
 	ctx := context.Background()
-	question, err := o.questionDbService.FindDocument(ctx, id)
+	question, err := o.findQuestion(ctx, id)
 	if err != nil {
 		if err == db_service.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
@@ -184,27 +498,37 @@ func (o *implAmbulanceCounselingAPI) DeleteQuestionById(c *gin.Context) {
 		return
 	}
 
-	// First, delete all associated replies from the reply collection
-	deleteErrors := false
-	for _, reply := range question.Replies {
-		err = o.replyDbService.DeleteDocument(ctx, reply.Id)
-		if err != nil {
-			log.Printf("Failed to delete reply %s when deleting question %s: %v", reply.Id, id, err)
-			deleteErrors = true
-		}
+	if !ifMatchSatisfied(c, question.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
 	}
 
-	// Delete the question
-	err = o.questionDbService.DeleteDocument(ctx, id)
+	// the article would otherwise stay public with no way to withdraw it
+	if question.KnowledgeBaseArticleId != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Unpublish the question from the knowledge base first"})
+		return
+	}
+
+	// the question and its replies are deleted together
+	err = o.inTransaction(ctx, func(ctx context.Context) error {
+		for _, reply := range question.Replies {
+			if err := o.replyDbService.DeleteDocument(ctx, reply.Id); err != nil && err != db_service.ErrNotFound {
+				return err
+			}
+		}
+		return o.questionDbService.DeleteDocument(ctx, id)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete question"})
 		return
 	}
 
-	if deleteErrors {
-		c.JSON(http.StatusOK, gin.H{"message": "Question deleted, but some replies could not be deleted"})
-		return
+	// stored files are not part of the transaction, they are removed once it committed
+	attachments := question.Attachments
+	for _, reply := range question.Replies {
+		attachments = append(attachments, reply.Attachments...)
 	}
+	o.deleteAttachmentFiles(ctx, attachments)
 
 	c.Status(http.StatusNoContent)
 }
@@ -217,14 +541,13 @@ func (o *implAmbulanceCounselingAPI) ReplyToQuestion(c *gin.Context) {
 	}
 
 	// Check if user is authorized (must be a doctor or the creator of the question)
-	userId, exists := c.Get("userId")
-	if !exists {
+	if _, exists := c.Get("userId"); !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
 	ctx := context.Background()
-	question, err := o.questionDbService.FindDocument(ctx, id)
+	question, err := o.findQuestion(ctx, id)
 	if err != nil {
 		if err == db_service.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
@@ -240,59 +563,211 @@ func (o *implAmbulanceCounselingAPI) ReplyToQuestion(c *gin.Context) {
 		return
 	}
 
+	if !ifMatchSatisfied(c, question.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
+	if !isQuestionOpen(question) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Question is closed, reopen it to reply"})
+		return
+	}
+
+	// a claimed question is answered only by its doctor, unclaimed ones by any doctor
+	if assignee := activeAssignee(question, time.Now()); isDoctor(c) && assignee != "" && assignee != c.GetString("userId") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Question is assigned to another doctor"})
+		return
+	}
+
 	var reply Reply
 	if err := c.ShouldBindJSON(&reply); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply data"})
 		return
 	}
 
+	if err := o.addReply(ctx, c, question, &reply); err != nil {
+		switch {
+		case err == errInvalidStatusTransition:
+			c.JSON(http.StatusConflict, gin.H{"error": "Question is closed, reopen it to reply"})
+		case err == errAssignedToOtherDoctor:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Question is assigned to another doctor"})
+		case err == errUnknownParentReply:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent reply not found in the question"})
+		case errors.Is(err, errCreateReply):
+			log.Printf("Failed to create reply to question %s: %v", question.Id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reply"})
+		default:
+			writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question with reply")
+		}
+		return
+	}
+
+	c.Header("ETag", versionETag(reply.Version))
+	c.JSON(http.StatusCreated, reply)
+}
+
+var errCreateReply = errors.New("failed to create reply")
+var errUnknownParentReply = errors.New("parent reply does not belong to the question")
+
+// Stores the reply of the authenticated user and appends it to the question. Every reply is
+// written through here, whether it was posted to the REST API or sent over the chat.
+func (o *implAmbulanceCounselingAPI) addReply(ctx context.Context, c *gin.Context, question *Question, reply *Reply) error {
 	replyId, err := o.generateDocumentID()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply ID"})
-		return
+		return fmt.Errorf("%w: %w", errCreateReply, err)
 	}
 
 	reply.Id = replyId
-	reply.UserId = userId.(string)
+	reply.QuestionId = question.Id
+	reply.UserId = c.GetString("userId")
 	reply.CreatedAt = time.Now()
 	reply.RepliedTo = false
+	reply.Attachments = nil
 
-	// If replier is a doctor, add their name
+	// If replier is a doctor, sign the reply with their name from the JWT
 	if isDoctor(c) {
-		// Use the user's name from the JWT if available, or a default
-		doctorName, exists := c.Get("userName")
-		if exists {
-			reply.DoctorName = doctorName.(string)
-		} else {
-			reply.DoctorName = "Doctor"
+		reply.DoctorName = doctorDisplayName(c.GetString("userTitle"), c.GetString("userName"))
+		reply.DoctorSpecialty = c.GetString("userSpecialty")
+	}
+
+	// Without If-Match the reply is appended to whatever the latest version of the question is
+	expectedVersion := question.Version
+	return o.inTransaction(ctx, func(ctx context.Context) error {
+		// the answered reply can no longer change
+		if reply.ParentReplyId != "" {
+			if err := o.lockReply(ctx, question.Id, reply.ParentReplyId); err != nil {
+				return err
+			}
+		}
+		// the cause keeps the labels the transactor retries by
+		if err := o.replyDbService.CreateDocument(ctx, reply.Id, reply); err != nil {
+			return fmt.Errorf("%w: %w", errCreateReply, err)
+		}
+		_, err := o.updateQuestion(ctx, question.Id, func(question *Question) error {
+			if hasIfMatch(c) && question.Version != expectedVersion {
+				return errPreconditionFailed
+			}
+
+			// closed questions are reopened explicitly, never by a reply
+			if !isQuestionOpen(question) {
+				return errInvalidStatusTransition
+			}
+
+			// the question now waits for the other side of the conversation
+			nextStatus := statusWaitingForDoctor
+			if isDoctor(c) {
+				nextStatus = statusWaitingForPatient
+			}
+			if err := transitionQuestion(question, nextStatus, reply.CreatedAt); err != nil {
+				return err
+			}
+
+			// replying claims the question for the doctor and keeps the claim alive
+			if isDoctor(c) {
+				if assignee := activeAssignee(question, reply.CreatedAt); assignee != "" && assignee != reply.UserId {
+					return errAssignedToOtherDoctor
+				}
+				assignQuestion(question, reply.UserId, reply.CreatedAt)
+			}
+
+			// Set repliedTo flag on the question
+			question.RepliedTo = true
+			question.LastUpdated = time.Now()
+			return recordReplyEvent(question, replyEventCreated, reply)
+		})
+		return err
+	})
+}
+
+func (o *implAmbulanceCounselingAPI) CloseQuestion(c *gin.Context) {
+	var form QuestionCloseForm
+	// an empty body closes the question unresolved
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid close data"})
+			return
 		}
 	}
 
-	err = o.replyDbService.CreateDocument(ctx, reply.Id, &reply)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reply"})
+	status := statusClosed
+	if form.Resolved {
+		status = statusResolved
+	}
+	o.changeQuestionStatus(c, status)
+}
+
+func (o *implAmbulanceCounselingAPI) ReopenQuestion(c *gin.Context) {
+	o.changeQuestionStatus(c, statusWaitingForDoctor)
+}
+
+// Moves the question from the path to the status on behalf of the patient or a doctor
+func (o *implAmbulanceCounselingAPI) changeQuestionStatus(c *gin.Context, status string) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
 		return
 	}
 
-	// Set repliedTo flag on the question
-	question.RepliedTo = true
-	question.LastUpdated = time.Now()
+	ctx := context.Background()
+	question, err := o.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
-	// Mark previous replies as replied to (for conversation threading)
-	for i := range question.Replies {
-		question.Replies[i].RepliedTo = true
+	if !isDoctor(c) && !isCreator(c, question.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can change the status"})
+		return
 	}
 
-	// Add the new reply
-	question.Replies = append(question.Replies, reply)
+	if !ifMatchSatisfied(c, question.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
 
-	err = o.questionDbService.UpdateDocument(ctx, id, question)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update question with reply"})
+	expectedVersion := question.Version
+	previousStatus := currentQuestionStatus(question)
+	question, err = o.updateQuestion(ctx, id, func(question *Question) error {
+		if hasIfMatch(c) && question.Version != expectedVersion {
+			return errPreconditionFailed
+		}
+		previousStatus = currentQuestionStatus(question)
+		// reopening an open question or closing a closed one is not a transition
+		if status == statusWaitingForDoctor && isQuestionOpen(question) {
+			return errInvalidStatusTransition
+		}
+		if status == statusResolved && !hasDoctorReply(question) {
+			return errNotAnswered
+		}
+		if err := transitionQuestion(question, status, time.Now()); err != nil {
+			return err
+		}
+		// finished conversations no longer hold a doctor
+		if !isQuestionOpen(question) {
+			releaseQuestion(question)
+		}
+		question.LastUpdated = time.Now()
+		return recordQuestionEvent(question, questionStatusEvents[status])
+	})
+	switch err {
+	case nil:
+	case errInvalidStatusTransition:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Question cannot move from %s to %s", previousStatus, status)})
+		return
+	case errNotAnswered:
+		c.JSON(http.StatusConflict, gin.H{"error": "Only questions answered by a doctor can be resolved"})
+		return
+	default:
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question")
 		return
 	}
 
-	c.JSON(http.StatusCreated, reply)
+	c.Header("ETag", versionETag(question.Version))
+	c.JSON(http.StatusOK, question)
 }
 
 func (o *implAmbulanceCounselingAPI) GetRepliesByQuestionId(c *gin.Context) {
@@ -302,8 +777,14 @@ func (o *implAmbulanceCounselingAPI) GetRepliesByQuestionId(c *gin.Context) {
 		return
 	}
 
+	format := c.DefaultQuery("format", replyFormatTree)
+	if format != replyFormatTree && format != replyFormatFlat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be tree or flat"})
+		return
+	}
+
 	ctx := context.Background()
-	question, err := o.questionDbService.FindDocument(ctx, questionId)
+	question, err := o.findQuestion(ctx, questionId)
 	if err != nil {
 		if err == db_service.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
@@ -313,7 +794,17 @@ func (o *implAmbulanceCounselingAPI) GetRepliesByQuestionId(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, question.Replies)
+	if !canReadQuestion(c, question) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	redactQuestion(c, question)
+
+	if format == replyFormatFlat {
+		c.JSON(http.StatusOK, question.Replies)
+		return
+	}
+	c.JSON(http.StatusOK, buildReplyThreads(question.Replies))
 }
 
 func (o *implAmbulanceCounselingAPI) GetReplyById(c *gin.Context) {
@@ -334,9 +825,139 @@ func (o *implAmbulanceCounselingAPI) GetReplyById(c *gin.Context) {
 		return
 	}
 
+	// the reply is readable with the question it belongs to
+	question, err := o.questionDbService.FindDocument(ctx, reply.QuestionId)
+	if err != nil && err != db_service.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err == db_service.ErrNotFound {
+		if !isDoctor(c) && !isCreator(c, reply.UserId) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reply not found"})
+			return
+		}
+	} else {
+		if !canReadQuestion(c, question) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reply not found"})
+			return
+		}
+		question.Replies = []Reply{*reply}
+		redactQuestion(c, question)
+		reply = &question.Replies[0]
+	}
+
+	c.Header("ETag", versionETag(reply.Version))
 	c.JSON(http.StatusOK, reply)
 }
 
+func (o *implAmbulanceCounselingAPI) UpdateQuestionPriority(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	var form QuestionPriorityForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority data"})
+		return
+	}
+	if _, ok := questionPriorities[form.Priority]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of low, normal, high, urgent"})
+		return
+	}
+
+	ctx := context.Background()
+	existingQuestion, err := o.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !isDoctor(c) && !isCreator(c, existingQuestion.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can change the priority"})
+		return
+	}
+
+	// the triage of a doctor overrides the estimate of the patient
+	if !isDoctor(c) && existingQuestion.TriagedBy != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Priority has been set by a doctor"})
+		return
+	}
+
+	if !ifMatchSatisfied(c, existingQuestion.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
+	existingQuestion.Priority = form.Priority
+	if isDoctor(c) {
+		existingQuestion.TriagedBy = c.GetString("userId")
+	}
+	existingQuestion.LastUpdated = time.Now()
+
+	err = o.questionDbService.UpdateDocumentIfVersion(ctx, id, existingQuestion.Version, existingQuestion)
+	if err != nil {
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question")
+		return
+	}
+
+	c.Header("ETag", versionETag(existingQuestion.Version))
+	c.JSON(http.StatusOK, existingQuestion)
+}
+
+func (o *implAmbulanceCounselingAPI) UpdateQuestionVisibility(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	var form QuestionVisibilityForm
+	if err := c.ShouldBindJSON(&form); err != nil || !questionVisibilities[form.Visibility] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be one of private, public, anonymized"})
+		return
+	}
+
+	ctx := context.Background()
+	existingQuestion, err := o.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// unlike the content, visibility can be changed after the question was answered
+	if !isCreator(c, existingQuestion.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can change visibility of this question"})
+		return
+	}
+
+	if !ifMatchSatisfied(c, existingQuestion.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
+	existingQuestion.Visibility = form.Visibility
+	existingQuestion.LastUpdated = time.Now()
+
+	err = o.questionDbService.UpdateDocumentIfVersion(ctx, id, existingQuestion.Version, existingQuestion)
+	if err != nil {
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question")
+		return
+	}
+
+	c.Header("ETag", versionETag(existingQuestion.Version))
+	c.JSON(http.StatusOK, existingQuestion)
+}
+
 func (o *implAmbulanceCounselingAPI) UpdateReplyById(c *gin.Context) {
 	replyId := c.Param("replyId")
 	if replyId == "" {
@@ -366,6 +987,11 @@ func (o *implAmbulanceCounselingAPI) UpdateReplyById(c *gin.Context) {
 		return
 	}
 
+	if !ifMatchSatisfied(c, existingReply.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Reply has been modified"})
+		return
+	}
+
 	var updateData Reply
 	if err := c.ShouldBindJSON(&updateData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply data"})
@@ -374,28 +1000,27 @@ func (o *implAmbulanceCounselingAPI) UpdateReplyById(c *gin.Context) {
 
 	existingReply.Text = updateData.Text
 
-	err = o.replyDbService.UpdateDocument(ctx, replyId, existingReply)
+	// the reply and the question write committing its event change together
+	expectedVersion := existingReply.Version
+	err = o.inTransaction(ctx, func(ctx context.Context) error {
+		existingReply.Version = expectedVersion
+		if err := o.replyDbService.UpdateDocumentIfVersion(ctx, replyId, expectedVersion, existingReply); err != nil {
+			return err
+		}
+		_, err := o.updateQuestion(ctx, existingReply.QuestionId, func(question *Question) error {
+			return recordReplyEvent(question, replyEventUpdated, existingReply)
+		})
+		if err != nil && err != db_service.ErrNotFound {
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reply"})
+		writeVersionedUpdateError(c, err, "Reply has been modified", "Failed to update reply")
 		return
 	}
 
-	questions, err := o.questionDbService.FindDocumentsByField(ctx, "replies.id", replyId)
-	if err == nil && len(questions) > 0 {
-		for _, question := range questions {
-			for i, reply := range question.Replies {
-				if reply.Id == replyId {
-					question.Replies[i] = *existingReply
-					err = o.questionDbService.UpdateDocument(ctx, question.Id, question)
-					if err != nil {
-						log.Printf("Failed to update parent question %s: %v", question.Id, err)
-					}
-					break
-				}
-			}
-		}
-	}
-
+	c.Header("ETag", versionETag(existingReply.Version))
 	c.JSON(http.StatusOK, existingReply)
 }
 
@@ -428,31 +1053,180 @@ func (o *implAmbulanceCounselingAPI) DeleteReplyById(c *gin.Context) {
 		return
 	}
 
-	questions, err := o.questionDbService.FindDocumentsByField(ctx, "replies.id", replyId)
+	if !ifMatchSatisfied(c, existingReply.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Reply has been modified"})
+		return
+	}
 
-	err = o.replyDbService.DeleteDocument(ctx, replyId)
+	// the reply and the question write committing its event change together
+	err = o.inTransaction(ctx, func(ctx context.Context) error {
+		if err := o.replyDbService.DeleteDocument(ctx, replyId); err != nil {
+			return err
+		}
+		_, err := o.updateQuestion(ctx, existingReply.QuestionId, func(question *Question) error {
+			return recordReplyEvent(question, replyEventDeleted, existingReply)
+		})
+		if err != nil && err != db_service.ErrNotFound {
+			return err
+		}
+		return nil
+	})
 	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reply not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reply"})
 		return
 	}
 
-	if err == nil && len(questions) > 0 {
-		for _, question := range questions {
-			var updatedReplies []Reply
-			for _, reply := range question.Replies {
-				if reply.Id != replyId {
-					updatedReplies = append(updatedReplies, reply)
-				}
-			}
+	o.deleteAttachmentFiles(ctx, existingReply.Attachments)
 
-			question.Replies = updatedReplies
+	c.Status(http.StatusNoContent)
+}
 
-			err = o.questionDbService.UpdateDocument(ctx, question.Id, question)
-			if err != nil {
-				log.Printf("Failed to update parent question %s after reply deletion: %v", question.Id, err)
-			}
+// Removes the stored files of deleted attachments, files that cannot be removed are only logged
+func (o *implAmbulanceCounselingAPI) deleteAttachmentFiles(ctx context.Context, attachments []Attachment) {
+	for _, attachment := range attachments {
+		if err := o.fileStore.DeleteFile(ctx, attachment.Id); err != nil && err != file_service.ErrNotFound {
+			log.Printf("Failed to delete file of attachment %s: %v", attachment.Id, err)
 		}
 	}
+}
 
-	c.Status(http.StatusNoContent)
+// Re-signs all replies of the doctor
+func (o *implAmbulanceCounselingAPI) updateReplyAuthor(ctx context.Context, userId string, doctorName string, doctorSpecialty string) error {
+	replies, err := o.replyDbService.FindDocumentsByField(ctx, "userid", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if reply.DoctorName == "" {
+			// written before the author became a doctor
+			continue
+		}
+		reply.DoctorName = doctorName
+		reply.DoctorSpecialty = doctorSpecialty
+		if err := o.replyDbService.UpdateDocument(ctx, reply.Id, reply); err != nil && err != db_service.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the question together with its replies
+func (o *implAmbulanceCounselingAPI) findQuestion(ctx context.Context, id string) (*Question, error) {
+	question, err := o.questionDbService.FindDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.loadReplies(ctx, question); err != nil {
+		return nil, err
+	}
+	return question, nil
+}
+
+// Fills in the replies of the questions, oldest first, with a single query of the reply collection
+func (o *implAmbulanceCounselingAPI) loadReplies(ctx context.Context, questions ...*Question) error {
+	if len(questions) == 0 {
+		return nil
+	}
+
+	// a single lookup served by the index of replies by question
+	ids := []string{}
+	byId := map[string]*Question{}
+	for _, question := range questions {
+		question.Replies = []Reply{}
+		byId[question.Id] = question
+		ids = append(ids, question.Id)
+	}
+
+	replies, err := o.replyDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters:   []db_service.FieldFilter{{Field: "questionid", Operator: db_service.OpIn, Value: ids}},
+		SortField: "createdat",
+	})
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if question, ok := byId[reply.QuestionId]; ok {
+			question.Replies = append(question.Replies, *reply)
+		}
+	}
+	return nil
+}
+
+// Locks the reply of the question against edits once another reply answers it
+func (o *implAmbulanceCounselingAPI) lockReply(ctx context.Context, questionId string, replyId string) error {
+	reply, err := o.replyDbService.FindDocument(ctx, replyId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			return errUnknownParentReply
+		}
+		return err
+	}
+	if reply.QuestionId != questionId {
+		return errUnknownParentReply
+	}
+	if reply.RepliedTo {
+		return nil
+	}
+	reply.RepliedTo = true
+	return o.replyDbService.UpdateDocumentIfVersion(ctx, reply.Id, reply.Version, reply)
+}
+
+// Runs the writes of fn as one unit of work, APIs reusing the helpers of the counseling API
+// without a transactor run them directly
+func (o *implAmbulanceCounselingAPI) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if o.transactor == nil {
+		return fn(ctx)
+	}
+	if err := o.transactor.WithTransaction(ctx, fn); err != nil {
+		return err
+	}
+	// events stored by the unit of work become visible to the dispatcher once it committed
+	o.outbox.notify()
+	return nil
+}
+
+// Re-reads the question and re-applies the change until the versioned update succeeds.
+// Events recorded by the change are stored in the outbox and committed by the same update.
+func (o *implAmbulanceCounselingAPI) updateQuestion(ctx context.Context, id string, apply func(question *Question) error) (*Question, error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		question, err := o.findQuestion(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := apply(question); err != nil {
+			return nil, err
+		}
+		if err := o.outbox.store(ctx, question); err != nil {
+			return nil, err
+		}
+		err = o.questionDbService.UpdateDocumentIfVersion(ctx, id, question.Version, question)
+		if err != nil {
+			o.outbox.discard(ctx, question)
+		} else {
+			o.outbox.notify()
+		}
+		if err != db_service.ErrVersionMismatch {
+			return question, err
+		}
+	}
+	return nil, db_service.ErrVersionMismatch
+}
+
+// Maps errors of versioned updates to responses - 412 for failed If-Match, 409 for lost races
+func writeVersionedUpdateError(c *gin.Context, err error, conflictMessage string, failureMessage string) {
+	switch {
+	case err == errPreconditionFailed || (err == db_service.ErrVersionMismatch && hasIfMatch(c)):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": conflictMessage})
+	case err == db_service.ErrVersionMismatch:
+		c.JSON(http.StatusConflict, gin.H{"error": conflictMessage})
+	case err == db_service.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failureMessage})
+	}
 }
@@ -0,0 +1,371 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+var userTypes = map[string]bool{
+	"patient": true,
+	"doctor":  true,
+	"admin":   true,
+}
+
+type implAmbulanceCounselingAdminAPI struct {
+	userAccounts
+}
+
+func NewAmbulanceCounselingAdminApi(userDbService db_service.DbService[User], refreshTokenDbService db_service.DbService[RefreshToken], mailer mail_service.Mailer) AmbulanceCounselingAdminAPI {
+	return &implAmbulanceCounselingAdminAPI{
+		userAccounts: userAccounts{
+			userDbService:         userDbService,
+			refreshTokenDbService: refreshTokenDbService,
+			mailer:                mailer,
+		},
+	}
+}
+
+// BootstrapAdmin makes sure an administrator exists. When there is none yet, the account
+// with the given email is promoted or, if it does not exist, created with the given password.
+func BootstrapAdmin(ctx context.Context, userDbService db_service.DbService[User], email string, password string) error {
+	admins, err := userDbService.FindDocumentsByField(ctx, "type", "admin")
+	if err != nil {
+		return err
+	}
+	if len(admins) > 0 {
+		return nil
+	}
+
+	email = strings.ToLower(email)
+	users, err := userDbService.FindDocumentsByField(ctx, "email", email)
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		user := users[0]
+		user.Type = "admin"
+		user.ApprovalStatus = ""
+		user.EmailVerified = true
+		user.Disabled = false
+		log.Printf("Promoting user %s to the first administrator", user.Id)
+		return userDbService.UpdateDocument(ctx, user.Id, user)
+	}
+
+	if password == "" {
+		return fmt.Errorf("password is required to create the first administrator %s", email)
+	}
+
+	id, err := generateRandomID()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user := User{
+		Id:            id,
+		Name:          "Administrator",
+		Email:         email,
+		Type:          "admin",
+		EmailVerified: true,
+		PasswordHash:  hashedPassword,
+	}
+	log.Printf("Creating the first administrator %s", email)
+	return userDbService.CreateDocument(ctx, user.Id, &user)
+}
+
+type userCursor struct {
+	Email string `json:"v"`
+	Id    string `json:"id"`
+}
+
+func encodeUserCursor(user *User) string {
+	data, _ := json.Marshal(userCursor{Email: user.Email, Id: user.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(value string) (*db_service.QueryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &db_service.QueryCursor{SortValue: cursor.Email, Id: cursor.Id}, nil
+}
+
+func (o *implAmbulanceCounselingAdminAPI) GetUsers(c *gin.Context) {
+	query := db_service.Query{
+		SortField: "email",
+		Limit:     defaultUserPageSize,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxUserPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxUserPageSize)})
+			return
+		}
+		query.Limit = limit
+	}
+
+	if value := c.Query("type"); value != "" {
+		if !userTypes[value] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of patient, doctor, admin"})
+			return
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "type", Operator: db_service.OpEq, Value: value})
+	}
+
+	if value := c.Query("disabled"); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "disabled must be true or false"})
+			return
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "disabled", Operator: db_service.OpEq, Value: disabled})
+	}
+
+	if value := strings.TrimSpace(c.Query("search")); value != "" {
+		pattern := "(?i)" + regexp.QuoteMeta(value)
+		query.AnyOf = []db_service.FieldFilter{
+			{Field: "name", Operator: db_service.OpRegex, Value: pattern},
+			{Field: "email", Operator: db_service.OpRegex, Value: pattern},
+		}
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeUserCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		query.After = cursor
+	}
+
+	// fetch one extra document to find out whether there is a next page
+	limit := query.Limit
+	query.Limit = limit + 1
+
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByQuery(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+
+	page := UserPage{Items: []User{}}
+	if int64(len(users)) > limit {
+		page.NextCursor = encodeUserCursor(users[limit-1])
+		users = users[:limit]
+	}
+	for _, user := range users {
+		page.Items = append(page.Items, *user)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (o *implAmbulanceCounselingAdminAPI) ChangeUserType(c *gin.Context) {
+	var form UserTypeForm
+	if err := c.ShouldBindJSON(&form); err != nil || !userTypes[form.Type] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of patient, doctor, admin"})
+		return
+	}
+
+	o.updateUser(c, func(user *User) error {
+		if isCreator(c, user.Id) && form.Type != "admin" {
+			return errOwnAccount
+		}
+		user.Type = form.Type
+		// doctors appointed by an administrator need no further verification
+		if user.Type == "doctor" {
+			user.ApprovalStatus = doctorApproved
+		} else {
+			user.ApprovalStatus = ""
+			user.Specialties = nil
+		}
+		return nil
+	})
+}
+
+func (o *implAmbulanceCounselingAdminAPI) DisableUser(c *gin.Context) {
+	o.updateUser(c, func(user *User) error {
+		if isCreator(c, user.Id) {
+			return errOwnAccount
+		}
+		user.Disabled = true
+		return nil
+	})
+}
+
+func (o *implAmbulanceCounselingAdminAPI) EnableUser(c *gin.Context) {
+	o.updateUser(c, func(user *User) error {
+		user.Disabled = false
+		return nil
+	})
+}
+
+func (o *implAmbulanceCounselingAdminAPI) GetPendingDoctors(c *gin.Context) {
+	query := db_service.Query{
+		Filters: []db_service.FieldFilter{
+			{Field: "type", Operator: db_service.OpEq, Value: "doctor"},
+			{Field: "approvalStatus", Operator: db_service.OpEq, Value: doctorPending},
+		},
+		SortField: "email",
+	}
+
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByQuery(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve doctors"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (o *implAmbulanceCounselingAdminAPI) ApproveDoctor(c *gin.Context) {
+	user := o.updateUser(c, func(user *User) error {
+		if user.Type != "doctor" || user.ApprovalStatus == doctorApproved {
+			return errNotAwaitingApproval
+		}
+		user.ApprovalStatus = doctorApproved
+		user.RejectionReason = ""
+		return nil
+	})
+	o.notifyApprovalDecision(user)
+}
+
+func (o *implAmbulanceCounselingAdminAPI) RejectDoctor(c *gin.Context) {
+	var form DoctorRejectionForm
+	// the reason is optional, an empty body is accepted
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rejection data"})
+			return
+		}
+	}
+
+	user := o.updateUser(c, func(user *User) error {
+		if user.Type != "doctor" || user.ApprovalStatus != doctorPending {
+			return errNotAwaitingApproval
+		}
+		user.ApprovalStatus = doctorRejected
+		user.RejectionReason = strings.TrimSpace(form.Reason)
+		return nil
+	})
+	o.notifyApprovalDecision(user)
+}
+
+// The decision is already stored, a failed email is only logged
+func (o *implAmbulanceCounselingAdminAPI) notifyApprovalDecision(user *User) {
+	if user == nil {
+		return
+	}
+	if err := o.sendApprovalDecision(context.Background(), user); err != nil {
+		log.Printf("Failed to send approval decision to user %s: %v", user.Id, err)
+	}
+}
+
+func (o *implAmbulanceCounselingAdminAPI) ResetUserPassword(c *gin.Context) {
+	userId := c.Param("userId")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	user, err := o.userDbService.FindDocument(ctx, userId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := o.sendPasswordReset(ctx, user); err != nil {
+		log.Printf("Failed to send password reset to user %s: %v", user.Id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Password reset email has been sent"})
+}
+
+var errOwnAccount = fmt.Errorf("administrators cannot demote or disable their own account")
+var errNotAwaitingApproval = fmt.Errorf("user is not a doctor awaiting approval")
+
+// Applies an administrative change to the user from the path and ends their sessions,
+// so that new tokens carry the changed claims. Returns the updated user, or nil when
+// an error response was written.
+func (o *implAmbulanceCounselingAdminAPI) updateUser(c *gin.Context, apply func(user *User) error) *User {
+	userId := c.Param("userId")
+	if userId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return nil
+	}
+
+	ctx := context.Background()
+	user, err := o.userDbService.FindDocument(ctx, userId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil
+	}
+
+	if err := apply(user); err != nil {
+		writeUserUpdateError(c, err)
+		return nil
+	}
+
+	err = o.userDbService.UpdateDocument(ctx, user.Id, user)
+	if err != nil {
+		writeUserUpdateError(c, err)
+		return nil
+	}
+
+	o.revokeUserSessions(ctx, user.Id)
+
+	c.JSON(http.StatusOK, user)
+	return user
+}
+
+// Maps errors of administrative changes to responses - 400 for refused changes, 404 for users deleted meanwhile
+func writeUserUpdateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errOwnAccount) || errors.Is(err, errNotAwaitingApproval):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, db_service.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		log.Printf("Failed to update user %s: %v", c.Param("userId"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	}
+}
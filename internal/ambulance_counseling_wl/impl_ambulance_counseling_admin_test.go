@@ -0,0 +1,250 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Registers a doctor and verifies their email, returns the created account
+func (s *testServer) registerDoctor(email string) User {
+	s.t.Helper()
+	form := RegistrationForm{
+		Name:          "Dr. Quinn",
+		Email:         email,
+		Password:      testPassword,
+		Type:          "doctor",
+		LicenseNumber: "SK-12345",
+		Specialty:     "General practice",
+		Workplace:     "City Hospital",
+	}
+	recorder := s.do(http.MethodPost, "/register", nil, form)
+	expectStatus(s.t, recorder, http.StatusCreated)
+	var user User
+	if err := json.Unmarshal(recorder.Body.Bytes(), &user); err != nil {
+		s.t.Fatalf("invalid response: %v", err)
+	}
+
+	match := regexp.MustCompile(`token=([0-9a-f]+)`).FindStringSubmatch(s.mailer.messages[len(s.mailer.messages)-1].Body)
+	if match == nil {
+		s.t.Fatal("verification token missing in email")
+	}
+	expectStatus(s.t, s.do(http.MethodGet, "/verify-email?token="+match[1], nil, nil), http.StatusOK)
+	return user
+}
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodGet, "/admin/users", nil, nil), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodGet, "/admin/users", testPatient, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodGet, "/admin/users", testDoctor, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testStranger.Id+"/disable", testPatient, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodGet, "/admin/users", testAdmin, nil), http.StatusOK)
+}
+
+func TestGetUsers(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.do(http.MethodGet, "/admin/users?type=patient&search=JOHN", testAdmin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var page UserPage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Id != testStranger.Id || page.NextCursor != "" {
+		t.Fatalf("expected only %s, got %+v", testStranger.Id, page)
+	}
+	if page.Items[0].PasswordHash != "" {
+		t.Error("password hash must not be exposed")
+	}
+
+	expectStatus(t, server.do(http.MethodGet, "/admin/users?type=nurse", testAdmin, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodGet, "/admin/users?limit=0", testAdmin, nil), http.StatusBadRequest)
+}
+
+func TestGetUsersPagination(t *testing.T) {
+	server := newTestServer(t)
+
+	var emails []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("expected the accounts on 3 pages, got %v so far", emails)
+		}
+		recorder := server.do(http.MethodGet, "/admin/users?limit=2&cursor="+cursor, testAdmin, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		var page UserPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if len(page.Items) > 2 {
+			t.Fatalf("expected at most 2 users on a page, got %d", len(page.Items))
+		}
+		for _, user := range page.Items {
+			emails = append(emails, user.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	// every seeded account is listed once, ordered by email address
+	if len(emails) != 5 || !slices.IsSorted(emails) || len(slices.Compact(slices.Clone(emails))) != 5 {
+		t.Errorf("expected the 5 accounts ordered by email, got %v", emails)
+	}
+
+	expectStatus(t, server.do(http.MethodGet, "/admin/users?cursor=invalid", testAdmin, nil), http.StatusBadRequest)
+}
+
+func TestChangeUserType(t *testing.T) {
+	server := newTestServer(t)
+	tokens := server.login(testPatient.Email, testPassword)
+
+	recorder := server.do(http.MethodPut, "/admin/users/"+testPatient.Id+"/type", testAdmin, UserTypeForm{Type: "doctor"})
+	expectStatus(t, recorder, http.StatusOK)
+
+	// the old token still claims the patient role
+	expectStatus(t, server.send(http.MethodPost, "/questions/new", tokens.Token, Question{PatientId: testPatient.Id}), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+
+	claims, err := ParseJWT(server.login(testPatient.Email, testPassword).Token)
+	if err != nil || claims.UserType != "doctor" {
+		t.Fatalf("expected doctor claims, got %+v: %v", claims, err)
+	}
+
+	expectStatus(t, server.do(http.MethodPut, "/admin/users/"+testPatient.Id+"/type", testAdmin, UserTypeForm{Type: "nurse"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/admin/users/"+testAdmin.Id+"/type", testAdmin, UserTypeForm{Type: "patient"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/admin/users/unknown/type", testAdmin, UserTypeForm{Type: "doctor"}), http.StatusNotFound)
+}
+
+func TestDisableUser(t *testing.T) {
+	server := newTestServer(t)
+	tokens := server.login(testPatient.Email, testPassword)
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testAdmin.Id+"/disable", testAdmin, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testPatient.Id+"/disable", testAdmin, nil), http.StatusOK)
+
+	expectStatus(t, server.send(http.MethodPost, "/questions/new", tokens.Token, Question{PatientId: testPatient.Id}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: testPatient.Email, Password: testPassword}), http.StatusForbidden)
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testPatient.Id+"/enable", testAdmin, nil), http.StatusOK)
+	server.login(testPatient.Email, testPassword)
+}
+
+func TestUpdateUserErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/nobody/disable", testAdmin, nil), http.StatusNotFound)
+	expectStatus(t, server.do(http.MethodPost, "/admin/doctors/"+testPatient.Id+"/approve", testAdmin, nil), http.StatusBadRequest)
+
+	// storage failures are not the fault of the request
+	server.userFaults.failUpdates = true
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testPatient.Id+"/disable", testAdmin, nil), http.StatusInternalServerError)
+	if user, _ := server.userDbService.FindDocument(context.Background(), testPatient.Id); user.Disabled {
+		t.Error("failed update must not disable the user")
+	}
+}
+
+func TestResetUserPassword(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/users/"+testPatient.Id+"/password-reset", testAdmin, nil), http.StatusAccepted)
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].To != testPatient.Email {
+		t.Fatalf("expected reset email to %s, got %+v", testPatient.Email, server.mailer.messages)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	// an administrator already exists
+	if err := BootstrapAdmin(ctx, server.userDbService, testPatient.Email, ""); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	if user, _ := server.userDbService.FindDocument(ctx, testPatient.Id); user.Type != "patient" {
+		t.Fatalf("patient must not be promoted while an administrator exists, got %v", user.Type)
+	}
+
+	if err := server.userDbService.DeleteDocument(ctx, testAdmin.Id); err != nil {
+		t.Fatalf("failed to delete administrator: %v", err)
+	}
+	if err := BootstrapAdmin(ctx, server.userDbService, testPatient.Email, ""); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	if user, _ := server.userDbService.FindDocument(ctx, testPatient.Id); user.Type != "admin" {
+		t.Fatalf("expected promoted administrator, got %v", user.Type)
+	}
+}
+
+func TestDoctorRegistrationRequiresCredentials(t *testing.T) {
+	server := newTestServer(t)
+
+	form := RegistrationForm{Name: "Dr. Quinn", Email: "quinn@example.com", Password: testPassword, Type: "doctor", Specialty: "General practice"}
+	expectStatus(t, server.do(http.MethodPost, "/register", nil, form), http.StatusBadRequest)
+	form.Type = "admin"
+	expectStatus(t, server.do(http.MethodPost, "/register", nil, form), http.StatusBadRequest)
+}
+
+func TestApproveDoctor(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	doctor := server.registerDoctor("quinn@example.com")
+	if doctor.Type != "doctor" || doctor.ApprovalStatus != doctorPending {
+		t.Fatalf("expected pending doctor, got %+v", doctor)
+	}
+
+	pending := server.login(doctor.Email, testPassword)
+	expectStatus(t, server.send(http.MethodPost, "/questions/question-1/reply", pending.Token, Reply{Text: "Drink water."}), http.StatusForbidden)
+
+	recorder := server.do(http.MethodGet, "/admin/doctors/pending", testAdmin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var doctors []User
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doctors); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(doctors) != 1 || doctors[0].Id != doctor.Id || doctors[0].LicenseNumber != "SK-12345" {
+		t.Fatalf("expected pending doctor %s, got %+v", doctor.Id, doctors)
+	}
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/doctors/"+testPatient.Id+"/approve", testAdmin, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/admin/doctors/"+doctor.Id+"/approve", testAdmin, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodPost, "/admin/doctors/"+doctor.Id+"/approve", testAdmin, nil), http.StatusBadRequest)
+
+	// the pending session ended with the approval
+	expectStatus(t, server.send(http.MethodPost, "/questions/question-1/reply", pending.Token, Reply{Text: "Drink water."}), http.StatusUnauthorized)
+	approved := server.login(doctor.Email, testPassword)
+	expectStatus(t, server.send(http.MethodPost, "/questions/question-1/reply", approved.Token, Reply{Text: "Drink water."}), http.StatusCreated)
+
+	last := server.mailer.messages[len(server.mailer.messages)-1]
+	if last.To != doctor.Email || !strings.Contains(last.Body, "approved") {
+		t.Errorf("expected approval email, got %+v", last)
+	}
+}
+
+func TestRejectDoctor(t *testing.T) {
+	server := newTestServer(t)
+	doctor := server.registerDoctor("quinn@example.com")
+
+	recorder := server.do(http.MethodPost, "/admin/doctors/"+doctor.Id+"/reject", testAdmin, DoctorRejectionForm{Reason: "License not found"})
+	expectStatus(t, recorder, http.StatusOK)
+	expectStatus(t, server.do(http.MethodPost, "/admin/doctors/"+doctor.Id+"/reject", testAdmin, nil), http.StatusBadRequest)
+
+	last := server.mailer.messages[len(server.mailer.messages)-1]
+	if last.To != doctor.Email || !strings.Contains(last.Body, "License not found") {
+		t.Errorf("expected rejection email with reason, got %+v", last)
+	}
+
+	recorder = server.do(http.MethodGet, "/admin/doctors/pending", testAdmin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if strings.TrimSpace(recorder.Body.String()) != "[]" {
+		t.Errorf("expected no pending doctors, got %s", recorder.Body.String())
+	}
+}
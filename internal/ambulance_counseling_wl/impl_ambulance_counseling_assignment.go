@@ -0,0 +1,157 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+type implAmbulanceCounselingAssignmentAPI struct {
+	userDbService db_service.DbService[User]
+	counseling    implAmbulanceCounselingAPI
+}
+
+func NewAmbulanceCounselingAssignmentApi(userDbService db_service.DbService[User], questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply]) AmbulanceCounselingAssignmentAPI {
+	return &implAmbulanceCounselingAssignmentAPI{
+		userDbService: userDbService,
+		counseling: implAmbulanceCounselingAPI{
+			questionDbService: questionDbService,
+			replyDbService:    replyDbService,
+		},
+	}
+}
+
+var errQuestionNotOpen = errors.New("question is not open")
+var errNotAssigned = errors.New("question is not assigned to the caller")
+
+func (o *implAmbulanceCounselingAssignmentAPI) ClaimQuestion(c *gin.Context) {
+	if !isDoctor(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can claim questions"})
+		return
+	}
+
+	doctorId := c.GetString("userId")
+	o.updateAssignment(c, func(question *Question, now time.Time) error {
+		if !isQuestionOpen(question) {
+			return errQuestionNotOpen
+		}
+		// claiming own question again extends the claim
+		if assignee := activeAssignee(question, now); assignee != "" && assignee != doctorId {
+			return errAssignedToOtherDoctor
+		}
+		assignQuestion(question, doctorId, now)
+		return nil
+	})
+}
+
+func (o *implAmbulanceCounselingAssignmentAPI) ReleaseQuestion(c *gin.Context) {
+	isAdmin := c.GetString("userType") == "admin"
+	if !isDoctor(c) && !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and administrators can release questions"})
+		return
+	}
+
+	o.updateAssignment(c, func(question *Question, now time.Time) error {
+		if !isAdmin && activeAssignee(question, now) != c.GetString("userId") {
+			return errNotAssigned
+		}
+		releaseQuestion(question)
+		return nil
+	})
+}
+
+func (o *implAmbulanceCounselingAssignmentAPI) AssignQuestion(c *gin.Context) {
+	isAdmin := c.GetString("userType") == "admin"
+	if !isDoctor(c) && !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and administrators can reassign questions"})
+		return
+	}
+
+	var form QuestionAssignmentForm
+	if err := c.ShouldBindJSON(&form); err != nil || form.DoctorId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Doctor ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	doctor, err := o.userDbService.FindDocument(ctx, form.DoctorId)
+	if err != nil && err != db_service.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err == db_service.ErrNotFound || doctor.Type != "doctor" || doctor.ApprovalStatus != doctorApproved || doctor.Disabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Questions can only be assigned to approved doctors"})
+		return
+	}
+
+	// doctors hand over only questions they hold, administrators any open question
+	o.updateAssignment(c, func(question *Question, now time.Time) error {
+		if !isQuestionOpen(question) {
+			return errQuestionNotOpen
+		}
+		if !isAdmin && activeAssignee(question, now) != c.GetString("userId") {
+			return errNotAssigned
+		}
+		assignQuestion(question, doctor.Id, now)
+		return nil
+	})
+}
+
+// Applies the assignment change to the question from the path and writes the updated question
+func (o *implAmbulanceCounselingAssignmentAPI) updateAssignment(c *gin.Context, apply func(question *Question, now time.Time) error) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !ifMatchSatisfied(c, question.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
+	expectedVersion := question.Version
+	question, err = o.counseling.updateQuestion(ctx, id, func(question *Question) error {
+		if hasIfMatch(c) && question.Version != expectedVersion {
+			return errPreconditionFailed
+		}
+		if err := apply(question, time.Now()); err != nil {
+			return err
+		}
+		question.LastUpdated = time.Now()
+		return nil
+	})
+	switch err {
+	case nil:
+	case errQuestionNotOpen:
+		c.JSON(http.StatusConflict, gin.H{"error": "Closed questions cannot be assigned"})
+		return
+	case errAssignedToOtherDoctor:
+		c.JSON(http.StatusConflict, gin.H{"error": "Question is already claimed by another doctor"})
+		return
+	case errNotAssigned:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Question is not assigned to you"})
+		return
+	default:
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to update question")
+		return
+	}
+
+	c.Header("ETag", versionETag(question.Version))
+	c.JSON(http.StatusOK, question)
+}
@@ -0,0 +1,96 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClaimQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testPatient, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/questions/unknown/claim", testDoctor, nil), http.StatusNotFound)
+	recorder := server.do(http.MethodPost, "/questions/question-1/claim", testDoctor, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if recorder.Header().Get("ETag") == "" {
+		t.Error("expected ETag of the claimed question")
+	}
+	question := server.question("question-1")
+	if question.AssignedDoctorId != testDoctor.Id || question.ClaimExpiresAt == nil {
+		t.Fatalf("question not claimed: %+v", question)
+	}
+
+	// only the assigned doctor answers, the patient can still reply
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testColleague, nil), http.StatusConflict)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testColleague, Reply{Text: "Rest."}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Since Monday."}), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testDoctor, Reply{Text: "Drink water."}), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testDoctor, nil), http.StatusOK)
+}
+
+func TestReplyClaimsQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testDoctor, Reply{Text: "How long?"}), http.StatusCreated)
+	if question := server.question("question-1"); question.AssignedDoctorId != testDoctor.Id {
+		t.Fatalf("reply must claim the question: %+v", question)
+	}
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testColleague, Reply{Text: "Rest."}), http.StatusForbidden)
+}
+
+func TestClaimExpires(t *testing.T) {
+	server := newTestServer(t)
+	question := server.seedQuestion(testPatient.Id, false)
+	expiredAt := time.Now().Add(-time.Minute)
+	question.AssignedDoctorId = testDoctor.Id
+	question.ClaimExpiresAt = &expiredAt
+	if err := server.questionDbService.UpdateDocument(context.Background(), question.Id, question); err != nil {
+		t.Fatalf("failed to update question: %v", err)
+	}
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testColleague, Reply{Text: "Rest."}), http.StatusCreated)
+	if stored := server.question("question-1"); stored.AssignedDoctorId != testColleague.Id || !stored.ClaimExpiresAt.After(time.Now()) {
+		t.Errorf("expected question claimed by %s, got %+v", testColleague.Id, stored)
+	}
+}
+
+func TestReleaseQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testDoctor, nil), http.StatusOK)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/release", testPatient, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/release", testColleague, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/release", testDoctor, nil), http.StatusOK)
+	if question := server.question("question-1"); question.AssignedDoctorId != "" || question.ClaimExpiresAt != nil {
+		t.Fatalf("question not released: %+v", question)
+	}
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testColleague, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/release", testAdmin, nil), http.StatusOK)
+}
+
+func TestAssignQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", DoctorName: "MUDr. Gregory House"})
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testDoctor, nil), http.StatusOK)
+
+	expectStatus(t, server.do(http.MethodPut, "/questions/question-1/assignee", testColleague, QuestionAssignmentForm{DoctorId: testColleague.Id}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPut, "/questions/question-1/assignee", testDoctor, QuestionAssignmentForm{DoctorId: testPatient.Id}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/questions/question-1/assignee", testDoctor, QuestionAssignmentForm{DoctorId: testColleague.Id}), http.StatusOK)
+	if question := server.question("question-1"); question.AssignedDoctorId != testColleague.Id {
+		t.Fatalf("question not handed over: %+v", question)
+	}
+	expectStatus(t, server.do(http.MethodPut, "/questions/question-1/assignee", testAdmin, QuestionAssignmentForm{DoctorId: testDoctor.Id}), http.StatusOK)
+
+	// closing ends the assignment
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/close", testPatient, QuestionCloseForm{Resolved: true}), http.StatusOK)
+	if question := server.question("question-1"); question.AssignedDoctorId != "" {
+		t.Errorf("closed question must not stay assigned: %+v", question)
+	}
+	expectStatus(t, server.do(http.MethodPut, "/questions/question-1/assignee", testAdmin, QuestionAssignmentForm{DoctorId: testDoctor.Id}), http.StatusConflict)
+}
@@ -0,0 +1,423 @@
+package ambulance_counseling_wl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/file_service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAttachmentSize         = 10 << 20
+	maxAttachmentsPerDocument = 10
+)
+
+// attachmentContentTypes lists the media types accepted for upload, detected from the content
+var attachmentContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var errAttachmentLimit = errors.New("too many attachments")
+var errDocumentLocked = errors.New("document has been replied to")
+
+type implAmbulanceCounselingAttachmentAPI struct {
+	counseling implAmbulanceCounselingAPI
+}
+
+func NewAmbulanceCounselingAttachmentApi(questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply], fileStore file_service.FileStore) AmbulanceCounselingAttachmentAPI {
+	return &implAmbulanceCounselingAttachmentAPI{
+		counseling: implAmbulanceCounselingAPI{
+			questionDbService: questionDbService,
+			replyDbService:    replyDbService,
+			fileStore:         fileStore,
+		},
+	}
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) UploadQuestionAttachment(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// like the text, attachments of a question are fixed once it was answered
+	if !isCreator(c, question.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can attach files to this question"})
+		return
+	}
+
+	if question.RepliedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot attach files to a question that has been replied to"})
+		return
+	}
+
+	if !ifMatchSatisfied(c, question.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Question has been modified"})
+		return
+	}
+
+	if len(question.Attachments) >= maxAttachmentsPerDocument {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A question can have at most %d attachments", maxAttachmentsPerDocument)})
+		return
+	}
+
+	attachment, ok := o.storeUpload(c)
+	if !ok {
+		return
+	}
+
+	expectedVersion := question.Version
+	question, err = o.counseling.updateQuestion(ctx, id, func(question *Question) error {
+		if hasIfMatch(c) && question.Version != expectedVersion {
+			return errPreconditionFailed
+		}
+		if question.RepliedTo {
+			return errDocumentLocked
+		}
+		if len(question.Attachments) >= maxAttachmentsPerDocument {
+			return errAttachmentLimit
+		}
+		question.Attachments = append(question.Attachments, *attachment)
+		question.LastUpdated = time.Now()
+		return nil
+	})
+	if err != nil {
+		o.counseling.deleteAttachmentFiles(ctx, []Attachment{*attachment})
+		writeAttachmentError(c, err, "Question has been modified", "Failed to update question")
+		return
+	}
+
+	c.Header("ETag", versionETag(question.Version))
+	c.JSON(http.StatusCreated, attachment)
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) UploadReplyAttachment(c *gin.Context) {
+	questionId := c.Param("questionId")
+	replyId := c.Param("replyId")
+	if questionId == "" || replyId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID and reply ID are required"})
+		return
+	}
+
+	ctx := context.Background()
+	reply, err := o.findQuestionReply(ctx, questionId, replyId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reply not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !isCreator(c, reply.UserId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can attach files to this reply"})
+		return
+	}
+
+	if reply.RepliedTo {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot attach files to a reply that has been replied to"})
+		return
+	}
+
+	if !ifMatchSatisfied(c, reply.Version) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Reply has been modified"})
+		return
+	}
+
+	if len(reply.Attachments) >= maxAttachmentsPerDocument {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A reply can have at most %d attachments", maxAttachmentsPerDocument)})
+		return
+	}
+
+	attachment, ok := o.storeUpload(c)
+	if !ok {
+		return
+	}
+
+	reply.Attachments = append(reply.Attachments, *attachment)
+	if err := o.counseling.replyDbService.UpdateDocumentIfVersion(ctx, reply.Id, reply.Version, reply); err != nil {
+		o.counseling.deleteAttachmentFiles(ctx, []Attachment{*attachment})
+		writeAttachmentError(c, err, "Reply has been modified", "Failed to update reply")
+		return
+	}
+
+	c.Header("ETag", versionETag(reply.Version))
+	c.JSON(http.StatusCreated, attachment)
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) DownloadAttachment(c *gin.Context) {
+	id := c.Param("questionId")
+	attachmentId := c.Param("attachmentId")
+	if id == "" || attachmentId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID and attachment ID are required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// files stay private even on public and anonymized questions
+	if !canReadAttachments(c, question) {
+		if !canReadQuestion(c, question) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can download attachments"})
+		return
+	}
+
+	attachment, _ := findAttachment(question, attachmentId)
+	if attachment == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	content, err := o.counseling.fileStore.OpenFile(ctx, attachment.Id)
+	if err != nil {
+		if err == file_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+	defer content.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) DeleteAttachment(c *gin.Context) {
+	id := c.Param("questionId")
+	attachmentId := c.Param("attachmentId")
+	if id == "" || attachmentId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID and attachment ID are required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	attachment, replyId := findAttachment(question, attachmentId)
+	if attachment == nil || !canReadQuestion(c, question) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	if !isCreator(c, attachment.UploadedBy) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the uploader can delete this attachment"})
+		return
+	}
+
+	if replyId == "" {
+		err = o.removeQuestionAttachment(c, question, attachmentId)
+	} else {
+		err = o.removeReplyAttachment(c, question.Id, replyId, attachmentId)
+	}
+	if err != nil {
+		writeAttachmentError(c, err, "Attachment has been modified", "Failed to delete attachment")
+		return
+	}
+
+	o.counseling.deleteAttachmentFiles(ctx, []Attachment{*attachment})
+	c.Status(http.StatusNoContent)
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) removeQuestionAttachment(c *gin.Context, question *Question, attachmentId string) error {
+	if !ifMatchSatisfied(c, question.Version) {
+		return errPreconditionFailed
+	}
+
+	ctx := context.Background()
+	expectedVersion := question.Version
+	_, err := o.counseling.updateQuestion(ctx, question.Id, func(question *Question) error {
+		if hasIfMatch(c) && question.Version != expectedVersion {
+			return errPreconditionFailed
+		}
+		if question.RepliedTo {
+			return errDocumentLocked
+		}
+		question.Attachments = withoutAttachment(question.Attachments, attachmentId)
+		question.LastUpdated = time.Now()
+		return nil
+	})
+	return err
+}
+
+func (o *implAmbulanceCounselingAttachmentAPI) removeReplyAttachment(c *gin.Context, questionId string, replyId string, attachmentId string) error {
+	ctx := context.Background()
+	reply, err := o.findQuestionReply(ctx, questionId, replyId)
+	if err != nil {
+		return err
+	}
+	if !ifMatchSatisfied(c, reply.Version) {
+		return errPreconditionFailed
+	}
+	if reply.RepliedTo {
+		return errDocumentLocked
+	}
+
+	reply.Attachments = withoutAttachment(reply.Attachments, attachmentId)
+	return o.counseling.replyDbService.UpdateDocumentIfVersion(ctx, reply.Id, reply.Version, reply)
+}
+
+// Loads the reply, it must belong to the question
+func (o *implAmbulanceCounselingAttachmentAPI) findQuestionReply(ctx context.Context, questionId string, replyId string) (*Reply, error) {
+	reply, err := o.counseling.replyDbService.FindDocument(ctx, replyId)
+	if err != nil {
+		return nil, err
+	}
+	if reply.QuestionId != questionId {
+		return nil, db_service.ErrNotFound
+	}
+	return reply, nil
+}
+
+// Reads the file of the multipart upload, checks its size and content and stores it,
+// writes the error response and returns false on failure
+func (o *implAmbulanceCounselingAttachmentAPI) storeUpload(c *gin.Context) (*Attachment, bool) {
+	// leaves room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments can have at most %d MB", maxAttachmentSize>>20)})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required in the file form field"})
+		return nil, false
+	}
+
+	if header.Size > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachments can have at most %d MB", maxAttachmentSize>>20)})
+		return nil, false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read the uploaded file"})
+		return nil, false
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read the uploaded file"})
+		return nil, false
+	}
+
+	// the declared content type comes from the client and is not trusted
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	if !attachmentContentTypes[contentType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG, PNG, GIF, WebP images and PDF documents can be attached"})
+		return nil, false
+	}
+
+	id, err := o.counseling.generateDocumentID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate attachment ID"})
+		return nil, false
+	}
+
+	attachment := &Attachment{
+		Id:          id,
+		FileName:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		UploadedBy:  c.GetString("userId"),
+		UploadedAt:  time.Now(),
+	}
+
+	ctx := context.Background()
+	if err := o.counseling.fileStore.SaveFile(ctx, attachment.Id, attachment.FileName, bytes.NewReader(content)); err != nil {
+		log.Printf("Failed to store attachment %s: %v", attachment.Id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		return nil, false
+	}
+	return attachment, true
+}
+
+// Finds the attachment of the question or of one of its replies, returns the id of the reply
+// the attachment belongs to or an empty string for attachments of the question
+func findAttachment(question *Question, attachmentId string) (*Attachment, string) {
+	for i := range question.Attachments {
+		if question.Attachments[i].Id == attachmentId {
+			return &question.Attachments[i], ""
+		}
+	}
+	for _, reply := range question.Replies {
+		for i := range reply.Attachments {
+			if reply.Attachments[i].Id == attachmentId {
+				return &reply.Attachments[i], reply.Id
+			}
+		}
+	}
+	return nil, ""
+}
+
+func withoutAttachment(attachments []Attachment, attachmentId string) []Attachment {
+	var remaining []Attachment
+	for _, attachment := range attachments {
+		if attachment.Id != attachmentId {
+			remaining = append(remaining, attachment)
+		}
+	}
+	return remaining
+}
+
+// Maps errors of attachment updates to responses, see writeVersionedUpdateError
+func writeAttachmentError(c *gin.Context, err error, conflictMessage string, failureMessage string) {
+	switch err {
+	case errDocumentLocked:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot change attachments after a reply"})
+	case errAttachmentLimit:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("At most %d attachments are allowed", maxAttachmentsPerDocument)})
+	default:
+		writeVersionedUpdateError(c, err, conflictMessage, failureMessage)
+	}
+}
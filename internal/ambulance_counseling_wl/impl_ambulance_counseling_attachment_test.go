@@ -0,0 +1,213 @@
+package ambulance_counseling_wl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/file_service"
+)
+
+var testPng = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+var testPdf = []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n%%EOF\n")
+
+// Uploads the content as the file form field of a multipart request
+func (s *testServer) upload(path string, user *User, fileName string, content []byte) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		s.t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	token, err := GenerateJWT(user)
+	if err != nil {
+		s.t.Fatalf("failed to sign token: %v", err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/ak-ambulance-counseling-api"+path, &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decodeAttachment(t *testing.T, recorder *httptest.ResponseRecorder) Attachment {
+	t.Helper()
+	var attachment Attachment
+	if err := json.Unmarshal(recorder.Body.Bytes(), &attachment); err != nil {
+		t.Fatalf("failed to decode attachment: %v", err)
+	}
+	return attachment
+}
+
+func TestUploadQuestionAttachment(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	expectStatus(t, server.upload("/questions/question-1/attachments", testStranger, "scan.png", testPng), http.StatusForbidden)
+	expectStatus(t, server.upload("/questions/question-1/attachments", testPatient, "notes.txt", []byte("plain text")), http.StatusUnsupportedMediaType)
+	expectStatus(t, server.upload("/questions/question-1/attachments", testPatient, "huge.pdf", append(testPdf, make([]byte, maxAttachmentSize)...)), http.StatusRequestEntityTooLarge)
+
+	recorder := server.upload("/questions/question-1/attachments", testPatient, "scan.png", testPng)
+	expectStatus(t, recorder, http.StatusCreated)
+	attachment := decodeAttachment(t, recorder)
+	if attachment.ContentType != "image/png" || attachment.Size != int64(len(testPng)) || attachment.UploadedBy != testPatient.Id {
+		t.Fatalf("unexpected attachment: %+v", attachment)
+	}
+	if question := server.question("question-1"); len(question.Attachments) != 1 || question.Attachments[0].Id != attachment.Id {
+		t.Fatalf("attachment not stored on the question: %+v", question.Attachments)
+	}
+
+	// the question is locked once a doctor replied
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testDoctor, Reply{Text: "Send a photo."}), http.StatusCreated)
+	expectStatus(t, server.upload("/questions/question-1/attachments", testPatient, "scan.pdf", testPdf), http.StatusForbidden)
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	recorder := server.upload("/questions/question-1/attachments", testPatient, "scan.pdf", testPdf)
+	expectStatus(t, recorder, http.StatusCreated)
+	path := "/questions/question-1/attachments/" + decodeAttachment(t, recorder).Id
+
+	for _, user := range []*User{testPatient, testDoctor} {
+		recorder = server.do(http.MethodGet, path, user, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		if !bytes.Equal(recorder.Body.Bytes(), testPdf) || recorder.Header().Get("Content-Type") != "application/pdf" {
+			t.Errorf("unexpected download for %s: %q %q", user.Id, recorder.Header().Get("Content-Type"), recorder.Body.String())
+		}
+	}
+
+	expectStatus(t, server.do(http.MethodGet, path, testStranger, nil), http.StatusNotFound)
+	server.setVisibility("question-1", visibilityPublic)
+	expectStatus(t, server.do(http.MethodGet, path, testStranger, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/attachments/unknown", testPatient, nil), http.StatusNotFound)
+}
+
+func TestDownloadAttachmentOfAnonymizedQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	recorder := server.upload("/questions/question-1/attachments", testPatient, "scan.pdf", testPdf)
+	expectStatus(t, recorder, http.StatusCreated)
+	path := "/questions/question-1/attachments/" + decodeAttachment(t, recorder).Id
+	server.setVisibility("question-1", visibilityAnonymized)
+
+	// anybody reads the question, the files could identify the patient
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1", nil, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodGet, path, nil, nil), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodGet, path, testStranger, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodGet, path, testPatient, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodGet, path, testDoctor, nil), http.StatusOK)
+}
+
+func TestAnonymizedAttachmentsHidePatient(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true,
+		Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Send a photo.", DoctorName: "MUDr. Gregory House",
+			Attachments: []Attachment{{Id: "leaflet", FileName: "leaflet.pdf", UploadedBy: testDoctor.Id}}},
+		Reply{Id: "reply-2", UserId: testPatient.Id, Text: "Here it is.",
+			Attachments: []Attachment{{Id: "photo", FileName: "Jane Doe rash.PNG", UploadedBy: testPatient.Id}}},
+	)
+	question := server.question("question-1")
+	question.Attachments = []Attachment{{Id: "scan", FileName: "jane-doe-scan.pdf", UploadedBy: testPatient.Id}}
+	if err := server.questionDbService.UpdateDocument(context.Background(), question.Id, question); err != nil {
+		t.Fatalf("failed to update question: %v", err)
+	}
+	server.setVisibility("question-1", visibilityAnonymized)
+
+	recorder := server.do(http.MethodGet, "/questions/question-1", testStranger, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if err := json.Unmarshal(recorder.Body.Bytes(), question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if attachment := question.Attachments[0]; attachment.UploadedBy != "" || attachment.FileName != "attachment.pdf" {
+		t.Errorf("question attachment not redacted: %+v", attachment)
+	}
+	if attachment := question.Replies[1].Attachments[0]; attachment.UploadedBy != "" || attachment.FileName != "attachment.png" {
+		t.Errorf("reply attachment not redacted: %+v", attachment)
+	}
+	// files of the doctor keep their names
+	if attachment := question.Replies[0].Attachments[0]; attachment.UploadedBy != testDoctor.Id || attachment.FileName != "leaflet.pdf" {
+		t.Errorf("doctor attachment must not be redacted: %+v", attachment)
+	}
+	if bytes.Contains(recorder.Body.Bytes(), []byte(testPatient.Id)) {
+		t.Errorf("response names the patient: %s", recorder.Body.String())
+	}
+
+	var reply Reply
+	recorder = server.do(http.MethodGet, "/questions/question-1/reply/reply-2", testStranger, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if attachment := reply.Attachments[0]; attachment.UploadedBy != "" || attachment.FileName != "attachment.png" {
+		t.Errorf("reply attachment not redacted: %+v", attachment)
+	}
+
+	recorder = server.do(http.MethodGet, "/questions/question-1", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if !bytes.Contains(recorder.Body.Bytes(), []byte("jane-doe-scan.pdf")) {
+		t.Errorf("the patient must see their file names: %s", recorder.Body.String())
+	}
+}
+
+func TestUploadReplyAttachment(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", DoctorName: "MUDr. Gregory House"})
+
+	expectStatus(t, server.upload("/questions/question-1/reply/reply-1/attachments", testPatient, "scan.png", testPng), http.StatusForbidden)
+	expectStatus(t, server.upload("/questions/question-1/reply/unknown/attachments", testDoctor, "scan.png", testPng), http.StatusNotFound)
+	recorder := server.upload("/questions/question-1/reply/reply-1/attachments", testDoctor, "leaflet.pdf", testPdf)
+	expectStatus(t, recorder, http.StatusCreated)
+	attachment := decodeAttachment(t, recorder)
+
+	reply, err := server.replyDbService.FindDocument(context.Background(), "reply-1")
+	if err != nil || len(reply.Attachments) != 1 {
+		t.Fatalf("attachment not stored on the reply: %+v %v", reply, err)
+	}
+	if question := server.question("question-1"); len(question.Replies[0].Attachments) != 1 || question.Replies[0].Attachments[0].Id != attachment.Id {
+		t.Fatalf("reply not updated: %+v", question.Replies[0])
+	}
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/attachments/"+attachment.Id, testPatient, nil), http.StatusOK)
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	recorder := server.upload("/questions/question-1/attachments", testPatient, "scan.png", testPng)
+	expectStatus(t, recorder, http.StatusCreated)
+	attachment := decodeAttachment(t, recorder)
+	path := "/questions/question-1/attachments/" + attachment.Id
+
+	expectStatus(t, server.do(http.MethodDelete, path, testDoctor, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodDelete, path, testPatient, nil), http.StatusNoContent)
+	if question := server.question("question-1"); len(question.Attachments) != 0 {
+		t.Fatalf("attachment not removed: %+v", question.Attachments)
+	}
+	if _, err := server.fileStore.OpenFile(context.Background(), attachment.Id); err != file_service.ErrNotFound {
+		t.Errorf("expected stored file to be deleted, got %v", err)
+	}
+	expectStatus(t, server.do(http.MethodGet, path, testPatient, nil), http.StatusNotFound)
+}
+
+func TestDeleteQuestionRemovesAttachments(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	recorder := server.upload("/questions/question-1/attachments", testPatient, "scan.png", testPng)
+	expectStatus(t, recorder, http.StatusCreated)
+	attachment := decodeAttachment(t, recorder)
+
+	expectStatus(t, server.do(http.MethodDelete, "/delete/question/question-1", testPatient, nil), http.StatusNoContent)
+	if _, err := server.fileStore.OpenFile(context.Background(), attachment.Id); err != file_service.ErrNotFound {
+		t.Errorf("expected stored file to be deleted, got %v", err)
+	}
+}
@@ -4,21 +4,32 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"log"
 	"net/http"
+	"net/mail"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type implAmbulanceCounselingAuthAPI struct {
-	userDbService db_service.DbService[User]
+	userAccounts
+	revokedTokenDbService db_service.DbService[RevokedToken]
 }
 
-func NewAmbulanceCounselingAuthApi(userDbService db_service.DbService[User]) AmbulanceCounselingAuthAPI {
+func NewAmbulanceCounselingAuthApi(userDbService db_service.DbService[User], refreshTokenDbService db_service.DbService[RefreshToken], revokedTokenDbService db_service.DbService[RevokedToken], mailer mail_service.Mailer) AmbulanceCounselingAuthAPI {
 	return &implAmbulanceCounselingAuthAPI{
-		userDbService: userDbService,
+		userAccounts: userAccounts{
+			userDbService:         userDbService,
+			refreshTokenDbService: refreshTokenDbService,
+			mailer:                mailer,
+		},
+		revokedTokenDbService: revokedTokenDbService,
 	}
 }
 
@@ -37,6 +48,12 @@ func hashPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
+// email format check
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
 // hash check
 func checkPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
@@ -71,20 +88,29 @@ func (o *implAmbulanceCounselingAuthAPI) UserLogin(c *gin.Context) {
 		return
 	}
 
-	tokenString, err := GenerateJWT(user)
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		return
+	}
+
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account has been disabled"})
+		return
+	}
+
+	familyId, err := generateRandomID()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	response := UserLogin200Response{
-		Token: tokenString,
+	response, err := o.issueTokens(ctx, user, familyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": response.Token,
-		"user":  user,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 func (o *implAmbulanceCounselingAuthAPI) UserRegister(c *gin.Context) {
@@ -95,6 +121,35 @@ func (o *implAmbulanceCounselingAuthAPI) UserRegister(c *gin.Context) {
 	}
 
 	email := strings.ToLower(registrationForm.Email)
+	if !isValidEmail(email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	user := User{
+		Name:          registrationForm.Name,
+		Email:         email,
+		Type:          "patient",
+		EmailVerified: false,
+	}
+
+	switch registrationForm.Type {
+	case "", "patient":
+	case "doctor":
+		// doctors act as patients until an administrator verifies their credentials
+		user.Type = "doctor"
+		user.ApprovalStatus = doctorPending
+		user.LicenseNumber = strings.TrimSpace(registrationForm.LicenseNumber)
+		user.Specialty = strings.TrimSpace(registrationForm.Specialty)
+		user.Workplace = strings.TrimSpace(registrationForm.Workplace)
+		if user.LicenseNumber == "" || user.Specialty == "" || user.Workplace == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "License number, specialty and workplace are required for doctors"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of patient, doctor"})
+		return
+	}
 
 	ctx := context.Background()
 
@@ -129,13 +184,8 @@ func (o *implAmbulanceCounselingAuthAPI) UserRegister(c *gin.Context) {
 		return
 	}
 
-	user := User{
-		Id:           id,
-		Name:         registrationForm.Name,
-		Email:        email,
-		Type:         "patient",
-		PasswordHash: hashedPassword,
-	}
+	user.Id = id
+	user.PasswordHash = hashedPassword
 
 	err = o.userDbService.CreateDocument(ctx, user.Id, &user)
 	if err != nil {
@@ -143,5 +193,310 @@ func (o *implAmbulanceCounselingAuthAPI) UserRegister(c *gin.Context) {
 		return
 	}
 
+	// the account exists even if the email fails, the user can ask for another one
+	if err := o.sendEmailVerification(ctx, &user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.Id, err)
+	}
+
 	c.JSON(http.StatusCreated, user)
 }
+
+func (o *implAmbulanceCounselingAuthAPI) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token is required"})
+		return
+	}
+
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByField(ctx, "emailVerificationTokenHash", hashToken(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if len(users) == 0 || users[0].EmailVerificationExpiresAt == nil || time.Now().After(*users[0].EmailVerificationExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	user := users[0]
+	user.EmailVerified = true
+	user.EmailVerificationTokenHash = ""
+	user.EmailVerificationExpiresAt = nil
+
+	err = o.userDbService.UpdateDocument(ctx, user.Id, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (o *implAmbulanceCounselingAuthAPI) ResendVerificationEmail(c *gin.Context) {
+	var form EmailVerificationResendForm
+	if err := c.ShouldBindJSON(&form); err != nil || form.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification data"})
+		return
+	}
+
+	// the response is the same whether the account exists or not, to not disclose registered emails
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByField(ctx, "email", strings.ToLower(form.Email))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// failures are only logged, an error response would disclose the account as well
+	if len(users) > 0 && !users[0].EmailVerified {
+		if err := o.sendEmailVerification(ctx, users[0]); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", users[0].Id, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account awaits verification, a verification email has been sent"})
+}
+
+func (o *implAmbulanceCounselingAuthAPI) RefreshToken(c *gin.Context) {
+	var form RefreshTokenForm
+	if err := c.ShouldBindJSON(&form); err != nil || form.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refresh token data"})
+		return
+	}
+
+	ctx := context.Background()
+	stored, err := o.refreshTokenDbService.FindDocument(ctx, hashToken(form.RefreshToken))
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if stored.Used {
+		// a rotated token was presented again, the session may have been stolen
+		o.revokeTokenFamily(ctx, stored.FamilyId)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		if err := o.refreshTokenDbService.DeleteDocument(ctx, stored.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to delete expired refresh token: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	stored.Used = true
+	err = o.refreshTokenDbService.UpdateDocumentIfVersion(ctx, stored.Id, stored.Version, stored)
+	if err != nil {
+		if err == db_service.ErrVersionMismatch || err == db_service.ErrNotFound {
+			// another request rotated the token at the same time
+			o.revokeTokenFamily(ctx, stored.FamilyId)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := o.userDbService.FindDocument(ctx, stored.UserId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account has been disabled"})
+		return
+	}
+
+	response, err := o.issueTokens(ctx, user, stored.FamilyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (o *implAmbulanceCounselingAuthAPI) UserLogout(c *gin.Context) {
+	userId, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// the refresh token is optional, without it only the access token is revoked
+	var form RefreshTokenForm
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logout data"})
+			return
+		}
+	}
+
+	ctx := context.Background()
+	revoked := RevokedToken{
+		Id:        c.GetString("tokenId"),
+		UserId:    userId.(string),
+		ExpiresAt: c.GetTime("tokenExpiresAt"),
+	}
+	if revoked.Id != "" {
+		err := o.revokedTokenDbService.CreateDocument(ctx, revoked.Id, &revoked)
+		if err != nil && err != db_service.ErrConflict {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	if form.RefreshToken != "" {
+		stored, err := o.refreshTokenDbService.FindDocument(ctx, hashToken(form.RefreshToken))
+		switch {
+		case err == nil && stored.UserId == revoked.UserId:
+			o.revokeTokenFamily(ctx, stored.FamilyId)
+		case err != nil && err != db_service.ErrNotFound:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (o *implAmbulanceCounselingAuthAPI) RequestPasswordReset(c *gin.Context) {
+	var form PasswordResetRequestForm
+	if err := c.ShouldBindJSON(&form); err != nil || form.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password reset data"})
+		return
+	}
+
+	// the response is the same whether the account exists or not, to not disclose registered emails
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByField(ctx, "email", strings.ToLower(form.Email))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// failures are only logged, an error response would disclose the account as well
+	if len(users) > 0 {
+		if err := o.sendPasswordReset(ctx, users[0]); err != nil {
+			log.Printf("Failed to send password reset to user %s: %v", users[0].Id, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+func (o *implAmbulanceCounselingAuthAPI) ConfirmPasswordReset(c *gin.Context) {
+	var form PasswordResetConfirmForm
+	if err := c.ShouldBindJSON(&form); err != nil || form.Token == "" || form.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password reset data"})
+		return
+	}
+
+	ctx := context.Background()
+	users, err := o.userDbService.FindDocumentsByField(ctx, "passwordResetTokenHash", hashToken(form.Token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if len(users) == 0 || users[0].PasswordResetExpiresAt == nil || time.Now().After(*users[0].PasswordResetExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired password reset token"})
+		return
+	}
+
+	user := users[0]
+	hashedPassword, err := hashPassword(form.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+		return
+	}
+
+	// the token is single-use
+	user.PasswordHash = hashedPassword
+	user.PasswordResetTokenHash = ""
+	user.PasswordResetExpiresAt = nil
+
+	err = o.userDbService.UpdateDocument(ctx, user.Id, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// sessions started with the old password must not survive the reset
+	o.revokeUserSessions(ctx, user.Id)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (o *implAmbulanceCounselingAuthAPI) ValidateToken(ctx context.Context, claims *JWTClaims) error {
+	_, err := o.revokedTokenDbService.FindDocument(ctx, claims.ID)
+	switch err {
+	case nil:
+		return ErrTokenRevoked
+	case db_service.ErrNotFound:
+	default:
+		return err
+	}
+
+	user, err := o.userDbService.FindDocument(ctx, claims.UserId)
+	switch {
+	case err == db_service.ErrNotFound:
+		return ErrTokenRevoked
+	case err != nil:
+		return err
+	case user.Disabled:
+		return ErrAccountDisabled
+	case user.Type != claims.UserType || user.ApprovalStatus != claims.ApprovalStatus:
+		// the user was promoted, demoted or reviewed since the token was issued
+		return ErrTokenRevoked
+	case user.Name != claims.UserName || user.Title != claims.UserTitle || user.Specialty != claims.Specialty ||
+		!slices.Equal(user.Specialties, claims.Specialties):
+		// the profile changed, a refreshed token carries the new signature
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Issues an access token together with a new refresh token of the given family
+func (o *implAmbulanceCounselingAuthAPI) issueTokens(ctx context.Context, user *User, familyId string) (*AuthTokens, error) {
+	accessToken, err := GenerateJWT(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := RefreshToken{
+		Id:        hashToken(refreshToken),
+		UserId:    user.Id,
+		FamilyId:  familyId,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := o.refreshTokenDbService.CreateDocument(ctx, stored.Id, &stored); err != nil {
+		return nil, err
+	}
+
+	return &AuthTokens{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
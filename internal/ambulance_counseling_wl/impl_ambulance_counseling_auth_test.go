@@ -0,0 +1,157 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// bcrypt is slow on purpose, hashes are shared between the tests
+var testPasswordHashes = map[string]string{}
+
+func (s *testServer) seedUser(user *User, password string) {
+	s.t.Helper()
+	hash, ok := testPasswordHashes[password]
+	if !ok {
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			s.t.Fatalf("failed to hash password: %v", err)
+		}
+		testPasswordHashes[password] = hash
+	}
+	stored := *user
+	stored.PasswordHash = hash
+	if err := s.userDbService.CreateDocument(context.Background(), stored.Id, &stored); err != nil {
+		s.t.Fatalf("failed to seed user: %v", err)
+	}
+}
+
+func decodeTokens(t *testing.T, recorder *httptest.ResponseRecorder) AuthTokens {
+	t.Helper()
+	var tokens AuthTokens
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("invalid token response: %v", err)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("missing tokens in response: %s", recorder.Body.String())
+	}
+	return tokens
+}
+
+func (s *testServer) login(email string, password string) AuthTokens {
+	s.t.Helper()
+	recorder := s.do(http.MethodPost, "/login", nil, LoginForm{Email: email, Password: password})
+	expectStatus(s.t, recorder, http.StatusOK)
+	return decodeTokens(s.t, recorder)
+}
+
+func TestUserLogin(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: testPatient.Email, Password: "wrong"}), http.StatusUnauthorized)
+
+	tokens := server.login(testPatient.Email, testPassword)
+	if tokens.ExpiresIn != int64(accessTokenTTL.Seconds()) {
+		t.Errorf("unexpected token lifetime %d", tokens.ExpiresIn)
+	}
+	claims, err := ParseJWT(tokens.Token)
+	if err != nil || claims.UserId != testPatient.Id || claims.ID == "" {
+		t.Errorf("unexpected claims %+v: %v", claims, err)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	server := newTestServer(t)
+	initial := server.login(testPatient.Email, testPassword)
+
+	recorder := server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: initial.RefreshToken})
+	expectStatus(t, recorder, http.StatusOK)
+	rotated := decodeTokens(t, recorder)
+	if rotated.RefreshToken == initial.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// reusing the rotated token revokes the whole family
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: initial.RefreshToken}), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: rotated.RefreshToken}), http.StatusUnauthorized)
+}
+
+func TestUserLogout(t *testing.T) {
+	server := newTestServer(t)
+	tokens := server.login(testPatient.Email, testPassword)
+
+	expectStatus(t, server.send(http.MethodPost, "/questions/new", tokens.Token, Question{PatientId: testPatient.Id, Category: "cardiology"}), http.StatusCreated)
+	expectStatus(t, server.send(http.MethodPost, "/logout", tokens.Token, RefreshTokenForm{RefreshToken: tokens.RefreshToken}), http.StatusNoContent)
+
+	expectStatus(t, server.send(http.MethodPost, "/questions/new", tokens.Token, Question{PatientId: testPatient.Id}), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+}
+
+func TestPasswordReset(t *testing.T) {
+	server := newTestServer(t)
+	session := server.login(testPatient.Email, testPassword)
+
+	expectStatus(t, server.do(http.MethodPost, "/password-reset/request", nil, PasswordResetRequestForm{Email: "nobody@example.com"}), http.StatusAccepted)
+	if len(server.mailer.messages) != 0 {
+		t.Fatalf("no email expected for unknown account, got %d", len(server.mailer.messages))
+	}
+
+	// failing to send must not disclose the account either
+	server.mailer.failures = 1
+	expectStatus(t, server.do(http.MethodPost, "/password-reset/request", nil, PasswordResetRequestForm{Email: testPatient.Email}), http.StatusAccepted)
+
+	expectStatus(t, server.do(http.MethodPost, "/password-reset/request", nil, PasswordResetRequestForm{Email: testPatient.Email}), http.StatusAccepted)
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].To != testPatient.Email {
+		t.Fatalf("expected reset email to %s, got %+v", testPatient.Email, server.mailer.messages)
+	}
+	match := regexp.MustCompile(`token=([0-9a-f]+)`).FindStringSubmatch(server.mailer.messages[0].Body)
+	if match == nil {
+		t.Fatalf("reset token missing in email: %s", server.mailer.messages[0].Body)
+	}
+
+	confirm := PasswordResetConfirmForm{Token: match[1], Password: "remembered"}
+	expectStatus(t, server.do(http.MethodPost, "/password-reset/confirm", nil, confirm), http.StatusNoContent)
+	expectStatus(t, server.do(http.MethodPost, "/password-reset/confirm", nil, confirm), http.StatusBadRequest)
+
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: testPatient.Email, Password: testPassword}), http.StatusUnauthorized)
+	server.login(testPatient.Email, "remembered")
+	expectStatus(t, server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: session.RefreshToken}), http.StatusUnauthorized)
+}
+
+func TestEmailVerification(t *testing.T) {
+	server := newTestServer(t)
+	form := RegistrationForm{Name: "New Patient", Email: "New@Example.com", Password: "secret"}
+
+	expectStatus(t, server.do(http.MethodPost, "/register", nil, RegistrationForm{Name: "Bad", Email: "not-an-email", Password: "secret"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/register", nil, form), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: "new@example.com", Password: "secret"}), http.StatusForbidden)
+
+	// resend replaces the token from the registration email
+	expectStatus(t, server.do(http.MethodPost, "/verify-email/resend", nil, EmailVerificationResendForm{Email: "new@example.com"}), http.StatusAccepted)
+	if len(server.mailer.messages) != 2 {
+		t.Fatalf("expected registration and resent verification emails, got %d", len(server.mailer.messages))
+	}
+	pattern := regexp.MustCompile(`token=([0-9a-f]+)`)
+	stale := pattern.FindStringSubmatch(server.mailer.messages[0].Body)
+	fresh := pattern.FindStringSubmatch(server.mailer.messages[1].Body)
+	if stale == nil || fresh == nil {
+		t.Fatal("verification token missing in email")
+	}
+
+	expectStatus(t, server.do(http.MethodGet, "/verify-email?token="+stale[1], nil, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodGet, "/verify-email?token="+fresh[1], nil, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodGet, "/verify-email?token="+fresh[1], nil, nil), http.StatusBadRequest)
+
+	server.login("new@example.com", "secret")
+}
+
+func TestUnverifiedTokenIsRejected(t *testing.T) {
+	server := newTestServer(t)
+	unverified := *testPatient
+	unverified.EmailVerified = false
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", &unverified, Question{PatientId: unverified.Id}), http.StatusForbidden)
+}
@@ -0,0 +1,136 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+type implAmbulanceCounselingCategoryAPI struct {
+	categoryDbService db_service.DbService[Category]
+	questionDbService db_service.DbService[Question]
+}
+
+func NewAmbulanceCounselingCategoryApi(categoryDbService db_service.DbService[Category], questionDbService db_service.DbService[Question]) AmbulanceCounselingCategoryAPI {
+	return &implAmbulanceCounselingCategoryAPI{
+		categoryDbService: categoryDbService,
+		questionDbService: questionDbService,
+	}
+}
+
+func (o *implAmbulanceCounselingCategoryAPI) GetCategories(c *gin.Context) {
+	ctx := context.Background()
+	categories, err := o.categoryDbService.FindAllDocuments(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve categories"})
+		return
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name < categories[j].Name
+	})
+	c.JSON(http.StatusOK, categories)
+}
+
+func (o *implAmbulanceCounselingCategoryAPI) CreateCategory(c *gin.Context) {
+	var category Category
+	if err := c.ShouldBindJSON(&category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category data"})
+		return
+	}
+
+	category.Name = strings.TrimSpace(category.Name)
+	if !categoryIdPattern.MatchString(category.Id) || category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category requires a lowercase id such as cardiology and a name"})
+		return
+	}
+
+	ctx := context.Background()
+	err := o.categoryDbService.CreateDocument(ctx, category.Id, &category)
+	if err != nil {
+		if err == db_service.ErrConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "Category already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+func (o *implAmbulanceCounselingCategoryAPI) UpdateCategory(c *gin.Context) {
+	id := c.Param("categoryId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category ID is required"})
+		return
+	}
+
+	var updateData Category
+	if err := c.ShouldBindJSON(&updateData); err != nil || strings.TrimSpace(updateData.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	ctx := context.Background()
+	category, err := o.categoryDbService.FindDocument(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// the id is referenced by questions and doctor specialties, only the texts change
+	category.Name = strings.TrimSpace(updateData.Name)
+	category.Description = updateData.Description
+
+	err = o.categoryDbService.UpdateDocument(ctx, id, category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+func (o *implAmbulanceCounselingCategoryAPI) DeleteCategory(c *gin.Context) {
+	id := c.Param("categoryId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	questions, err := o.questionDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters:   []db_service.FieldFilter{{Field: "category", Operator: db_service.OpEq, Value: id}},
+		SortField: questionSortFields["createdAt"],
+		Limit:     1,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(questions) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category is used by questions"})
+		return
+	}
+
+	err = o.categoryDbService.DeleteDocument(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetCategories(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.do(http.MethodGet, "/categories", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var categories []Category
+	if err := json.Unmarshal(recorder.Body.Bytes(), &categories); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(categories) != 2 || categories[0].Id != "cardiology" || categories[1].Id != "dermatology" {
+		t.Errorf("unexpected categories %+v", categories)
+	}
+}
+
+func TestCategoryAdministration(t *testing.T) {
+	server := newTestServer(t)
+	pediatrics := Category{Id: "pediatrics", Name: "Pediatrics"}
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/categories", testDoctor, pediatrics), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/admin/categories", testAdmin, Category{Id: "Child Care", Name: "Pediatrics"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/admin/categories", testAdmin, pediatrics), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodPost, "/admin/categories", testAdmin, pediatrics), http.StatusConflict)
+
+	expectStatus(t, server.do(http.MethodPut, "/admin/categories/unknown", testAdmin, Category{Name: "Unknown"}), http.StatusNotFound)
+	recorder := server.do(http.MethodPut, "/admin/categories/pediatrics", testAdmin, Category{Id: "children", Name: "Children", Description: "Questions about children"})
+	expectStatus(t, recorder, http.StatusOK)
+	var category Category
+	if err := json.Unmarshal(recorder.Body.Bytes(), &category); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if category.Id != "pediatrics" || category.Name != "Children" {
+		t.Errorf("unexpected category %+v", category)
+	}
+
+	expectStatus(t, server.do(http.MethodDelete, "/admin/categories/pediatrics", testAdmin, nil), http.StatusNoContent)
+	expectStatus(t, server.do(http.MethodDelete, "/admin/categories/pediatrics", testAdmin, nil), http.StatusNotFound)
+}
+
+func TestDeleteUsedCategory(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Palpitations", Category: "cardiology"}), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodDelete, "/admin/categories/cardiology", testAdmin, nil), http.StatusConflict)
+}
+
+func TestCreateQuestionRequiresCategory(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Rash"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Rash", Category: "allergology"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Rash", Category: "dermatology"}), http.StatusCreated)
+}
+
+func TestQueueMatchesSpecialties(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	createdAt := time.Now()
+	for _, question := range []Question{
+		{Id: "heart", Category: "cardiology"},
+		{Id: "legacy"},
+		{Id: "skin", Category: "dermatology"},
+	} {
+		question.PatientId = testPatient.Id
+		question.CreatedAt = createdAt
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+	}
+	server.migrateQueue()
+
+	cases := []struct {
+		name string
+		user *User
+		ids  string
+	}{
+		{"specialist", testColleague, "heart,legacy"},
+		{"generalist", testDoctor, "heart,legacy,skin"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := server.do(http.MethodGet, "/queue", tc.user, nil)
+			expectStatus(t, recorder, http.StatusOK)
+			var page QuestionPage
+			if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			ids := []string{}
+			for _, question := range page.Items {
+				ids = append(ids, question.Id)
+			}
+			if strings.Join(ids, ",") != tc.ids {
+				t.Errorf("expected %v, got %v", tc.ids, ids)
+			}
+		})
+	}
+}
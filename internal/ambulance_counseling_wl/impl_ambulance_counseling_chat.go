@@ -0,0 +1,171 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// Largest chat message accepted from a client
+const chatMaxMessageSize = 64 << 10
+
+type implAmbulanceCounselingChatAPI struct {
+	counseling    implAmbulanceCounselingAPI
+	replyEventBus event_service.EventBus[ReplyEvent]
+	hub           *chatHub
+}
+
+func NewAmbulanceCounselingChatApi(questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply], transactor db_service.Transactor, replyEventBus event_service.EventBus[ReplyEvent], outbox *Outbox) AmbulanceCounselingChatAPI {
+	return &implAmbulanceCounselingChatAPI{
+		counseling: implAmbulanceCounselingAPI{
+			questionDbService: questionDbService,
+			replyDbService:    replyDbService,
+			transactor:        transactor,
+			outbox:            outbox,
+		},
+		replyEventBus: replyEventBus,
+		hub:           newChatHub(),
+	}
+}
+
+func (o *implAmbulanceCounselingChatAPI) JoinQuestionChat(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.questionDbService.FindDocument(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// the chat is a conversation of the people allowed to reply
+	if !isDoctor(c) && !isCreator(c, question.PatientId) {
+		if !canReadQuestion(c, question) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can join the chat"})
+		return
+	}
+
+	if !c.IsWebsocket() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket upgrade is required"})
+		return
+	}
+
+	// the Origin is not checked, the connection is authorized by the access token and not by cookies
+	server := websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			o.serveChat(c, question.Id, conn)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// Relays the chat of the question until the participant disconnects or the access token expires
+func (o *implAmbulanceCounselingChatAPI) serveChat(c *gin.Context, questionId string, conn *websocket.Conn) {
+	conn.MaxPayloadBytes = chatMaxMessageSize
+	participant := ChatParticipant{
+		UserId: c.GetString("userId"),
+		Name:   c.GetString("userName"),
+		Type:   c.GetString("userType"),
+	}
+	if isDoctor(c) {
+		participant.Name = doctorDisplayName(c.GetString("userTitle"), c.GetString("userName"))
+	}
+	connection := &chatConnection{conn: conn, participant: participant}
+
+	// replies posted over the REST API show up in the chat as well
+	events, unsubscribe := o.replyEventBus.SubscribeLive()
+	defer unsubscribe()
+	go func() {
+		for event := range events {
+			if event.QuestionId == questionId {
+				reply := event.Reply
+				connection.send(ChatMessage{Type: event.Type, Reply: &reply})
+			}
+		}
+	}()
+
+	expired := time.AfterFunc(time.Until(c.GetTime("tokenExpiresAt")), func() {
+		conn.Close()
+	})
+	defer expired.Stop()
+
+	o.hub.join(questionId, connection)
+	defer o.hub.leave(questionId, connection)
+
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			if err == websocket.ErrFrameTooLarge {
+				connection.send(ChatMessage{Type: chatMessageError, Error: "Message is too large"})
+				continue
+			}
+			return
+		}
+
+		var message ChatMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			connection.send(ChatMessage{Type: chatMessageError, Error: "Invalid message"})
+			continue
+		}
+
+		switch message.Type {
+		case chatMessageTyping:
+			o.hub.broadcast(questionId, ChatMessage{Type: chatMessageTyping, Participant: &participant}, connection)
+		case chatMessageText:
+			if errorMessage := o.postMessage(c, questionId, message.Text, message.ParentReplyId); errorMessage != "" {
+				connection.send(ChatMessage{Type: chatMessageError, Error: errorMessage})
+			}
+		default:
+			connection.send(ChatMessage{Type: chatMessageError, Error: "Unknown message type"})
+		}
+	}
+}
+
+// Stores the message as a reply, the participants receive it from the event bus.
+// Returns the description of the failure shown to the sender.
+func (o *implAmbulanceCounselingChatAPI) postMessage(c *gin.Context, questionId string, text string, parentReplyId string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "Message text is required"
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.questionDbService.FindDocument(ctx, questionId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			return "Question not found"
+		}
+		return "Database error"
+	}
+
+	switch o.counseling.addReply(ctx, c, question, &Reply{Text: text, ParentReplyId: parentReplyId}) {
+	case nil:
+		return ""
+	case errUnknownParentReply:
+		return "Parent reply not found in the question"
+	case errInvalidStatusTransition:
+		return "Question is closed, reopen it to reply"
+	case errAssignedToOtherDoctor:
+		return "Question is assigned to another doctor"
+	default:
+		return "Failed to send message"
+	}
+}
@@ -0,0 +1,115 @@
+package ambulance_counseling_wl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Opens the chat of the question as the user, the token is passed the way browsers do
+func (s *testServer) chat(questionId string, user *User) *websocket.Conn {
+	s.t.Helper()
+	httpServer := httptest.NewServer(s.router)
+	s.t.Cleanup(httpServer.Close)
+
+	token, err := GenerateJWT(user)
+	if err != nil {
+		s.t.Fatalf("failed to sign token: %v", err)
+	}
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ak-ambulance-counseling-api/questions/" + questionId + "/chat?access_token=" + token
+	conn, err := websocket.Dial(url, "", httpServer.URL)
+	if err != nil {
+		s.t.Fatalf("failed to open chat: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Reads chat messages until one of the type arrives
+func expectChatMessage(t *testing.T, conn *websocket.Conn, messageType string) ChatMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var message ChatMessage
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			t.Fatalf("failed to receive %s: %v", messageType, err)
+		}
+		if message.Type == messageType {
+			return message
+		}
+	}
+}
+
+func TestJoinQuestionChatAccess(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/chat", nil, nil), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodGet, "/questions/unknown/chat", testPatient, nil), http.StatusNotFound)
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/chat", testStranger, nil), http.StatusNotFound)
+	server.setVisibility("question-1", visibilityPublic)
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/chat", testStranger, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/chat", testPatient, nil), http.StatusBadRequest)
+}
+
+func TestQuestionChat(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	patient := server.chat("question-1", testPatient)
+	expectChatMessage(t, patient, chatMessagePresence)
+	doctor := server.chat("question-1", testDoctor)
+	if presence := expectChatMessage(t, patient, chatMessagePresence); len(presence.Participants) != 2 {
+		t.Fatalf("expected both participants present, got %+v", presence.Participants)
+	}
+
+	websocket.JSON.Send(doctor, ChatMessage{Type: chatMessageTyping})
+	if typing := expectChatMessage(t, patient, chatMessageTyping); typing.Participant == nil || typing.Participant.Name != "MUDr. Gregory House" {
+		t.Fatalf("unexpected typing indicator: %+v", typing)
+	}
+
+	websocket.JSON.Send(doctor, ChatMessage{Type: chatMessageText, Text: "Drink more water."})
+	received := expectChatMessage(t, patient, replyEventCreated)
+	if received.Reply == nil || received.Reply.Text != "Drink more water." || received.Reply.UserId != testDoctor.Id {
+		t.Fatalf("unexpected reply: %+v", received)
+	}
+	expectChatMessage(t, doctor, replyEventCreated)
+
+	// chat messages are stored the same way as replies posted to the REST API
+	question := server.question("question-1")
+	if len(question.Replies) != 1 || question.Replies[0].Id != received.Reply.Id || question.Status != statusWaitingForPatient || question.AssignedDoctorId != testDoctor.Id {
+		t.Fatalf("chat message not stored as reply: %+v", question)
+	}
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Thank you."}), http.StatusCreated)
+	if received := expectChatMessage(t, doctor, replyEventCreated); received.Reply == nil || received.Reply.Text != "Thank you." {
+		t.Fatalf("REST reply not relayed to the chat: %+v", received)
+	}
+
+	websocket.JSON.Send(patient, ChatMessage{Type: chatMessageText, Text: "  "})
+	expectChatMessage(t, patient, chatMessageError)
+
+	doctor.Close()
+	if presence := expectChatMessage(t, patient, chatMessagePresence); len(presence.Participants) != 1 || presence.Participants[0].UserId != testPatient.Id {
+		t.Fatalf("expected only the patient present, got %+v", presence.Participants)
+	}
+}
+
+func TestQuestionChatRejectsClosedQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/close", testPatient, nil), http.StatusOK)
+
+	patient := server.chat("question-1", testPatient)
+	websocket.JSON.Send(patient, ChatMessage{Type: chatMessageText, Text: "Are you there?"})
+	if message := expectChatMessage(t, patient, chatMessageError); message.Error != "Question is closed, reopen it to reply" {
+		t.Fatalf("unexpected error: %+v", message)
+	}
+	if question := server.question("question-1"); len(question.Replies) != 0 {
+		t.Fatalf("message to closed question must not be stored: %+v", question.Replies)
+	}
+}
@@ -0,0 +1,72 @@
+package ambulance_counseling_wl
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+	"github.com/gin-gonic/gin"
+)
+
+// Comments sent on idle streams so that proxies do not close the connection
+const eventKeepAliveInterval = 30 * time.Second
+
+type implAmbulanceCounselingEventsAPI struct {
+	replyEventBus event_service.EventBus[ReplyEvent]
+}
+
+func NewAmbulanceCounselingEventsApi(replyEventBus event_service.EventBus[ReplyEvent]) AmbulanceCounselingEventsAPI {
+	return &implAmbulanceCounselingEventsAPI{
+		replyEventBus: replyEventBus,
+	}
+}
+
+func (o *implAmbulanceCounselingEventsAPI) GetEvents(c *gin.Context) {
+	scope := c.DefaultQuery("scope", eventScopeQuestions)
+	if scope != eventScopeQuestions && scope != eventScopeQueue {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be questions or queue"})
+		return
+	}
+	if scope == eventScopeQueue && !isDoctor(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can follow the queue"})
+		return
+	}
+
+	userId := c.GetString("userId")
+	specialties := c.GetStringSlice("userSpecialties")
+	events, unsubscribe := o.replyEventBus.SubscribeLive()
+	defer unsubscribe()
+
+	// the stream ends together with the access token, clients reconnect with a refreshed one
+	expired := time.NewTimer(time.Until(c.GetTime("tokenExpiresAt")))
+	defer expired.Stop()
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-expired.C:
+			return false
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if receivesReplyEvent(scope, userId, specialties, event) {
+				c.SSEvent(event.Type, event)
+			}
+			return true
+		}
+	})
+}
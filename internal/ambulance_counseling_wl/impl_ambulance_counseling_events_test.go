@@ -0,0 +1,136 @@
+package ambulance_counseling_wl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamedEvent struct {
+	name string
+	data ReplyEvent
+}
+
+// Opens the event stream as the user, events are delivered to the returned channel until the test ends
+func (s *testServer) subscribe(scope string, user *User) <-chan streamedEvent {
+	s.t.Helper()
+	httpServer := httptest.NewServer(s.router)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.t.Cleanup(func() {
+		cancel()
+		httpServer.Close()
+	})
+
+	token, err := GenerateJWT(user)
+	if err != nil {
+		s.t.Fatalf("failed to sign token: %v", err)
+	}
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/ak-ambulance-counseling-api/events?scope="+scope, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		s.t.Fatalf("failed to open event stream: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		s.t.Fatalf("unexpected event stream response: %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamedEvent, 16)
+	go func() {
+		defer response.Body.Close()
+		var event streamedEvent
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event.data)
+			case line == "" && event.name != "":
+				events <- event
+				event = streamedEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func expectEvent(t *testing.T, events <-chan streamedEvent, name string, replyId string) {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.name != name || event.data.Type != name || event.data.QuestionId != "question-1" || event.data.Reply.Id != replyId {
+			t.Fatalf("expected %s of %s, got %+v", name, replyId, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", name)
+	}
+}
+
+func TestGetEventsValidatesScope(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodGet, "/events?scope=all", testPatient, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodGet, "/events?scope=queue", testPatient, nil), http.StatusForbidden)
+}
+
+func TestGetEventsStreamsReplyChanges(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	patientEvents := server.subscribe(eventScopeQuestions, testPatient)
+	queueEvents := server.subscribe(eventScopeQueue, testDoctor)
+
+	recorder := server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "It started on Monday."})
+	expectStatus(t, recorder, http.StatusCreated)
+	var patientReply Reply
+	json.Unmarshal(recorder.Body.Bytes(), &patientReply)
+	expectEvent(t, patientEvents, replyEventCreated, patientReply.Id)
+	expectEvent(t, queueEvents, replyEventCreated, patientReply.Id)
+
+	recorder = server.do(http.MethodPost, "/questions/question-1/reply", testDoctor, Reply{Text: "Drink more water."})
+	expectStatus(t, recorder, http.StatusCreated)
+	var doctorReply Reply
+	json.Unmarshal(recorder.Body.Bytes(), &doctorReply)
+	expectEvent(t, patientEvents, replyEventCreated, doctorReply.Id)
+
+	expectStatus(t, server.do(http.MethodPut, "/update/reply/"+doctorReply.Id, testDoctor, Reply{Text: "Drink at least two litres of water."}), http.StatusOK)
+	expectEvent(t, patientEvents, replyEventUpdated, doctorReply.Id)
+
+	expectStatus(t, server.do(http.MethodDelete, "/delete/reply/"+doctorReply.Id, testDoctor, nil), http.StatusNoContent)
+	expectEvent(t, patientEvents, replyEventDeleted, doctorReply.Id)
+}
+
+func TestReceivesReplyEvent(t *testing.T) {
+	event := ReplyEvent{PatientId: testPatient.Id, Category: "dermatology"}
+	claimed := ReplyEvent{PatientId: testPatient.Id, AssignedDoctorId: testDoctor.Id, Category: "cardiology"}
+
+	testCases := []struct {
+		name        string
+		scope       string
+		userId      string
+		specialties []string
+		event       ReplyEvent
+		receives    bool
+	}{
+		{"creator of the question", eventScopeQuestions, testPatient.Id, nil, event, true},
+		{"other user", eventScopeQuestions, testStranger.Id, nil, event, false},
+		{"assigned doctor", eventScopeQuestions, testDoctor.Id, nil, claimed, true},
+		{"generalist on the queue", eventScopeQueue, testDoctor.Id, nil, event, true},
+		{"specialist of other category", eventScopeQueue, testColleague.Id, testColleague.Specialties, event, false},
+		{"question claimed by colleague", eventScopeQueue, testColleague.Id, testColleague.Specialties, claimed, false},
+		{"own claimed question", eventScopeQueue, testDoctor.Id, nil, claimed, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if receives := receivesReplyEvent(tc.scope, tc.userId, tc.specialties, tc.event); receives != tc.receives {
+				t.Errorf("expected %v, got %v", tc.receives, receives)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+type implAmbulanceCounselingKnowledgeBaseAPI struct {
+	knowledgeBaseDbService db_service.DbService[KnowledgeBaseArticle]
+	userDbService          db_service.DbService[User]
+	counseling             implAmbulanceCounselingAPI
+}
+
+func NewAmbulanceCounselingKnowledgeBaseApi(knowledgeBaseDbService db_service.DbService[KnowledgeBaseArticle], userDbService db_service.DbService[User], questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply]) AmbulanceCounselingKnowledgeBaseAPI {
+	return &implAmbulanceCounselingKnowledgeBaseAPI{
+		knowledgeBaseDbService: knowledgeBaseDbService,
+		userDbService:          userDbService,
+		counseling: implAmbulanceCounselingAPI{
+			questionDbService: questionDbService,
+			replyDbService:    replyDbService,
+		},
+	}
+}
+
+func encodeArticleCursor(article *KnowledgeBaseArticle) string {
+	data, _ := json.Marshal(questionCursor{SortValue: article.PublishedAt, Id: article.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (o *implAmbulanceCounselingKnowledgeBaseAPI) GetKnowledgeBaseArticles(c *gin.Context) {
+	query := db_service.Query{
+		SortField:      "publishedAt",
+		SortDescending: true,
+		Limit:          defaultQuestionPageSize,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxQuestionPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxQuestionPageSize)})
+			return
+		}
+		query.Limit = limit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeQuestionCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		query.After = cursor
+	}
+
+	// fetch one extra document to find out whether there is a next page
+	limit := query.Limit
+	query.Limit = limit + 1
+
+	ctx := context.Background()
+	articles, err := o.knowledgeBaseDbService.FindDocumentsByQuery(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve articles"})
+		return
+	}
+
+	page := KnowledgeBasePage{Items: []KnowledgeBaseArticle{}}
+	for i, article := range articles {
+		if int64(i) == limit {
+			page.NextCursor = encodeArticleCursor(articles[i-1])
+			break
+		}
+		page.Items = append(page.Items, *article)
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (o *implAmbulanceCounselingKnowledgeBaseAPI) GetKnowledgeBaseArticleById(c *gin.Context) {
+	id := c.Param("articleId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Article ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	article, err := o.knowledgeBaseDbService.FindDocument(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, article)
+}
+
+func (o *implAmbulanceCounselingKnowledgeBaseAPI) PublishQuestion(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !isDoctor(c) && !isCreator(c, question.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can publish this question"})
+		return
+	}
+
+	if !hasDoctorReply(question) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only questions answered by a doctor can be published"})
+		return
+	}
+
+	article, err := o.buildArticle(ctx, question)
+	if err != nil {
+		log.Printf("Failed to anonymize question %s: %v", question.Id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to anonymize question"})
+		return
+	}
+
+	// publishing again refreshes the article, e.g. with later replies
+	status := http.StatusCreated
+	if question.KnowledgeBaseArticleId != "" {
+		existing, err := o.knowledgeBaseDbService.FindDocument(ctx, question.KnowledgeBaseArticleId)
+		switch err {
+		case nil:
+			article.Id = existing.Id
+			article.PublishedAt = existing.PublishedAt
+			status = http.StatusOK
+		case db_service.ErrNotFound:
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	if status == http.StatusOK {
+		err = o.knowledgeBaseDbService.UpdateDocument(ctx, article.Id, article)
+	} else {
+		err = o.knowledgeBaseDbService.CreateDocument(ctx, article.Id, article)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish question"})
+		return
+	}
+
+	if question.KnowledgeBaseArticleId != article.Id {
+		_, err = o.counseling.updateQuestion(ctx, question.Id, func(question *Question) error {
+			question.KnowledgeBaseArticleId = article.Id
+			return nil
+		})
+		if err != nil {
+			// without the link the article could not be withdrawn, so it is not kept
+			if deleteErr := o.knowledgeBaseDbService.DeleteDocument(ctx, article.Id); deleteErr != nil {
+				log.Printf("Failed to delete unlinked article %s: %v", article.Id, deleteErr)
+			}
+			writeVersionedUpdateError(c, err, "Question has been modified", "Failed to publish question")
+			return
+		}
+	}
+
+	c.JSON(status, article)
+}
+
+func (o *implAmbulanceCounselingKnowledgeBaseAPI) UnpublishQuestion(c *gin.Context) {
+	id := c.Param("questionId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Question ID is required"})
+		return
+	}
+
+	ctx := context.Background()
+	question, err := o.counseling.findQuestion(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !isDoctor(c) && !isCreator(c, question.PatientId) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and the question creator can unpublish this question"})
+		return
+	}
+
+	if question.KnowledgeBaseArticleId == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question is not published"})
+		return
+	}
+
+	err = o.knowledgeBaseDbService.DeleteDocument(ctx, question.KnowledgeBaseArticleId)
+	if err != nil && err != db_service.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unpublish question"})
+		return
+	}
+
+	_, err = o.counseling.updateQuestion(ctx, question.Id, func(question *Question) error {
+		question.KnowledgeBaseArticleId = ""
+		return nil
+	})
+	if err != nil {
+		writeVersionedUpdateError(c, err, "Question has been modified", "Failed to unpublish question")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Creates the anonymized copy of the question, the patient identity never leaves the question
+func (o *implAmbulanceCounselingKnowledgeBaseAPI) buildArticle(ctx context.Context, question *Question) (*KnowledgeBaseArticle, error) {
+	knownNames := []string{}
+	patient, err := o.userDbService.FindDocument(ctx, question.PatientId)
+	switch err {
+	case nil:
+		knownNames = append(knownNames, patient.Name)
+	case db_service.ErrNotFound:
+	default:
+		return nil, err
+	}
+	redactor := newRedactor(knownNames...)
+
+	id, err := o.counseling.generateDocumentID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	article := &KnowledgeBaseArticle{
+		Id:               id,
+		Summary:          redactor.Redact(question.Summary),
+		Question:         redactor.Redact(question.Question),
+		Replies:          []KnowledgeBaseReply{},
+		PublishedAt:      now,
+		UpdatedAt:        now,
+		SourceQuestionId: question.Id,
+	}
+	for _, reply := range question.Replies {
+		article.Replies = append(article.Replies, KnowledgeBaseReply{
+			Text:            redactor.Redact(reply.Text),
+			DoctorName:      reply.DoctorName,
+			DoctorSpecialty: reply.DoctorSpecialty,
+			CreatedAt:       reply.CreatedAt,
+		})
+	}
+	return article, nil
+}
@@ -0,0 +1,110 @@
+package ambulance_counseling_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactor(t *testing.T) {
+	redactor := newRedactor("Jana Nováková")
+
+	cases := []struct {
+		text     string
+		redacted string
+	}{
+		{"Write me at jana.novakova@example.com please.", "Write me at [email] please."},
+		{"Call +421 905 123 456 or 0905/123456.", "Call [phone] or [phone]."},
+		{"Born 12.3.1985, also written 1985-03-12.", "Born [date], also written [date]."},
+		{"I am Jana, NOVÁKOVÁ is my surname.", "I am [name], [name] is my surname."},
+		{"Mrs. Smith and MUDr. Peter Kováč know me.", "Mrs [name] and MUDr [name] know me."},
+		{"Take 400 mg 3 times a day for 10 days.", "Take 400 mg 3 times a day for 10 days."},
+	}
+	for _, tc := range cases {
+		if redacted := redactor.Redact(tc.text); redacted != tc.redacted {
+			t.Errorf("expected %q, got %q", tc.redacted, redacted)
+		}
+	}
+}
+
+func TestPublishQuestion(t *testing.T) {
+	server := newTestServer(t)
+	question := server.seedQuestion(testPatient.Id, true,
+		Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Jane, measure your blood pressure.", DoctorName: "MUDr. Gregory House", DoctorSpecialty: "Diagnostics"},
+		Reply{Id: "reply-2", UserId: testPatient.Id, Text: "Thanks, reach me at jane@example.com."},
+	)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/publish", nil, nil), http.StatusUnauthorized)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/publish", testStranger, nil), http.StatusForbidden)
+
+	recorder := server.do(http.MethodPost, "/questions/question-1/publish", testPatient, nil)
+	expectStatus(t, recorder, http.StatusCreated)
+	var article KnowledgeBaseArticle
+	if err := json.Unmarshal(recorder.Body.Bytes(), &article); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if strings.Contains(recorder.Body.String(), testPatient.Id) || strings.Contains(recorder.Body.String(), question.Id) {
+		t.Errorf("article must not reference the patient or the question: %s", recorder.Body.String())
+	}
+	if len(article.Replies) != 2 || article.Replies[0].Text != "[name], measure your blood pressure." ||
+		article.Replies[0].DoctorName != "MUDr. Gregory House" || article.Replies[1].Text != "Thanks, reach me at [email]." {
+		t.Errorf("unexpected article replies %+v", article.Replies)
+	}
+	if stored := server.question("question-1"); stored.KnowledgeBaseArticleId != article.Id {
+		t.Errorf("question not linked to article: %+v", stored)
+	}
+
+	// the knowledge base is readable without a token
+	recorder = server.do(http.MethodGet, "/knowledge-base", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var page KnowledgeBasePage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Id != article.Id {
+		t.Errorf("expected published article, got %+v", page.Items)
+	}
+	expectStatus(t, server.do(http.MethodGet, "/knowledge-base/"+article.Id, nil, nil), http.StatusOK)
+
+	// publishing again refreshes the same article
+	recorder = server.do(http.MethodPost, "/questions/question-1/publish", testDoctor, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var refreshed KnowledgeBaseArticle
+	if err := json.Unmarshal(recorder.Body.Bytes(), &refreshed); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	// stored timestamps have millisecond precision
+	if refreshed.Id != article.Id || !refreshed.PublishedAt.Equal(article.PublishedAt.Truncate(time.Millisecond)) {
+		t.Errorf("expected refreshed article %s, got %+v", article.Id, refreshed)
+	}
+}
+
+func TestPublishUnansweredQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testPatient.Id, Text: "Anyone?"})
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/publish", testPatient, nil), http.StatusConflict)
+}
+
+func TestUnpublishQuestion(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", DoctorName: "MUDr. Gregory House"})
+
+	expectStatus(t, server.do(http.MethodDelete, "/questions/question-1/publish", testPatient, nil), http.StatusNotFound)
+	recorder := server.do(http.MethodPost, "/questions/question-1/publish", testPatient, nil)
+	expectStatus(t, recorder, http.StatusCreated)
+	var article KnowledgeBaseArticle
+	if err := json.Unmarshal(recorder.Body.Bytes(), &article); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	// a published question cannot be deleted while its article stays public
+	expectStatus(t, server.do(http.MethodDelete, "/delete/question/question-1", testPatient, nil), http.StatusConflict)
+
+	expectStatus(t, server.do(http.MethodDelete, "/questions/question-1/publish", testStranger, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodDelete, "/questions/question-1/publish", testPatient, nil), http.StatusNoContent)
+	expectStatus(t, server.do(http.MethodGet, "/knowledge-base/"+article.Id, nil, nil), http.StatusNotFound)
+	expectStatus(t, server.do(http.MethodDelete, "/delete/question/question-1", testPatient, nil), http.StatusNoContent)
+}
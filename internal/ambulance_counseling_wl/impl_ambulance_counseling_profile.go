@@ -0,0 +1,107 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+type implAmbulanceCounselingProfileAPI struct {
+	userDbService     db_service.DbService[User]
+	categoryDbService db_service.DbService[Category]
+	counseling        implAmbulanceCounselingAPI
+}
+
+func NewAmbulanceCounselingProfileApi(userDbService db_service.DbService[User], categoryDbService db_service.DbService[Category], questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply]) AmbulanceCounselingProfileAPI {
+	return &implAmbulanceCounselingProfileAPI{
+		userDbService:     userDbService,
+		categoryDbService: categoryDbService,
+		counseling: implAmbulanceCounselingAPI{
+			questionDbService: questionDbService,
+			replyDbService:    replyDbService,
+		},
+	}
+}
+
+func (o *implAmbulanceCounselingProfileAPI) GetProfile(c *gin.Context) {
+	ctx := context.Background()
+	user, err := o.userDbService.FindDocument(ctx, c.GetString("userId"))
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (o *implAmbulanceCounselingProfileAPI) UpdateProfile(c *gin.Context) {
+	var form ProfileForm
+	if err := c.ShouldBindJSON(&form); err != nil || strings.TrimSpace(form.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	ctx := context.Background()
+	user, err := o.userDbService.FindDocument(ctx, c.GetString("userId"))
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if form.NotificationPreference != "" && !notificationPreferences[form.NotificationPreference] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notificationPreference must be one of immediate, daily_digest, off"})
+		return
+	}
+
+	if len(form.Specialties) > 0 && user.Type != "doctor" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only doctors have specialties"})
+		return
+	}
+	for _, category := range form.Specialties {
+		if _, err := o.categoryDbService.FindDocument(ctx, category); err != nil {
+			if err == db_service.ErrNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category " + category})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	user.Name = strings.TrimSpace(form.Name)
+	user.Title = strings.TrimSpace(form.Title)
+	user.Specialties = form.Specialties
+	if form.NotificationPreference != "" {
+		user.NotificationPreference = form.NotificationPreference
+	}
+
+	err = o.userDbService.UpdateDocument(ctx, user.Id, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	// replies keep the signature of their author, repeating the request retries the update
+	if user.Type == "doctor" {
+		err = o.counseling.updateReplyAuthor(ctx, user.Id, doctorDisplayName(user.Title, user.Name), user.Specialty)
+		if err != nil {
+			log.Printf("Failed to update replies of user %s: %v", user.Id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update replies"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,95 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGetProfile(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodGet, "/profile", nil, nil), http.StatusUnauthorized)
+
+	recorder := server.do(http.MethodGet, "/profile", testDoctor, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var user User
+	if err := json.Unmarshal(recorder.Body.Bytes(), &user); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if user.Id != testDoctor.Id || user.Title != testDoctor.Title || user.Specialty != testDoctor.Specialty {
+		t.Errorf("unexpected profile %+v", user)
+	}
+}
+
+func TestUpdateProfileResignsReplies(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	tokens := server.login(testDoctor.Email, testPassword)
+
+	recorder := server.send(http.MethodPost, "/questions/question-1/reply", tokens.Token, Reply{Text: "Drink water."})
+	expectStatus(t, recorder, http.StatusCreated)
+	var reply Reply
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if reply.DoctorName != "MUDr. Gregory House" || reply.DoctorSpecialty != "Diagnostics" {
+		t.Fatalf("reply not signed by the doctor: %+v", reply)
+	}
+
+	expectStatus(t, server.send(http.MethodPut, "/profile", tokens.Token, ProfileForm{Name: " "}), http.StatusBadRequest)
+	expectStatus(t, server.send(http.MethodPut, "/profile", tokens.Token, ProfileForm{Name: "Greg House", Title: "prof. MUDr."}), http.StatusOK)
+
+	stored, err := server.replyDbService.FindDocument(context.Background(), reply.Id)
+	if err != nil || stored.DoctorName != "prof. MUDr. Greg House" {
+		t.Errorf("reply not re-signed: %+v: %v", stored, err)
+	}
+	recorder = server.do(http.MethodGet, "/questions/question-1", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var question Question
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(question.Replies) != 1 || question.Replies[0].DoctorName != "prof. MUDr. Greg House" || question.Replies[0].Version != stored.Version {
+		t.Errorf("question served with a stale reply: %+v", question.Replies)
+	}
+
+	// the old token carries the old name, the refreshed one the new name
+	expectStatus(t, server.send(http.MethodGet, "/profile", tokens.Token, nil), http.StatusUnauthorized)
+	recorder = server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: tokens.RefreshToken})
+	expectStatus(t, recorder, http.StatusOK)
+	claims, err := ParseJWT(decodeTokens(t, recorder).Token)
+	if err != nil || claims.UserName != "Greg House" || claims.UserTitle != "prof. MUDr." {
+		t.Errorf("unexpected claims %+v: %v", claims, err)
+	}
+}
+
+func TestUpdateProfileKeepsPatientReplies(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testPatient.Id, Text: "Since Monday."})
+
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: "Jane Doe"}), http.StatusOK)
+
+	if reply := server.question("question-1").Replies[0]; reply.DoctorName != "" {
+		t.Errorf("patient reply must stay unsigned: %+v", reply)
+	}
+}
+
+func TestUpdateProfileSpecialties(t *testing.T) {
+	server := newTestServer(t)
+	tokens := server.login(testDoctor.Email, testPassword)
+
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: "Jane Patient", Specialties: []string{"cardiology"}}), http.StatusBadRequest)
+	expectStatus(t, server.send(http.MethodPut, "/profile", tokens.Token, ProfileForm{Name: "Gregory House", Specialties: []string{"allergology"}}), http.StatusBadRequest)
+	expectStatus(t, server.send(http.MethodPut, "/profile", tokens.Token, ProfileForm{Name: "Gregory House", Title: "MUDr.", Specialties: []string{"dermatology"}}), http.StatusOK)
+
+	// the queue routes by the specialties in the token
+	expectStatus(t, server.send(http.MethodGet, "/queue", tokens.Token, nil), http.StatusUnauthorized)
+	recorder := server.do(http.MethodPost, "/refresh", nil, RefreshTokenForm{RefreshToken: tokens.RefreshToken})
+	expectStatus(t, recorder, http.StatusOK)
+	claims, err := ParseJWT(decodeTokens(t, recorder).Token)
+	if err != nil || len(claims.Specialties) != 1 || claims.Specialties[0] != "dermatology" {
+		t.Errorf("unexpected claims %+v: %v", claims, err)
+	}
+}
@@ -0,0 +1,1177 @@
+package ambulance_counseling_wl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/file_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	testPatient   = &User{Id: "patient-1", Name: "Jane Patient", Email: "jane@example.com", Type: "patient", EmailVerified: true}
+	testStranger  = &User{Id: "patient-2", Name: "John Stranger", Email: "john@example.com", Type: "patient", EmailVerified: true}
+	testDoctor    = &User{Id: "doctor-1", Name: "Gregory House", Title: "MUDr.", Specialty: "Diagnostics", Email: "house@example.com", Type: "doctor", ApprovalStatus: doctorApproved, EmailVerified: true}
+	testColleague = &User{Id: "doctor-2", Name: "James Wilson", Title: "MUDr.", Specialty: "Oncology", Specialties: []string{"cardiology"}, Email: "wilson@example.com", Type: "doctor", ApprovalStatus: doctorApproved, EmailVerified: true}
+	testAdmin     = &User{Id: "admin-1", Name: "Ada Admin", Email: "ada@example.com", Type: "admin", EmailVerified: true}
+)
+
+// testCategories are available to all test questions
+var testCategories = []Category{
+	{Id: "cardiology", Name: "Cardiology"},
+	{Id: "dermatology", Name: "Dermatology"},
+}
+
+// testPassword is the password of all seeded test users
+const testPassword = "secret"
+
+type testServer struct {
+	t                        *testing.T
+	router                   *gin.Engine
+	questionDbService        db_service.DbService[Question]
+	questionFaults           *faultyDbService[Question]
+	replyDbService           db_service.DbService[Reply]
+	userDbService            db_service.DbService[User]
+	userFaults               *faultyDbService[User]
+	refreshTokenDbService    db_service.DbService[RefreshToken]
+	revokedTokenDbService    db_service.DbService[RevokedToken]
+	knowledgeBaseDbService   db_service.DbService[KnowledgeBaseArticle]
+	categoryDbService        db_service.DbService[Category]
+	webhookDbService         db_service.DbService[Webhook]
+	webhookDeliveryDbService db_service.DbService[WebhookDelivery]
+	fileStore                file_service.FileStore
+	replyEventBus            event_service.EventBus[ReplyEvent]
+	questionEventBus         event_service.EventBus[QuestionEvent]
+	outboxDbService          db_service.DbService[OutboxEvent]
+	processedEventDbService  db_service.DbService[ProcessedEvent]
+	outbox                   *Outbox
+	transactor               db_service.Transactor
+	mailer                   *testMailer
+}
+
+var errFaultyWrite = errors.New("simulated write failure")
+
+// faultyDbService fails the selected kind of writes, simulating a database failing mid-request
+type faultyDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+	failCreates bool
+	failUpdates bool
+	failDeletes bool
+}
+
+func (f *faultyDbService[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	if f.failCreates {
+		return errFaultyWrite
+	}
+	return f.DbService.CreateDocument(ctx, id, document)
+}
+
+func (f *faultyDbService[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	if f.failUpdates {
+		return errFaultyWrite
+	}
+	return f.DbService.UpdateDocument(ctx, id, document)
+}
+
+func (f *faultyDbService[DocType]) UpdateDocumentIfVersion(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	if f.failUpdates {
+		return errFaultyWrite
+	}
+	return f.DbService.UpdateDocumentIfVersion(ctx, id, expectedVersion, document)
+}
+
+func (f *faultyDbService[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	if f.failDeletes {
+		return errFaultyWrite
+	}
+	return f.DbService.DeleteDocument(ctx, id)
+}
+
+var errMailerDown = errors.New("simulated mail server failure")
+
+// testMailer records sent messages instead of delivering them
+type testMailer struct {
+	messages []mail_service.Message
+	// number of the next messages failing to send
+	failures int
+}
+
+func (m *testMailer) SendMail(ctx context.Context, message mail_service.Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errMailerDown
+	}
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	jwtSecretKey = []byte("test-secret-key")
+
+	server := &testServer{
+		t:                        t,
+		questionFaults:           &faultyDbService[Question]{DbService: db_service.NewMemoryService[Question]()},
+		replyDbService:           db_service.NewMemoryService[Reply](),
+		userFaults:               &faultyDbService[User]{DbService: db_service.NewMemoryService[User]()},
+		refreshTokenDbService:    db_service.NewMemoryService[RefreshToken](),
+		revokedTokenDbService:    db_service.NewMemoryService[RevokedToken](),
+		knowledgeBaseDbService:   db_service.NewMemoryService[KnowledgeBaseArticle](),
+		categoryDbService:        db_service.NewMemoryService[Category](),
+		webhookDbService:         db_service.NewMemoryService[Webhook](),
+		webhookDeliveryDbService: db_service.NewMemoryService[WebhookDelivery](),
+		replyEventBus:            event_service.NewMemoryBus[ReplyEvent](),
+		questionEventBus:         event_service.NewMemoryBus[QuestionEvent](),
+		fileStore:                file_service.NewDiskStore(file_service.DiskStoreConfig{Directory: t.TempDir()}),
+		outboxDbService:          db_service.NewMemoryService[OutboxEvent](),
+		processedEventDbService:  db_service.NewMemoryService[ProcessedEvent](),
+		transactor:               db_service.NewMemoryTransactor(),
+		mailer:                   &testMailer{},
+	}
+	server.questionDbService = server.questionFaults
+	server.userDbService = server.userFaults
+	// events reach the buses the same way as in the service
+	server.outbox = NewOutbox(server.outboxDbService, server.questionDbService, server.processedEventDbService, server.replyEventBus, server.questionEventBus)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.outbox.Run(ctx)
+
+	server.router = NewRouterWithGinEngine(gin.New(), ApiHandleFunctions{
+		AmbulanceCounselingAPI:              NewAmbulanceCounselingApi(server.questionDbService, server.replyDbService, server.categoryDbService, server.transactor, server.fileStore, server.outbox),
+		AmbulanceCounselingAdminAPI:         NewAmbulanceCounselingAdminApi(server.userDbService, server.refreshTokenDbService, server.mailer),
+		AmbulanceCounselingAssignmentAPI:    NewAmbulanceCounselingAssignmentApi(server.userDbService, server.questionDbService, server.replyDbService),
+		AmbulanceCounselingAttachmentAPI:    NewAmbulanceCounselingAttachmentApi(server.questionDbService, server.replyDbService, server.fileStore),
+		AmbulanceCounselingAuthAPI:          NewAmbulanceCounselingAuthApi(server.userDbService, server.refreshTokenDbService, server.revokedTokenDbService, server.mailer),
+		AmbulanceCounselingCategoryAPI:      NewAmbulanceCounselingCategoryApi(server.categoryDbService, server.questionDbService),
+		AmbulanceCounselingChatAPI:          NewAmbulanceCounselingChatApi(server.questionDbService, server.replyDbService, server.transactor, server.replyEventBus, server.outbox),
+		AmbulanceCounselingEventsAPI:        NewAmbulanceCounselingEventsApi(server.replyEventBus),
+		AmbulanceCounselingKnowledgeBaseAPI: NewAmbulanceCounselingKnowledgeBaseApi(server.knowledgeBaseDbService, server.userDbService, server.questionDbService, server.replyDbService),
+		AmbulanceCounselingProfileAPI:       NewAmbulanceCounselingProfileApi(server.userDbService, server.categoryDbService, server.questionDbService, server.replyDbService),
+		AmbulanceCounselingWebhookAPI:       NewAmbulanceCounselingWebhookApi(server.webhookDbService, server.webhookDeliveryDbService),
+	})
+	for i := range testCategories {
+		if err := server.categoryDbService.CreateDocument(context.Background(), testCategories[i].Id, &testCategories[i]); err != nil {
+			t.Fatalf("failed to seed category: %v", err)
+		}
+	}
+	// tokens are validated against the stored accounts
+	for _, user := range []*User{testPatient, testStranger, testDoctor, testColleague, testAdmin} {
+		server.seedUser(user, testPassword)
+	}
+	return server
+}
+
+// Sends a request as the given user, nil user sends it without the Authorization header
+func (s *testServer) do(method string, path string, user *User, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.doWithHeaders(method, path, user, nil, body)
+}
+
+// Sends a request as the given user with additional request headers
+func (s *testServer) doWithHeaders(method string, path string, user *User, headers map[string]string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	token := ""
+	if user != nil {
+		var err error
+		if token, err = GenerateJWT(user); err != nil {
+			s.t.Fatalf("failed to sign token: %v", err)
+		}
+	}
+	return s.sendWithHeaders(method, path, token, headers, body)
+}
+
+// Sends a request authorized with a raw access token, empty token omits the Authorization header
+func (s *testServer) send(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.sendWithHeaders(method, path, token, nil, body)
+}
+
+func (s *testServer) sendWithHeaders(method string, path string, token string, headers map[string]string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			s.t.Fatalf("failed to encode request body: %v", err)
+		}
+	}
+
+	request := httptest.NewRequest(method, "/ak-ambulance-counseling-api"+path, bytes.NewReader(payload))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *testServer) seedQuestion(patientId string, repliedTo bool, replies ...Reply) *Question {
+	s.t.Helper()
+	ctx := context.Background()
+	question := &Question{
+		Id:          "question-1",
+		PatientId:   patientId,
+		Summary:     "Headache",
+		Question:    "I have a headache every morning.",
+		CreatedAt:   time.Now(),
+		LastUpdated: time.Now(),
+		RepliedTo:   repliedTo,
+		Replies:     []Reply{},
+	}
+	for i := range replies {
+		replies[i].QuestionId = question.Id
+		if err := s.replyDbService.CreateDocument(ctx, replies[i].Id, &replies[i]); err != nil {
+			s.t.Fatalf("failed to seed reply: %v", err)
+		}
+		question.Replies = append(question.Replies, replies[i])
+	}
+	if err := s.questionDbService.CreateDocument(ctx, question.Id, question); err != nil {
+		s.t.Fatalf("failed to seed question: %v", err)
+	}
+	return question
+}
+
+func (s *testServer) setVisibility(id string, visibility string) {
+	s.t.Helper()
+	question := s.question(id)
+	question.Visibility = visibility
+	if err := s.questionDbService.UpdateDocument(context.Background(), id, question); err != nil {
+		s.t.Fatalf("failed to update question: %v", err)
+	}
+}
+
+// Loads the question together with its replies
+func (s *testServer) question(id string) *Question {
+	s.t.Helper()
+	question, err := s.questionDbService.FindDocument(context.Background(), id)
+	if err != nil {
+		s.t.Fatalf("failed to load question %v: %v", id, err)
+	}
+	replies, err := s.replyDbService.FindDocumentsByQuery(context.Background(), db_service.Query{
+		Filters:   []db_service.FieldFilter{{Field: "questionid", Operator: db_service.OpEq, Value: id}},
+		SortField: "createdat",
+	})
+	if err != nil {
+		s.t.Fatalf("failed to load replies of question %v: %v", id, err)
+	}
+	for _, reply := range replies {
+		question.Replies = append(question.Replies, *reply)
+	}
+	return question
+}
+
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestPublicRoutesDoNotRequireToken(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Drink water."})
+	server.setVisibility("question-1", visibilityPublic)
+
+	publicRoutes := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/questions", http.StatusOK},
+		{http.MethodGet, "/questions/question-1", http.StatusOK},
+		{http.MethodGet, "/questions/question-1/replies", http.StatusOK},
+		{http.MethodGet, "/questions/question-1/reply/reply-1", http.StatusOK},
+		{http.MethodPost, "/login", http.StatusBadRequest},
+		{http.MethodPost, "/register", http.StatusBadRequest},
+		{http.MethodPost, "/refresh", http.StatusBadRequest},
+		{http.MethodPost, "/password-reset/request", http.StatusBadRequest},
+		{http.MethodPost, "/password-reset/confirm", http.StatusBadRequest},
+		{http.MethodGet, "/verify-email", http.StatusBadRequest},
+		{http.MethodPost, "/verify-email/resend", http.StatusBadRequest},
+	}
+	for _, route := range publicRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			expectStatus(t, server.do(route.method, route.path, nil, nil), route.status)
+		})
+	}
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	protectedRoutes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/questions/new"},
+		{http.MethodPost, "/questions/question-1/reply"},
+		{http.MethodPut, "/update/question/question-1"},
+		{http.MethodPut, "/update/question/question-1/visibility"},
+		{http.MethodPut, "/update/reply/reply-1"},
+		{http.MethodDelete, "/delete/question/question-1"},
+		{http.MethodDelete, "/delete/reply/reply-1"},
+		{http.MethodPost, "/logout"},
+	}
+	for _, route := range protectedRoutes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			expectStatus(t, server.do(route.method, route.path, nil, nil), http.StatusUnauthorized)
+		})
+	}
+
+	t.Run("invalid token", func(t *testing.T) {
+		expectStatus(t, server.send(http.MethodPost, "/questions/new", "not-a-token", nil), http.StatusUnauthorized)
+	})
+}
+
+func TestUpdateQuestionById(t *testing.T) {
+	update := Question{Summary: "Migraine", Question: "It is a migraine after all."}
+
+	cases := []struct {
+		name      string
+		user      *User
+		repliedTo bool
+		status    int
+	}{
+		{"creator", testPatient, false, http.StatusOK},
+		{"doctor", testDoctor, false, http.StatusForbidden},
+		{"stranger", testStranger, false, http.StatusForbidden},
+		{"creator after reply", testPatient, true, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, tc.repliedTo)
+
+			expectStatus(t, server.do(http.MethodPut, "/update/question/question-1", tc.user, update), tc.status)
+
+			stored := server.question("question-1")
+			if updated := stored.Summary == update.Summary; updated != (tc.status == http.StatusOK) {
+				t.Errorf("unexpected stored summary %q", stored.Summary)
+			}
+		})
+	}
+}
+
+func TestUpdateQuestionByIdIfMatch(t *testing.T) {
+	update := Question{Summary: "Migraine", Question: "It is a migraine after all."}
+
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+
+	recorder := server.do(http.MethodGet, "/questions/question-1", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	etag := recorder.Header().Get("ETag")
+	if etag != versionETag(server.question("question-1").Version) {
+		t.Fatalf("expected ETag of the stored version, got %q", etag)
+	}
+
+	recorder = server.doWithHeaders(http.MethodPut, "/update/question/question-1", testPatient, map[string]string{"If-Match": etag}, update)
+	expectStatus(t, recorder, http.StatusOK)
+	current := recorder.Header().Get("ETag")
+	if current == "" || current == etag {
+		t.Fatalf("expected ETag of the updated version, got %q", current)
+	}
+
+	// the version seen before the update is stale now
+	stale := Question{Summary: "Stale", Question: "Written against an old version."}
+	expectStatus(t, server.doWithHeaders(http.MethodPut, "/update/question/question-1", testPatient, map[string]string{"If-Match": etag}, stale), http.StatusPreconditionFailed)
+	if stored := server.question("question-1"); stored.Summary != update.Summary {
+		t.Errorf("stale update must not be stored, got %q", stored.Summary)
+	}
+
+	// If-Match is optional, requests without it update the current version
+	recorder = server.do(http.MethodPut, "/update/question/question-1", testPatient, stale)
+	expectStatus(t, recorder, http.StatusOK)
+	if stored := server.question("question-1"); stored.Summary != stale.Summary || recorder.Header().Get("ETag") != versionETag(stored.Version) {
+		t.Errorf("expected update without If-Match, got %q with ETag %q", stored.Summary, recorder.Header().Get("ETag"))
+	}
+}
+
+func TestUpdateReplyByIdIfMatch(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Drink water."})
+
+	recorder := server.do(http.MethodGet, "/questions/question-1/reply/reply-1", testDoctor, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	etag := recorder.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag of the reply")
+	}
+
+	recorder = server.doWithHeaders(http.MethodPut, "/update/reply/reply-1", testDoctor, map[string]string{"If-Match": etag}, Reply{Text: "Drink more water."})
+	expectStatus(t, recorder, http.StatusOK)
+	if current := recorder.Header().Get("ETag"); current == "" || current == etag {
+		t.Fatalf("expected ETag of the updated version, got %q", current)
+	}
+
+	expectStatus(t, server.doWithHeaders(http.MethodPut, "/update/reply/reply-1", testDoctor, map[string]string{"If-Match": etag}, Reply{Text: "Stale."}), http.StatusPreconditionFailed)
+	expectStatus(t, server.do(http.MethodPut, "/update/reply/reply-1", testDoctor, Reply{Text: "Rest."}), http.StatusOK)
+	if reply, _ := server.replyDbService.FindDocument(context.Background(), "reply-1"); reply.Text != "Rest." {
+		t.Errorf("expected update without If-Match, got %q", reply.Text)
+	}
+}
+
+func TestDeleteQuestionById(t *testing.T) {
+	cases := []struct {
+		name   string
+		user   *User
+		status int
+	}{
+		{"creator", testPatient, http.StatusNoContent},
+		{"doctor", testDoctor, http.StatusNoContent},
+		{"stranger", testStranger, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Drink water."})
+
+			expectStatus(t, server.do(http.MethodDelete, "/delete/question/question-1", tc.user, nil), tc.status)
+
+			_, questionErr := server.questionDbService.FindDocument(context.Background(), "question-1")
+			_, replyErr := server.replyDbService.FindDocument(context.Background(), "reply-1")
+			deleted := tc.status == http.StatusNoContent
+			if (questionErr == db_service.ErrNotFound) != deleted || (replyErr == db_service.ErrNotFound) != deleted {
+				t.Errorf("unexpected storage state: question %v, reply %v", questionErr, replyErr)
+			}
+		})
+	}
+}
+
+func TestReplyToQuestion(t *testing.T) {
+	cases := []struct {
+		name       string
+		user       *User
+		status     int
+		doctorName string
+	}{
+		{"creator", testPatient, http.StatusCreated, ""},
+		{"doctor", testDoctor, http.StatusCreated, "MUDr. Gregory House"},
+		{"stranger", testStranger, http.StatusForbidden, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "How long?"})
+
+			recorder := server.do(http.MethodPost, "/questions/question-1/reply", tc.user, Reply{Text: "Since Monday."})
+			expectStatus(t, recorder, tc.status)
+
+			stored := server.question("question-1")
+			if tc.status != http.StatusCreated {
+				if len(stored.Replies) != 1 || stored.Replies[0].RepliedTo {
+					t.Errorf("question must not change on forbidden reply: %+v", stored)
+				}
+				return
+			}
+
+			var reply Reply
+			if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if reply.UserId != tc.user.Id || reply.DoctorName != tc.doctorName {
+				t.Errorf("unexpected reply author: %+v", reply)
+			}
+			if !stored.RepliedTo || len(stored.Replies) != 2 {
+				t.Fatalf("reply not added to question: %+v", stored)
+			}
+			if stored.Replies[0].RepliedTo || stored.Replies[1].RepliedTo {
+				t.Errorf("replies to the question must not lock earlier replies: %+v", stored.Replies)
+			}
+		})
+	}
+}
+
+func TestThreadedReplies(t *testing.T) {
+	server := newTestServer(t)
+	now := time.Now()
+	server.seedQuestion(testPatient.Id, true,
+		Reply{Id: "reply-1", UserId: testDoctor.Id, DoctorName: "MUDr. Gregory House", Text: "How long?", CreatedAt: now.Add(-2 * time.Minute)},
+		Reply{Id: "reply-2", UserId: testDoctor.Id, DoctorName: "MUDr. Gregory House", Text: "Any fever?", CreatedAt: now.Add(-time.Minute)},
+	)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "No.", ParentReplyId: "unknown"}), http.StatusBadRequest)
+	recorder := server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Since Monday.", ParentReplyId: "reply-1"})
+	expectStatus(t, recorder, http.StatusCreated)
+	var answer Reply
+	if err := json.Unmarshal(recorder.Body.Bytes(), &answer); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	// only the answered reply is locked
+	stored := server.question("question-1")
+	if !stored.Replies[0].RepliedTo || stored.Replies[1].RepliedTo || stored.Replies[2].RepliedTo {
+		t.Fatalf("expected only the answered reply to be locked: %+v", stored.Replies)
+	}
+	expectStatus(t, server.do(http.MethodPut, "/update/reply/reply-1", testDoctor, Reply{Text: "How long exactly?"}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPut, "/update/reply/reply-2", testDoctor, Reply{Text: "Any fever or chills?"}), http.StatusOK)
+
+	recorder = server.do(http.MethodGet, "/questions/question-1/replies", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var threads []ReplyThread
+	if err := json.Unmarshal(recorder.Body.Bytes(), &threads); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(threads) != 2 || threads[0].Id != "reply-1" || threads[1].Id != "reply-2" ||
+		len(threads[0].Replies) != 1 || threads[0].Replies[0].Id != answer.Id || len(threads[1].Replies) != 0 {
+		t.Errorf("unexpected reply tree %+v", threads)
+	}
+
+	recorder = server.do(http.MethodGet, "/questions/question-1/replies?format=flat", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var replies []Reply
+	if err := json.Unmarshal(recorder.Body.Bytes(), &replies); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(replies) != 3 || replies[0].Id != "reply-1" || replies[1].Id != "reply-2" || replies[2].Id != answer.Id {
+		t.Errorf("expected the replies oldest first, got %+v", replies)
+	}
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1/replies?format=nested", testPatient, nil), http.StatusBadRequest)
+}
+
+func TestUpdateReplyById(t *testing.T) {
+	cases := []struct {
+		name      string
+		user      *User
+		repliedTo bool
+		status    int
+	}{
+		{"author", testDoctor, false, http.StatusOK},
+		{"question creator", testPatient, false, http.StatusForbidden},
+		{"stranger", testStranger, false, http.StatusForbidden},
+		{"author after reply", testDoctor, true, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", RepliedTo: tc.repliedTo})
+
+			expectStatus(t, server.do(http.MethodPut, "/update/reply/reply-1", tc.user, Reply{Text: "Rest and drink water."}), tc.status)
+
+			stored := server.question("question-1")
+			if updated := stored.Replies[0].Text != "Rest."; updated != (tc.status == http.StatusOK) {
+				t.Errorf("unexpected reply text %q", stored.Replies[0].Text)
+			}
+		})
+	}
+}
+
+func TestDeleteReplyById(t *testing.T) {
+	cases := []struct {
+		name      string
+		user      *User
+		repliedTo bool
+		status    int
+	}{
+		{"author", testDoctor, false, http.StatusNoContent},
+		{"question creator", testPatient, false, http.StatusForbidden},
+		{"stranger", testStranger, false, http.StatusForbidden},
+		{"author after reply", testDoctor, true, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", RepliedTo: tc.repliedTo})
+
+			expectStatus(t, server.do(http.MethodDelete, "/delete/reply/reply-1", tc.user, nil), tc.status)
+
+			stored := server.question("question-1")
+			if deleted := len(stored.Replies) == 0; deleted != (tc.status == http.StatusNoContent) {
+				t.Errorf("unexpected replies %+v", stored.Replies)
+			}
+		})
+	}
+}
+
+func TestFailedWritesRollBack(t *testing.T) {
+	seed := func(t *testing.T) *testServer {
+		server := newTestServer(t)
+		server.seedQuestion(testPatient.Id, false, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest."})
+		return server
+	}
+	expectReply := func(t *testing.T, server *testServer, text string) {
+		t.Helper()
+		replies, err := server.replyDbService.FindAllDocuments(context.Background())
+		if err != nil || len(replies) != 1 || replies[0].Text != text {
+			t.Fatalf("expected the reply collection to keep only the seeded reply, got %+v, %v", replies, err)
+		}
+		if stored := server.question("question-1"); len(stored.Replies) != 1 || stored.Replies[0].Text != text || stored.RepliedTo {
+			t.Errorf("expected the question to be unchanged, got %+v", stored)
+		}
+	}
+
+	t.Run("reply", func(t *testing.T) {
+		server := seed(t)
+		server.questionFaults.failUpdates = true
+		expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Since Monday."}), http.StatusInternalServerError)
+		expectReply(t, server, "Rest.")
+	})
+
+	t.Run("update reply", func(t *testing.T) {
+		server := seed(t)
+		server.questionFaults.failUpdates = true
+		expectStatus(t, server.do(http.MethodPut, "/update/reply/reply-1", testDoctor, Reply{Text: "Rest and drink water."}), http.StatusInternalServerError)
+		expectReply(t, server, "Rest.")
+	})
+
+	t.Run("delete reply", func(t *testing.T) {
+		server := seed(t)
+		server.questionFaults.failUpdates = true
+		expectStatus(t, server.do(http.MethodDelete, "/delete/reply/reply-1", testDoctor, nil), http.StatusInternalServerError)
+		expectReply(t, server, "Rest.")
+	})
+
+	t.Run("delete question", func(t *testing.T) {
+		server := seed(t)
+		server.questionFaults.failDeletes = true
+		expectStatus(t, server.do(http.MethodDelete, "/delete/question/question-1", testPatient, nil), http.StatusInternalServerError)
+		expectReply(t, server, "Rest.")
+	})
+
+	t.Run("create question", func(t *testing.T) {
+		server := seed(t)
+		server.questionFaults.failCreates = true
+		expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Cough", Category: "cardiology"}), http.StatusInternalServerError)
+		if questions, err := server.questionDbService.FindAllDocuments(context.Background()); err != nil || len(questions) != 1 {
+			t.Errorf("expected only the seeded question, got %+v, %v", questions, err)
+		}
+		if events, err := server.outboxDbService.FindAllDocuments(context.Background()); err != nil || len(events) != 0 {
+			t.Errorf("expected the created event to be rolled back, got %+v, %v", events, err)
+		}
+	})
+
+	// the events of the failed writes are discarded with them
+	server := seed(t)
+	server.questionFaults.failUpdates = true
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Since Monday."}), http.StatusInternalServerError)
+	if events, err := server.outboxDbService.FindAllDocuments(context.Background()); err != nil || len(events) != 0 {
+		t.Errorf("expected no stored events, got %+v, %v", events, err)
+	}
+}
+
+func TestCreateQuestionBelongsToCaller(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Cough", Visibility: "everyone"}), http.StatusBadRequest)
+
+	recorder := server.do(http.MethodPost, "/questions/new", testPatient, Question{PatientId: testStranger.Id, Summary: "Cough", Category: "cardiology"})
+	expectStatus(t, recorder, http.StatusCreated)
+	var question Question
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if question.PatientId != testPatient.Id || question.Visibility != visibilityPrivate {
+		t.Errorf("expected private question of %s, got %+v", testPatient.Id, question)
+	}
+}
+
+func TestGetQuestionByIdVisibility(t *testing.T) {
+	claimedUntil := time.Now().Add(time.Hour)
+	expiredAt := time.Now().Add(-time.Minute)
+	closed := func(question *Question) { question.Status = statusClosed }
+	cases := []struct {
+		name       string
+		visibility string
+		change     func(question *Question)
+		user       *User
+		status     int
+	}{
+		{"private anonymous", visibilityPrivate, nil, nil, http.StatusNotFound},
+		{"private stranger", visibilityPrivate, nil, testStranger, http.StatusNotFound},
+		{"private creator", visibilityPrivate, closed, testPatient, http.StatusOK},
+		{"private doctor in queue", visibilityPrivate, nil, testDoctor, http.StatusOK},
+		{"private doctor closed", visibilityPrivate, closed, testDoctor, http.StatusNotFound},
+		{"private doctor claimed by colleague", visibilityPrivate, func(question *Question) {
+			question.AssignedDoctorId, question.ClaimExpiresAt = testColleague.Id, &claimedUntil
+		}, testDoctor, http.StatusNotFound},
+		{"private doctor claim expired", visibilityPrivate, func(question *Question) {
+			question.AssignedDoctorId, question.ClaimExpiresAt = testColleague.Id, &expiredAt
+		}, testDoctor, http.StatusOK},
+		{"private doctor assigned", visibilityPrivate, func(question *Question) {
+			question.Status, question.AssignedDoctorId = statusWaitingForPatient, testDoctor.Id
+		}, testDoctor, http.StatusOK},
+		{"private doctor other specialty", visibilityPrivate, func(question *Question) { question.Category = "dermatology" }, testColleague, http.StatusNotFound},
+		{"legacy stranger", "", nil, testStranger, http.StatusNotFound},
+		{"public anonymous", visibilityPublic, nil, nil, http.StatusOK},
+		{"anonymized stranger", visibilityAnonymized, nil, testStranger, http.StatusOK},
+		{"anonymized doctor closed", visibilityAnonymized, closed, testDoctor, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.seedQuestion(testPatient.Id, false)
+			server.setVisibility("question-1", tc.visibility)
+			if tc.change != nil {
+				question := server.question("question-1")
+				tc.change(question)
+				if err := server.questionDbService.UpdateDocument(context.Background(), question.Id, question); err != nil {
+					t.Fatalf("failed to update question: %v", err)
+				}
+			}
+
+			expectStatus(t, server.do(http.MethodGet, "/questions/question-1", tc.user, nil), tc.status)
+			expectStatus(t, server.do(http.MethodGet, "/questions/question-1/replies", tc.user, nil), tc.status)
+		})
+	}
+}
+
+func TestAnonymizedQuestionHidesPatient(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true,
+		Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "How long?", DoctorName: "MUDr. Gregory House"},
+		Reply{Id: "reply-2", UserId: testPatient.Id, Text: "Since Monday."},
+	)
+	server.setVisibility("question-1", visibilityAnonymized)
+
+	var question Question
+	recorder := server.do(http.MethodGet, "/questions/question-1", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if question.PatientId != "" || question.Replies[0].UserId != testDoctor.Id || question.Replies[1].UserId != "" {
+		t.Errorf("patient identity not redacted: %+v", question)
+	}
+
+	var reply Reply
+	recorder = server.do(http.MethodGet, "/questions/question-1/reply/reply-2", testStranger, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if reply.UserId != "" {
+		t.Errorf("patient identity not redacted: %+v", reply)
+	}
+
+	recorder = server.do(http.MethodGet, "/questions/question-1", testDoctor, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if question.PatientId != testPatient.Id {
+		t.Errorf("doctor must see the patient: %+v", question)
+	}
+}
+
+// Lists the ids of the questions GetQuestions returns to the user
+func (s *testServer) listedQuestionIds(path string, user *User) []string {
+	s.t.Helper()
+	recorder := s.do(http.MethodGet, path, user, nil)
+	expectStatus(s.t, recorder, http.StatusOK)
+	var page QuestionPage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		s.t.Fatalf("invalid response: %v", err)
+	}
+	ids := []string{}
+	for _, question := range page.Items {
+		ids = append(ids, question.Id)
+	}
+	return ids
+}
+
+func TestGetQuestionsVisibility(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	// equal timestamps order the questions by id
+	createdAt := time.Now()
+	claimedUntil := createdAt.Add(time.Hour)
+	for _, question := range []Question{
+		{Id: "own-private", PatientId: testPatient.Id, Visibility: visibilityPrivate},
+		{Id: "other-private", PatientId: testStranger.Id, Visibility: visibilityPrivate},
+		{Id: "other-public", PatientId: testStranger.Id, Visibility: visibilityPublic},
+		{Id: "other-anonymized", PatientId: testStranger.Id, Visibility: visibilityAnonymized},
+		{Id: "other-legacy", PatientId: testStranger.Id},
+		{Id: "other-closed", PatientId: testStranger.Id, Visibility: visibilityPrivate, Status: statusClosed},
+		{Id: "other-claimed", PatientId: testStranger.Id, Visibility: visibilityPrivate, AssignedDoctorId: testColleague.Id, ClaimExpiresAt: &claimedUntil},
+		{Id: "other-assigned", PatientId: testStranger.Id, Visibility: visibilityPrivate, Status: statusWaitingForPatient, AssignedDoctorId: testDoctor.Id},
+	} {
+		question.CreatedAt = createdAt
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		user *User
+		ids  []string
+	}{
+		{"anonymous", nil, []string{"other-anonymized", "other-public"}},
+		{"patient", testPatient, []string{"other-anonymized", "other-public", "own-private"}},
+		{"stranger", testStranger, []string{"other-anonymized", "other-assigned", "other-claimed", "other-closed", "other-legacy", "other-private", "other-public"}},
+		// doctors see the questions assigned to them and the unclaimed ones waiting for a doctor
+		{"doctor", testDoctor, []string{"other-anonymized", "other-assigned", "other-legacy", "other-private", "other-public", "own-private"}},
+		{"colleague", testColleague, []string{"other-anonymized", "other-claimed", "other-legacy", "other-private", "other-public", "own-private"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := server.listedQuestionIds("/questions?sortOrder=asc", tc.user)
+			if strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+				t.Errorf("expected %v, got %v", tc.ids, ids)
+			}
+		})
+	}
+}
+
+func TestGetQuestionsByPatient(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	createdAt := time.Now()
+	for _, question := range []Question{
+		{Id: "anonymized", PatientId: testStranger.Id, Visibility: visibilityAnonymized, Status: statusClosed},
+		{Id: "public", PatientId: testStranger.Id, Visibility: visibilityPublic, Status: statusClosed},
+	} {
+		question.CreatedAt = createdAt
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+	}
+
+	// probing another patient's id would link the anonymized questions to them
+	expectStatus(t, server.do(http.MethodGet, "/questions?patientId="+testStranger.Id, testPatient, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodGet, "/questions?patientId="+testStranger.Id, nil, nil), http.StatusBadRequest)
+
+	if ids := server.listedQuestionIds("/questions?sortOrder=asc&patientId="+testStranger.Id, testStranger); strings.Join(ids, ",") != "anonymized,public" {
+		t.Errorf("expected the patient's own questions, got %v", ids)
+	}
+	if ids := server.listedQuestionIds("/questions?sortOrder=asc&patientId="+testStranger.Id, testDoctor); strings.Join(ids, ",") != "public" {
+		t.Errorf("expected the anonymized question to be left out, got %v", ids)
+	}
+	if ids := server.listedQuestionIds("/questions?sortOrder=asc", testDoctor); strings.Join(ids, ",") != "anonymized,public" {
+		t.Errorf("expected the anonymized question without the filter, got %v", ids)
+	}
+}
+
+func TestUpdateQuestionVisibility(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true)
+
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/visibility", testDoctor, QuestionVisibilityForm{Visibility: visibilityPublic}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/visibility", testPatient, QuestionVisibilityForm{Visibility: "everyone"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/visibility", testPatient, QuestionVisibilityForm{Visibility: visibilityPublic}), http.StatusOK)
+
+	if question := server.question("question-1"); question.Visibility != visibilityPublic {
+		t.Errorf("visibility not changed: %+v", question)
+	}
+	expectStatus(t, server.do(http.MethodGet, "/questions/question-1", nil, nil), http.StatusOK)
+}
+
+func TestQuestionLifecycle(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Cough", Category: "cardiology", Status: statusResolved})
+	expectStatus(t, recorder, http.StatusCreated)
+	var question Question
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if question.Status != statusWaitingForDoctor || len(question.StatusHistory) != 1 {
+		t.Fatalf("expected new question waiting for a doctor, got %+v", question)
+	}
+	path := "/questions/" + question.Id
+
+	expectStatus(t, server.do(http.MethodPost, path+"/reply", testDoctor, Reply{Text: "How long?"}), http.StatusCreated)
+	if stored := server.question(question.Id); stored.Status != statusWaitingForPatient {
+		t.Errorf("expected %s after doctor reply, got %s", statusWaitingForPatient, stored.Status)
+	}
+	expectStatus(t, server.do(http.MethodPost, path+"/reply", testPatient, Reply{Text: "Since Monday."}), http.StatusCreated)
+	if stored := server.question(question.Id); stored.Status != statusWaitingForDoctor {
+		t.Errorf("expected %s after patient reply, got %s", statusWaitingForDoctor, stored.Status)
+	}
+
+	expectStatus(t, server.do(http.MethodPost, path+"/close", testStranger, nil), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, path+"/reopen", testPatient, nil), http.StatusConflict)
+	recorder = server.do(http.MethodPost, path+"/close", testPatient, QuestionCloseForm{Resolved: true})
+	expectStatus(t, recorder, http.StatusOK)
+	if recorder.Header().Get("ETag") == "" {
+		t.Error("expected ETag of the closed question")
+	}
+
+	// closed questions accept no replies until reopened
+	expectStatus(t, server.do(http.MethodPost, path+"/reply", testPatient, Reply{Text: "One more thing."}), http.StatusConflict)
+	expectStatus(t, server.do(http.MethodPost, path+"/close", testDoctor, nil), http.StatusConflict)
+	expectStatus(t, server.do(http.MethodPost, path+"/reopen", testDoctor, nil), http.StatusOK)
+	expectStatus(t, server.do(http.MethodPost, path+"/reply", testPatient, Reply{Text: "One more thing."}), http.StatusCreated)
+
+	stored := server.question(question.Id)
+	statuses := []string{}
+	for _, change := range stored.StatusHistory {
+		statuses = append(statuses, change.Status)
+	}
+	expected := []string{statusWaitingForDoctor, statusWaitingForPatient, statusWaitingForDoctor, statusResolved, statusWaitingForDoctor}
+	if strings.Join(statuses, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected history %v, got %v", expected, statuses)
+	}
+}
+
+func TestResolveRequiresDoctorReply(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testPatient.Id, Text: "Anyone?"})
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/close", testPatient, QuestionCloseForm{Resolved: true}), http.StatusConflict)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/close", testPatient, nil), http.StatusOK)
+	if question := server.question("question-1"); question.Status != statusClosed {
+		t.Errorf("expected closed question, got %+v", question)
+	}
+}
+
+func TestGetQuestionsByStatus(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	for _, question := range []Question{
+		{Id: "waiting", PatientId: testPatient.Id, Status: statusWaitingForDoctor},
+		{Id: "closed", PatientId: testPatient.Id, Status: statusClosed},
+	} {
+		question.CreatedAt = time.Now()
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+	}
+
+	expectStatus(t, server.do(http.MethodGet, "/questions?status=archived", testPatient, nil), http.StatusBadRequest)
+	recorder := server.do(http.MethodGet, "/questions?status="+statusClosed, testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var page QuestionPage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Id != "closed" {
+		t.Errorf("expected only the closed question, got %+v", page.Items)
+	}
+}
+
+func TestGetQuestionsIncludeReplies(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, true, Reply{Id: "reply-1", UserId: testDoctor.Id, Text: "Rest.", CreatedAt: time.Now()})
+
+	pageOf := func(path string) QuestionPage {
+		t.Helper()
+		recorder := server.do(http.MethodGet, path, testDoctor, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		var page QuestionPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return page
+	}
+
+	if page := pageOf("/questions"); len(page.Items) != 1 || len(page.Items[0].Replies) != 0 {
+		t.Errorf("replies must be left out by default, got %+v", page.Items)
+	}
+	if page := pageOf("/questions?includeReplies=true"); len(page.Items) != 1 || len(page.Items[0].Replies) != 1 || page.Items[0].Replies[0].Id != "reply-1" {
+		t.Errorf("expected the replies of the question, got %+v", page.Items)
+	}
+	expectStatus(t, server.do(http.MethodGet, "/questions?includeReplies=maybe", testDoctor, nil), http.StatusBadRequest)
+}
+
+func TestGetQuestionsPagination(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	// equal timestamps are ordered by id, so pages neither repeat nor skip them
+	createdAt := time.Now()
+	for i, id := range []string{"question-c", "question-a", "question-e", "question-b", "question-d"} {
+		question := Question{Id: id, PatientId: testPatient.Id, CreatedAt: createdAt}
+		if i >= 3 {
+			question.CreatedAt = createdAt.Add(time.Minute)
+		}
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+	}
+
+	pageOf := func(path string) QuestionPage {
+		t.Helper()
+		recorder := server.do(http.MethodGet, path, testDoctor, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		var page QuestionPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return page
+	}
+
+	cases := []struct {
+		name      string
+		sortOrder string
+		ids       []string
+	}{
+		{"ascending", "asc", []string{"question-a", "question-c", "question-e", "question-b", "question-d"}},
+		{"descending", "desc", []string{"question-d", "question-b", "question-e", "question-c", "question-a"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids := []string{}
+			pages := 0
+			cursor := ""
+			for {
+				page := pageOf("/questions?limit=2&sortOrder=" + tc.sortOrder + "&cursor=" + cursor)
+				pages++
+				for _, question := range page.Items {
+					ids = append(ids, question.Id)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				if len(page.Items) != 2 || pages > 3 {
+					t.Fatalf("expected full pages before the last one, got %+v", page)
+				}
+				cursor = page.NextCursor
+			}
+			if pages != 3 || strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+				t.Errorf("expected %v on 3 pages, got %v on %d", tc.ids, ids, pages)
+			}
+		})
+	}
+
+	t.Run("last page", func(t *testing.T) {
+		if page := pageOf("/questions?limit=5"); len(page.Items) != 5 || page.NextCursor != "" {
+			t.Errorf("expected all questions without a next cursor, got %d items and cursor %q", len(page.Items), page.NextCursor)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		expectStatus(t, server.do(http.MethodGet, "/questions?cursor=not-a-cursor", testDoctor, nil), http.StatusBadRequest)
+	})
+}
+
+// Runs the queue migration the service runs at startup on the seeded questions
+func (s *testServer) migrateQueue() {
+	s.t.Helper()
+	if _, err := MigrateQueue(context.Background(), s.questionDbService, s.replyDbService); err != nil {
+		s.t.Fatalf("failed to migrate the queue: %v", err)
+	}
+}
+
+// Reads a page of the queue of the doctor
+func (s *testServer) queuePage(path string) ([]Question, string) {
+	s.t.Helper()
+	recorder := s.do(http.MethodGet, path, testDoctor, nil)
+	expectStatus(s.t, recorder, http.StatusOK)
+	var page QuestionPage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+		s.t.Fatalf("invalid response: %v", err)
+	}
+	return page.Items, page.NextCursor
+}
+
+func TestGetQueue(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+	claimExpiresAt := now.Add(time.Hour)
+	for _, question := range []Question{
+		{Id: "normal", CreatedAt: now.Add(-3 * time.Hour), Priority: priorityNormal, Status: statusWaitingForDoctor},
+		{Id: "urgent", CreatedAt: now.Add(-time.Hour), Priority: priorityUrgent, Status: statusWaitingForDoctor},
+		{Id: "legacy", CreatedAt: now.Add(-2 * time.Hour)},
+		{Id: "follow-up", CreatedAt: now.Add(-5 * time.Hour), Priority: priorityNormal, Status: statusWaitingForDoctor, Replies: []Reply{
+			{Id: "reply-1", UserId: testDoctor.Id, DoctorName: "MUDr. Gregory House", CreatedAt: now.Add(-4 * time.Hour)},
+			{Id: "reply-2", UserId: testPatient.Id, CreatedAt: now.Add(-30 * time.Minute)},
+			{Id: "reply-3", UserId: testPatient.Id, CreatedAt: now.Add(-10 * time.Minute)},
+		}},
+		{Id: "answered", CreatedAt: now, Priority: priorityUrgent, Status: statusWaitingForPatient},
+		{Id: "legacy-answered", CreatedAt: now, Replies: []Reply{{Id: "reply-4", UserId: testDoctor.Id, DoctorName: "MUDr. Gregory House", CreatedAt: now}}},
+		{Id: "claimed", CreatedAt: now, Priority: priorityUrgent, Status: statusWaitingForDoctor, AssignedDoctorId: testColleague.Id, ClaimExpiresAt: &claimExpiresAt},
+	} {
+		question.PatientId = testPatient.Id
+		if err := server.questionDbService.CreateDocument(ctx, question.Id, &question); err != nil {
+			t.Fatalf("failed to seed question: %v", err)
+		}
+		for i := range question.Replies {
+			question.Replies[i].QuestionId = question.Id
+			if err := server.replyDbService.CreateDocument(ctx, question.Replies[i].Id, &question.Replies[i]); err != nil {
+				t.Fatalf("failed to seed reply: %v", err)
+			}
+		}
+	}
+
+	// questions stored before the waiting time was stored get it at startup
+	server.migrateQueue()
+
+	expectStatus(t, server.do(http.MethodGet, "/queue", testPatient, nil), http.StatusForbidden)
+	queue, nextCursor := server.queuePage("/queue")
+	ids := []string{}
+	for _, question := range queue {
+		ids = append(ids, question.Id)
+	}
+	if expected := "urgent,normal,legacy,follow-up"; strings.Join(ids, ",") != expected || nextCursor != "" {
+		t.Fatalf("expected queue %v on a single page, got %v", expected, ids)
+	}
+	// the patient waits since the first message after the doctor's reply
+	if waitingSince := queue[3].WaitingSince; waitingSince == nil || !waitingSince.Equal(now.Add(-30*time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("unexpected waiting time %v", waitingSince)
+	}
+
+	// pages continue across the priorities
+	ids = []string{}
+	for path := "/queue?limit=1"; ; {
+		page, nextCursor := server.queuePage(path)
+		for _, question := range page {
+			ids = append(ids, question.Id)
+		}
+		if nextCursor == "" || len(ids) > 4 {
+			break
+		}
+		path = "/queue?limit=1&cursor=" + nextCursor
+	}
+	if expected := "urgent,normal,legacy,follow-up"; strings.Join(ids, ",") != expected {
+		t.Errorf("expected paged queue %v, got %v", expected, ids)
+	}
+	expectStatus(t, server.do(http.MethodGet, "/queue?cursor=invalid", testDoctor, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodGet, "/queue?limit=0", testDoctor, nil), http.StatusBadRequest)
+}
+
+func TestQueueFollowsPatientReplies(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	server.migrateQueue()
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testDoctor, Reply{Text: "How long?"}), http.StatusCreated)
+	if queue, _ := server.queuePage("/queue"); len(queue) != 0 {
+		t.Fatalf("expected the answered question to leave the queue, got %+v", queue)
+	}
+	recorder := server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Since Monday."})
+	expectStatus(t, recorder, http.StatusCreated)
+	var reply Reply
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	queue, _ := server.queuePage("/queue")
+	if len(queue) != 1 || queue[0].WaitingSince == nil || !queue[0].WaitingSince.Equal(reply.CreatedAt.Truncate(time.Millisecond)) {
+		t.Errorf("expected the question waiting since the patient's reply, got %+v", queue)
+	}
+}
+
+func TestUpdateQuestionPriority(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Cough", Priority: "critical"}), http.StatusBadRequest)
+	server.seedQuestion(testPatient.Id, false)
+
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/priority", testStranger, QuestionPriorityForm{Priority: priorityHigh}), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/priority", testPatient, QuestionPriorityForm{Priority: "critical"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/priority", testPatient, QuestionPriorityForm{Priority: priorityHigh}), http.StatusOK)
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/priority", testDoctor, QuestionPriorityForm{Priority: priorityLow}), http.StatusOK)
+
+	// once triaged by a doctor the patient can no longer change the priority
+	expectStatus(t, server.do(http.MethodPut, "/update/question/question-1/priority", testPatient, QuestionPriorityForm{Priority: priorityUrgent}), http.StatusForbidden)
+	if question := server.question("question-1"); question.Priority != priorityLow || question.TriagedBy != testDoctor.Id {
+		t.Errorf("expected priority set by the doctor, got %+v", question)
+	}
+}
@@ -0,0 +1,220 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minWebhookSecretLength     = 16
+	defaultWebhookDeliveryPage = 50
+	maxWebhookDeliveryPage     = 200
+)
+
+type implAmbulanceCounselingWebhookAPI struct {
+	webhookDbService         db_service.DbService[Webhook]
+	webhookDeliveryDbService db_service.DbService[WebhookDelivery]
+}
+
+func NewAmbulanceCounselingWebhookApi(webhookDbService db_service.DbService[Webhook], webhookDeliveryDbService db_service.DbService[WebhookDelivery]) AmbulanceCounselingWebhookAPI {
+	return &implAmbulanceCounselingWebhookAPI{
+		webhookDbService:         webhookDbService,
+		webhookDeliveryDbService: webhookDeliveryDbService,
+	}
+}
+
+func (o *implAmbulanceCounselingWebhookAPI) GetWebhooks(c *gin.Context) {
+	ctx := context.Background()
+	webhooks, err := o.webhookDbService.FindAllDocuments(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhooks"})
+		return
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func (o *implAmbulanceCounselingWebhookAPI) CreateWebhook(c *gin.Context) {
+	var webhook Webhook
+	if err := c.ShouldBindJSON(&webhook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook data"})
+		return
+	}
+
+	if message := validateWebhook(&webhook); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	if len(webhook.Secret) < minWebhookSecretLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("secret must have at least %d characters", minWebhookSecretLength)})
+		return
+	}
+
+	id, err := generateRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate webhook ID"})
+		return
+	}
+	webhook.Id = id
+	webhook.CreatedAt = time.Now()
+
+	ctx := context.Background()
+	if err := o.webhookDbService.CreateDocument(ctx, webhook.Id, &webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	webhook.Secret = ""
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func (o *implAmbulanceCounselingWebhookAPI) UpdateWebhook(c *gin.Context) {
+	id := c.Param("webhookId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook ID is required"})
+		return
+	}
+
+	var updateData Webhook
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook data"})
+		return
+	}
+	if message := validateWebhook(&updateData); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	if updateData.Secret != "" && len(updateData.Secret) < minWebhookSecretLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("secret must have at least %d characters", minWebhookSecretLength)})
+		return
+	}
+
+	ctx := context.Background()
+	webhook, err := o.webhookDbService.FindDocument(ctx, id)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// the secret is kept unless a new one is sent
+	webhook.Url = updateData.Url
+	webhook.EventTypes = updateData.EventTypes
+	if updateData.Secret != "" {
+		webhook.Secret = updateData.Secret
+	}
+
+	if err := o.webhookDbService.UpdateDocument(ctx, id, webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	webhook.Secret = ""
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (o *implAmbulanceCounselingWebhookAPI) DeleteWebhook(c *gin.Context) {
+	id := c.Param("webhookId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook ID is required"})
+		return
+	}
+
+	// the delivery log is kept, pending deliveries fail on their next attempt
+	ctx := context.Background()
+	if err := o.webhookDbService.DeleteDocument(ctx, id); err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (o *implAmbulanceCounselingWebhookAPI) GetWebhookDeliveries(c *gin.Context) {
+	id := c.Param("webhookId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook ID is required"})
+		return
+	}
+
+	query := db_service.Query{
+		Filters:        []db_service.FieldFilter{{Field: "webhookId", Operator: db_service.OpEq, Value: id}},
+		SortField:      "createdAt",
+		SortDescending: true,
+		Limit:          defaultWebhookDeliveryPage,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxWebhookDeliveryPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxWebhookDeliveryPage)})
+			return
+		}
+		query.Limit = limit
+	}
+
+	if value := c.Query("status"); value != "" {
+		if value != webhookDeliveryPending && value != webhookDeliveryDelivered && value != webhookDeliveryFailed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, delivered, failed"})
+			return
+		}
+		query.Filters = append(query.Filters, db_service.FieldFilter{Field: "status", Operator: db_service.OpEq, Value: value})
+	}
+
+	ctx := context.Background()
+	if _, err := o.webhookDbService.FindDocument(ctx, id); err != nil {
+		if err == db_service.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	deliveries, err := o.webhookDeliveryDbService.FindDocumentsByQuery(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// Checks the endpoint and the event types of a webhook, returns the error message of invalid data
+func validateWebhook(webhook *Webhook) string {
+	endpoint, err := url.Parse(webhook.Url)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+
+	if len(webhook.EventTypes) == 0 {
+		return "At least one event type is required"
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !webhookEventTypes[eventType] {
+			return "Unknown event type " + eventType
+		}
+	}
+	return ""
+}
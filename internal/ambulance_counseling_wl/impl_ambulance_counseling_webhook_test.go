@@ -0,0 +1,181 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+// Registers a webhook of the endpoint for the event types and returns it
+func (s *testServer) webhook(url string, eventTypes ...string) Webhook {
+	s.t.Helper()
+	recorder := s.do(http.MethodPost, "/admin/webhooks", testAdmin, Webhook{Url: url, Secret: testWebhookSecret, EventTypes: eventTypes})
+	expectStatus(s.t, recorder, http.StatusCreated)
+	var webhook Webhook
+	if err := json.Unmarshal(recorder.Body.Bytes(), &webhook); err != nil {
+		s.t.Fatalf("invalid response: %v", err)
+	}
+	return webhook
+}
+
+// Lists the deliveries of the webhook, the query string filters them
+func (s *testServer) webhookDeliveries(webhookId string, query string) []WebhookDelivery {
+	s.t.Helper()
+	recorder := s.do(http.MethodGet, "/admin/webhooks/"+webhookId+"/deliveries"+query, testAdmin, nil)
+	expectStatus(s.t, recorder, http.StatusOK)
+	var deliveries []WebhookDelivery
+	if err := json.Unmarshal(recorder.Body.Bytes(), &deliveries); err != nil {
+		s.t.Fatalf("invalid response: %v", err)
+	}
+	return deliveries
+}
+
+func TestWebhookAdministration(t *testing.T) {
+	server := newTestServer(t)
+	valid := Webhook{Url: "https://example.com/hooks", Secret: testWebhookSecret, EventTypes: []string{questionEventCreated}}
+
+	expectStatus(t, server.do(http.MethodPost, "/admin/webhooks", testDoctor, valid), http.StatusForbidden)
+	expectStatus(t, server.do(http.MethodPost, "/admin/webhooks", testAdmin, Webhook{Url: "example.com/hooks", Secret: testWebhookSecret, EventTypes: valid.EventTypes}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/admin/webhooks", testAdmin, Webhook{Url: valid.Url, Secret: testWebhookSecret, EventTypes: []string{"question.deleted"}}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPost, "/admin/webhooks", testAdmin, Webhook{Url: valid.Url, Secret: "short", EventTypes: valid.EventTypes}), http.StatusBadRequest)
+
+	webhook := server.webhook(valid.Url, questionEventCreated)
+	if webhook.Id == "" || webhook.Secret != "" {
+		t.Fatalf("expected a webhook without its secret, got %+v", webhook)
+	}
+
+	recorder := server.do(http.MethodPut, "/admin/webhooks/"+webhook.Id, testAdmin, Webhook{Url: "http://example.com/events", EventTypes: []string{replyEventCreated, questionEventClosed}})
+	expectStatus(t, recorder, http.StatusOK)
+	if strings.Contains(recorder.Body.String(), testWebhookSecret) {
+		t.Errorf("the secret must never be returned: %s", recorder.Body.String())
+	}
+	stored, err := server.webhookDbService.FindDocument(context.Background(), webhook.Id)
+	if err != nil {
+		t.Fatalf("failed to load the webhook: %v", err)
+	}
+	if stored.Secret != testWebhookSecret || stored.Url != "http://example.com/events" || len(stored.EventTypes) != 2 {
+		t.Errorf("unexpected stored webhook %+v", stored)
+	}
+
+	recorder = server.do(http.MethodGet, "/admin/webhooks", testAdmin, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if strings.Contains(recorder.Body.String(), testWebhookSecret) {
+		t.Errorf("the secret must never be returned: %s", recorder.Body.String())
+	}
+
+	expectStatus(t, server.do(http.MethodGet, "/admin/webhooks/unknown/deliveries", testAdmin, nil), http.StatusNotFound)
+	expectStatus(t, server.do(http.MethodGet, "/admin/webhooks/"+webhook.Id+"/deliveries?status=lost", testAdmin, nil), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodDelete, "/admin/webhooks/"+webhook.Id, testAdmin, nil), http.StatusNoContent)
+	expectStatus(t, server.do(http.MethodDelete, "/admin/webhooks/"+webhook.Id, testAdmin, nil), http.StatusNotFound)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	server := newTestServer(t)
+	failing := true
+	var received []*http.Request
+	var bodies [][]byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer endpoint.Close()
+
+	webhook := server.webhook(endpoint.URL, questionEventClosed)
+	other := server.webhook(endpoint.URL, questionEventCreated)
+	dispatcher := NewWebhookDispatcher(server.webhookDbService, server.webhookDeliveryDbService, server.processedEventDbService, server.replyEventBus, server.questionEventBus)
+	ctx := context.Background()
+
+	server.seedQuestion(testPatient.Id, false)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/close", testPatient, nil), http.StatusOK)
+	event := <-events
+	if event.Type != questionEventClosed {
+		t.Fatalf("expected %s, got %s", questionEventClosed, event.Type)
+	}
+
+	// stored timestamps keep milliseconds only
+	now := time.Now().Truncate(time.Millisecond)
+	dispatcher.enqueue(ctx, event.Id, event.Type, event, now)
+	if deliveries := server.webhookDeliveries(other.Id, ""); len(deliveries) != 0 {
+		t.Fatalf("events must be delivered only to subscribed webhooks: %+v", deliveries)
+	}
+
+	dispatcher.deliverDue(ctx, now)
+	deliveries := server.webhookDeliveries(webhook.Id, "")
+	if len(deliveries) != 1 || deliveries[0].Status != webhookDeliveryPending || deliveries[0].Attempts != 1 || deliveries[0].ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected a pending delivery after the failed attempt, got %+v", deliveries)
+	}
+	if deliveries[0].NextAttemptAt == nil || !deliveries[0].NextAttemptAt.Equal(now.Add(webhookInitialBackoff)) {
+		t.Errorf("expected the next attempt in %v, got %v", webhookInitialBackoff, deliveries[0].NextAttemptAt)
+	}
+
+	// nothing is attempted before the backoff passes
+	failing = false
+	dispatcher.deliverDue(ctx, now.Add(time.Second))
+	if len(received) != 1 {
+		t.Fatalf("retried before the backoff passed: %d requests", len(received))
+	}
+	dispatcher.deliverDue(ctx, now.Add(webhookInitialBackoff))
+	deliveries = server.webhookDeliveries(webhook.Id, "")
+	if len(received) != 2 || deliveries[0].Status != webhookDeliveryDelivered || deliveries[0].Attempts != 2 || deliveries[0].DeliveredAt == nil {
+		t.Fatalf("expected the delivery to succeed on retry, got %+v", deliveries)
+	}
+
+	request, body := received[1], bodies[1]
+	if request.Header.Get("X-Webhook-Event") != questionEventClosed || request.Header.Get("X-Webhook-Delivery") != deliveries[0].Id {
+		t.Errorf("unexpected headers %v", request.Header)
+	}
+	if signature := signWebhookPayload(testWebhookSecret, request.Header.Get("X-Webhook-Timestamp"), body); request.Header.Get("X-Webhook-Signature") != signature {
+		t.Errorf("expected signature %s, got %s", signature, request.Header.Get("X-Webhook-Signature"))
+	}
+	var payload struct {
+		Type string        `json:"type"`
+		Data QuestionEvent `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.Type != questionEventClosed || payload.Data.Question.Id != "question-1" || payload.Data.Question.Status != statusClosed {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	server := newTestServer(t)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer endpoint.Close()
+
+	webhook := server.webhook(endpoint.URL, replyEventCreated)
+	dispatcher := NewWebhookDispatcher(server.webhookDbService, server.webhookDeliveryDbService, server.processedEventDbService, server.replyEventBus, server.questionEventBus)
+	ctx := context.Background()
+
+	now := time.Now()
+	dispatcher.enqueue(ctx, "event-1", replyEventCreated, ReplyEvent{Id: "event-1", Type: replyEventCreated, QuestionId: "question-1"}, now)
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		dispatcher.deliverDue(ctx, now)
+		now = now.Add(webhookBackoff(attempt))
+	}
+
+	deliveries := server.webhookDeliveries(webhook.Id, "")
+	if len(deliveries) != 1 || deliveries[0].Status != webhookDeliveryFailed || deliveries[0].Attempts != webhookMaxAttempts || deliveries[0].NextAttemptAt != nil {
+		t.Fatalf("expected the delivery to fail after %d attempts, got %+v", webhookMaxAttempts, deliveries)
+	}
+	dispatcher.deliverDue(ctx, now.Add(24*time.Hour))
+	if deliveries = server.webhookDeliveries(webhook.Id, "?status=failed"); len(deliveries) != 1 || deliveries[0].Attempts != webhookMaxAttempts {
+		t.Errorf("failed deliveries must not be retried: %+v", deliveries)
+	}
+}
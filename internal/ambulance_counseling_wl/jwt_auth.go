@@ -1,7 +1,11 @@
 package ambulance_counseling_wl
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -13,18 +17,48 @@ import (
 
 var jwtSecretKey = []byte(os.Getenv("AMBULANCE_COUNSELING_JWT_SECRET_KEY"))
 
+// access tokens are short-lived, sessions are extended with refresh tokens
+const accessTokenTTL = 15 * time.Minute
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+var ErrAccountDisabled = errors.New("account has been disabled")
+
+// TokenValidator performs server-side checks of an already verified token, such as revocation
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, claims *JWTClaims) error
+}
+
 type JWTClaims struct {
-	UserId   string `json:"userId"`
-	UserType string `json:"userType"`
+	UserId         string   `json:"userId"`
+	UserName       string   `json:"userName"`
+	UserTitle      string   `json:"userTitle,omitempty"`
+	Specialty      string   `json:"specialty,omitempty"`
+	Specialties    []string `json:"specialties,omitempty"`
+	UserType       string   `json:"userType"`
+	ApprovalStatus string   `json:"approvalStatus,omitempty"`
+	// absent in tokens issued before email verification was required
+	EmailVerified *bool `json:"emailVerified,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateJWT(user *User) (string, error) {
+	tokenId := make([]byte, 16)
+	if _, err := rand.Read(tokenId); err != nil {
+		return "", err
+	}
+
 	claims := JWTClaims{
-		UserId:   user.Id,
-		UserType: user.Type,
+		UserId:         user.Id,
+		UserName:       user.Name,
+		UserTitle:      user.Title,
+		Specialty:      user.Specialty,
+		Specialties:    user.Specialties,
+		UserType:       user.Type,
+		ApprovalStatus: user.ApprovalStatus,
+		EmailVerified:  &user.EmailVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        hex.EncodeToString(tokenId),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "ambulance-counseling-api",
@@ -61,30 +95,102 @@ func ParseJWT(tokenString string) (*JWTClaims, error) {
 	return nil, errors.New("invalid token")
 }
 
-func JWTAuthMiddleware() gin.HandlerFunc {
+func JWTAuthMiddleware(validator TokenValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		if c.GetHeader("Authorization") == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
 			return
 		}
+		if authenticate(c, validator) {
+			c.Next()
+		}
+	}
+}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
+// OptionalJWTAuthMiddleware lets anonymous requests through, a provided token must be valid
+func OptionalJWTAuthMiddleware(validator TokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
 			return
 		}
+		if authenticate(c, validator) {
+			c.Next()
+		}
+	}
+}
 
-		tokenString := parts[1]
-		claims, err := ParseJWT(tokenString)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			return
+// WebSocketTokenMiddleware accepts the access token from the access_token query parameter,
+// browsers cannot set the Authorization header when opening a WebSocket
+func WebSocketTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+// Verifies the bearer token and stores its claims in the context, aborts the request on failure
+func authenticate(c *gin.Context, validator TokenValidator) bool {
+	authHeader := c.GetHeader("Authorization")
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
+		return false
+	}
+
+	tokenString := parts[1]
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return false
+	}
+
+	// tokens without the claim belong to accounts verified by MigrateUsers
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		return false
+	}
+
+	if validator != nil {
+		if err := validator.ValidateToken(c.Request.Context(), claims); err != nil {
+			switch err {
+			case ErrTokenRevoked:
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			case ErrAccountDisabled:
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Account has been disabled"})
+			default:
+				log.Printf("Failed to validate token %s: %v", claims.ID, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+			}
+			return false
 		}
+	}
 
-		c.Set("userId", claims.UserId)
-		c.Set("userType", claims.UserType)
+	c.Set("userId", claims.UserId)
+	c.Set("userName", claims.UserName)
+	c.Set("userTitle", claims.UserTitle)
+	c.Set("userSpecialty", claims.Specialty)
+	c.Set("userSpecialties", claims.Specialties)
+	c.Set("userType", claims.UserType)
+	c.Set("approvalStatus", claims.ApprovalStatus)
+	c.Set("tokenId", claims.ID)
+	c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+	return true
+}
 
-		c.Next()
+// RequireUserType restricts a route to the given user types, it must follow JWTAuthMiddleware
+func RequireUserType(userTypes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userType := c.GetString("userType")
+		for _, allowed := range userTypes {
+			if userType == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 	}
 }
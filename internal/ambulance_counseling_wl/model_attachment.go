@@ -0,0 +1,36 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type Attachment struct {
+
+	// Unique identifier for the attachment
+	Id string `json:"id"`
+
+	// Name of the uploaded file
+	FileName string `json:"fileName"`
+
+	// Media type detected from the content of the file
+	ContentType string `json:"contentType"`
+
+	// Size of the file in bytes
+	Size int64 `json:"size"`
+
+	// Unique identifier for the user who uploaded the file
+	UploadedBy string `json:"uploadedBy"`
+
+	// Timestamp when the file was uploaded
+	UploadedAt time.Time `json:"uploadedAt"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type AuthTokens struct {
+
+	// JWT token for authenticated requests
+	Token string `json:"token"`
+
+	// Single-use token for obtaining a new token pair after the JWT token expires
+	RefreshToken string `json:"refreshToken"`
+
+	// Lifetime of the JWT token in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+
+	User *User `json:"user,omitempty"`
+}
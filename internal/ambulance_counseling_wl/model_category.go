@@ -0,0 +1,23 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type Category struct {
+
+	// Unique identifier of the category, a lowercase slug such as cardiology
+	Id string `json:"id" bson:"id"`
+
+	// Name of the category shown to patients
+	Name string `json:"name" bson:"name"`
+
+	// Description of the problems the category covers
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
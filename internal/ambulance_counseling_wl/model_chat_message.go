@@ -0,0 +1,36 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type ChatMessage struct {
+
+	// Kind of the message. Clients send message and typing, the server sends
+	// reply.created, reply.updated, reply.deleted, typing, presence and error.
+	Type string `json:"type"`
+
+	// Text of a message sent by the client, stored as a reply to the question
+	Text string `json:"text,omitempty"`
+
+	// Reply the message answers, empty when it answers the question
+	ParentReplyId string `json:"parentReplyId,omitempty"`
+
+	// The changed reply of reply events
+	Reply *Reply `json:"reply,omitempty"`
+
+	// Participant who is typing
+	Participant *ChatParticipant `json:"participant,omitempty"`
+
+	// Participants connected to the chat of the question, sent on presence changes
+	Participants []ChatParticipant `json:"participants,omitempty"`
+
+	// Description of a rejected message
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type ChatParticipant struct {
+
+	// Unique identifier of the user
+	UserId string `json:"userId"`
+
+	// Name shown in the chat, doctors are shown with their title
+	Name string `json:"name"`
+
+	// Type of the user (patient, doctor)
+	Type string `json:"type"`
+}
@@ -0,0 +1,17 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type DoctorRejectionForm struct {
+
+	// Reason for the rejection, sent to the doctor
+	Reason string `json:"reason,omitempty"`
+}
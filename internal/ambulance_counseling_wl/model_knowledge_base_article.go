@@ -0,0 +1,39 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type KnowledgeBaseArticle struct {
+
+	// Unique identifier for the article
+	Id string `json:"id" bson:"id"`
+
+	// Redacted summary of the question
+	Summary string `json:"summary" bson:"summary"`
+
+	// Redacted question text
+	Question string `json:"question" bson:"question"`
+
+	// Redacted replies to the question
+	Replies []KnowledgeBaseReply `json:"replies" bson:"replies"`
+
+	// Timestamp when the question was first published
+	PublishedAt time.Time `json:"publishedAt" bson:"publishedAt"`
+
+	// Timestamp when the article was last refreshed from the question
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+
+	// Question the article was published from - not exposed in JSON responses
+	SourceQuestionId string `json:"-" bson:"sourceQuestionId"`
+}
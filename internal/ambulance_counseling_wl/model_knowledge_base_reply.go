@@ -0,0 +1,30 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type KnowledgeBaseReply struct {
+
+	// Redacted text of the reply
+	Text string `json:"text" bson:"text"`
+
+	// If the reply is from a doctor, this field contains the doctor's name
+	DoctorName string `json:"doctorName,omitempty" bson:"doctorName,omitempty"`
+
+	// If the reply is from a doctor, this field contains the doctor's specialty
+	DoctorSpecialty string `json:"doctorSpecialty,omitempty" bson:"doctorSpecialty,omitempty"`
+
+	// Timestamp when the reply was created
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
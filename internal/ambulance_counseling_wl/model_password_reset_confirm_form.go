@@ -0,0 +1,20 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type PasswordResetConfirmForm struct {
+
+	// One-time token received by email
+	Token string `json:"token"`
+
+	// New password for the user account
+	Password string `json:"password"`
+}
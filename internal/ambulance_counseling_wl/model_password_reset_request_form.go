@@ -10,8 +10,8 @@
 
 package ambulance_counseling_wl
 
-type UserLogin200Response struct {
+type PasswordResetRequestForm struct {
 
-	// JWT token for authenticated requests
-	Token string `json:"token,omitempty"`
+	// Email address of the account to reset
+	Email string `json:"email"`
 }
@@ -0,0 +1,26 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type ProfileForm struct {
+
+	// Name of the user
+	Name string `json:"name"`
+
+	// Academic or professional title shown before the name, e.g. MUDr.
+	Title string `json:"title,omitempty"`
+
+	// Question categories a doctor answers, replaces the current ones
+	Specialties []string `json:"specialties,omitempty"`
+
+	// Delivery of email notifications (immediate, daily_digest, off), kept when empty
+	NotificationPreference string `json:"notificationPreference,omitempty"`
+}
@@ -28,8 +28,14 @@ type Question struct {
 	// The question text submitted by the patient
 	Question string `json:"question"`
 
-	// List of replies to the question if any
-	Replies []Reply `json:"replies,omitempty"`
+	// Identifier of the category the question belongs to
+	Category string `json:"category,omitempty"`
+
+	// Files attached to the question
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// List of replies to the question if any, stored in the reply collection and loaded with the question
+	Replies []Reply `json:"replies,omitempty" bson:"-"`
 
 	// Timestamp when the question was created
 	CreatedAt time.Time `json:"createdAt"`
@@ -39,4 +45,40 @@ type Question struct {
 
 	// Indicates if the question has been replied to, if true question cannot be edited
 	RepliedTo bool `json:"repliedTo"`
+
+	// Lifecycle status of the question (waiting_for_doctor, waiting_for_patient, resolved, closed)
+	Status string `json:"status,omitempty"`
+
+	// Status transitions of the question, oldest first
+	StatusHistory []QuestionStatusChange `json:"statusHistory,omitempty"`
+
+	// Triage priority of the question (low, normal, high, urgent)
+	Priority string `json:"priority,omitempty"`
+
+	// Identifier of the doctor who overrode the priority, the patient cannot change it afterwards
+	TriagedBy string `json:"triagedBy,omitempty"`
+
+	// Timestamp since the question waits for a doctor, the doctor queue is ordered by it
+	WaitingSince *time.Time `json:"waitingSince,omitempty"`
+
+	// Identifier of the doctor who claimed the question, other doctors cannot reply while the claim lasts
+	AssignedDoctorId string `json:"assignedDoctorId,omitempty"`
+
+	// Timestamp when the claim of the assigned doctor expires unless they reply
+	ClaimExpiresAt *time.Time `json:"claimExpiresAt,omitempty"`
+
+	// Who besides the patient and doctors may read the question (private, public, anonymized)
+	Visibility string `json:"visibility,omitempty"`
+
+	// Identifier of the anonymized knowledge base article published from the question
+	KnowledgeBaseArticleId string `json:"knowledgeBaseArticleId,omitempty"`
+
+	// Revision of the document, incremented on every update and exposed as the ETag header
+	Version int64 `json:"version" bson:"version"`
+
+	// Identifiers of the latest outbox events, an event is dispatched only once the write storing its identifier succeeded
+	EventIds []string `json:"-"`
+
+	// Events produced by the change being written, stored in the outbox together with the question
+	PendingEvents []OutboxEvent `json:"-" bson:"-"`
 }
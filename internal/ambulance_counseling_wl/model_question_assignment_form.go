@@ -0,0 +1,17 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type QuestionAssignmentForm struct {
+
+	// Unique identifier of the approved doctor who takes over the question
+	DoctorId string `json:"doctorId"`
+}
@@ -0,0 +1,17 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type QuestionCloseForm struct {
+
+	// Indicates if the question was answered to satisfaction, otherwise it is closed unresolved
+	Resolved bool `json:"resolved"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type QuestionEvent struct {
+
+	// Unique identifier of the event, redelivered events keep it
+	Id string `json:"id"`
+
+	// Kind of the change (question.created, question.closed, question.resolved, question.reopened)
+	Type string `json:"type"`
+
+	// The question after the change
+	Question Question `json:"question"`
+}
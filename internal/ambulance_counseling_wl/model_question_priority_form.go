@@ -0,0 +1,17 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type QuestionPriorityForm struct {
+
+	// Triage priority of the question (low, normal, high, urgent)
+	Priority string `json:"priority"`
+}
@@ -0,0 +1,24 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type QuestionStatusChange struct {
+
+	// Status the question moved to (waiting_for_doctor, waiting_for_patient, resolved, closed)
+	Status string `json:"status"`
+
+	// Timestamp of the transition
+	ChangedAt time.Time `json:"changedAt"`
+}
@@ -20,4 +20,16 @@ type RegistrationForm struct {
 
 	// Password for the user account
 	Password string `json:"password"`
+
+	// Type of the account to create (patient, doctor), patient if omitted
+	Type string `json:"type,omitempty"`
+
+	// Medical license number, required for doctors
+	LicenseNumber string `json:"licenseNumber,omitempty"`
+
+	// Medical specialty, required for doctors
+	Specialty string `json:"specialty,omitempty"`
+
+	// Hospital or clinic where the doctor practices, required for doctors
+	Workplace string `json:"workplace,omitempty"`
 }
@@ -19,6 +19,12 @@ type Reply struct {
 	// Unique identifier for the reply
 	Id string `json:"id"`
 
+	// Identifier of the question the reply belongs to
+	QuestionId string `json:"questionId"`
+
+	// Identifier of the reply this one answers, empty for replies to the question itself
+	ParentReplyId string `json:"parentReplyId,omitempty"`
+
 	// Unique identifier for the user who made the reply
 	UserId string `json:"userId"`
 
@@ -28,9 +34,18 @@ type Reply struct {
 	// Timestamp when the reply was created
 	CreatedAt time.Time `json:"createdAt"`
 
-	// Indicates if there is another reply to the question, if true reply cannot be edited or deleted
+	// Indicates if another reply answers this one, if true reply cannot be edited or deleted
 	RepliedTo bool `json:"repliedTo"`
 
 	// If the reply is from a doctor, this field contains the doctor's name
 	DoctorName string `json:"doctorName,omitempty"`
+
+	// If the reply is from a doctor, this field contains the doctor's specialty
+	DoctorSpecialty string `json:"doctorSpecialty,omitempty"`
+
+	// Files attached to the reply
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Revision of the document, incremented on every update and exposed as the ETag header
+	Version int64 `json:"version" bson:"version"`
 }
@@ -0,0 +1,38 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type ReplyEvent struct {
+
+	// Unique identifier of the event, redelivered events keep it
+	Id string `json:"id"`
+
+	// Kind of the change (reply.created, reply.updated, reply.deleted)
+	Type string `json:"type"`
+
+	// Unique identifier of the question the reply belongs to
+	QuestionId string `json:"questionId"`
+
+	// The reply after the change, deleted replies carry their last state
+	Reply Reply `json:"reply"`
+
+	// Creator of the question, used to route the event to subscribers
+	PatientId string `json:"-"`
+
+	// Doctor holding the question, used to route the event to subscribers
+	AssignedDoctorId string `json:"-"`
+
+	// Category of the question, used to route the event to subscribers
+	Category string `json:"-"`
+
+	// Summary of the question, used in notifications
+	Summary string `json:"-"`
+}
@@ -0,0 +1,18 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type ReplyThread struct {
+	Reply
+
+	// Replies answering the reply, oldest first
+	Replies []ReplyThread `json:"replies"`
+}
@@ -10,6 +10,10 @@
 
 package ambulance_counseling_wl
 
+import (
+	"time"
+)
+
 type User struct {
 
 	// Unique identifier for the user
@@ -18,12 +22,54 @@ type User struct {
 	// Name of the user
 	Name string `json:"name" bson:"name"`
 
+	// Academic or professional title shown before the name, e.g. MUDr.
+	Title string `json:"title,omitempty" bson:"title,omitempty"`
+
 	// Email address of the user
 	Email string `json:"email" bson:"email"`
 
-	// Type of user (patient, doctor)
+	// Type of user (patient, doctor, admin)
 	Type string `json:"type" bson:"type"`
 
+	// Disabled accounts cannot log in and their tokens are rejected
+	Disabled bool `json:"disabled" bson:"disabled"`
+
+	// Medical license number of a doctor
+	LicenseNumber string `json:"licenseNumber,omitempty" bson:"licenseNumber,omitempty"`
+
+	// Medical specialty of a doctor
+	Specialty string `json:"specialty,omitempty" bson:"specialty,omitempty"`
+
+	// Question categories a doctor answers, doctors without any answer every category
+	Specialties []string `json:"specialties,omitempty" bson:"specialties,omitempty"`
+
+	// Hospital or clinic where the doctor practices
+	Workplace string `json:"workplace,omitempty" bson:"workplace,omitempty"`
+
+	// Verification of the doctor credentials (pending, approved, rejected)
+	ApprovalStatus string `json:"approvalStatus,omitempty" bson:"approvalStatus,omitempty"`
+
+	// Reason given by the administrator who rejected the doctor
+	RejectionReason string `json:"rejectionReason,omitempty" bson:"rejectionReason,omitempty"`
+
+	// Indicates if the user confirmed ownership of the email address
+	EmailVerified bool `json:"emailVerified" bson:"emailVerified"`
+
+	// Delivery of email notifications (immediate, daily_digest, off), immediate when empty
+	NotificationPreference string `json:"notificationPreference,omitempty" bson:"notificationPreference,omitempty"`
+
 	// Hashed password - not exposed in JSON responses
 	PasswordHash string `json:"-" bson:"passwordHash"`
+
+	// Hash of the pending password reset token - not exposed in JSON responses
+	PasswordResetTokenHash string `json:"-" bson:"passwordResetTokenHash,omitempty"`
+
+	// Expiration of the pending password reset token - not exposed in JSON responses
+	PasswordResetExpiresAt *time.Time `json:"-" bson:"passwordResetExpiresAt,omitempty"`
+
+	// Hash of the pending email verification token - not exposed in JSON responses
+	EmailVerificationTokenHash string `json:"-" bson:"emailVerificationTokenHash,omitempty"`
+
+	// Expiration of the pending email verification token - not exposed in JSON responses
+	EmailVerificationExpiresAt *time.Time `json:"-" bson:"emailVerificationExpiresAt,omitempty"`
 }
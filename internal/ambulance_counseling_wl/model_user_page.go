@@ -0,0 +1,20 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+type UserPage struct {
+
+	// User accounts on the current page
+	Items []User `json:"items"`
+
+	// Opaque cursor for retrieving the next page, absent on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
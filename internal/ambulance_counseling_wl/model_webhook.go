@@ -0,0 +1,33 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type Webhook struct {
+
+	// Unique identifier of the subscription
+	Id string `json:"id" bson:"id"`
+
+	// Endpoint receiving the events, an absolute http or https URL
+	Url string `json:"url" bson:"url"`
+
+	// Key of the HMAC-SHA256 signature of the payloads, never returned by the API
+	Secret string `json:"secret,omitempty" bson:"secret"`
+
+	// Event types delivered to the endpoint
+	EventTypes []string `json:"eventTypes" bson:"eventTypes"`
+
+	// Timestamp when the subscription was created
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
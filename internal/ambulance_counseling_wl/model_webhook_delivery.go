@@ -0,0 +1,51 @@
+/*
+ * Waiting List Api
+ *
+ * Ambulance Counseling Project API
+ *
+ * API version: 1.0.0
+ * Contact: xkoricansky@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_counseling_wl
+
+import (
+	"time"
+)
+
+type WebhookDelivery struct {
+
+	// Unique identifier of the delivery, sent in the X-Webhook-Delivery header
+	Id string `json:"id" bson:"id"`
+
+	// Subscription the event is delivered to
+	WebhookId string `json:"webhookId" bson:"webhookId"`
+
+	// Type of the delivered event
+	EventType string `json:"eventType" bson:"eventType"`
+
+	// JSON body sent to the endpoint, the same on every attempt
+	Payload string `json:"payload" bson:"payload"`
+
+	// State of the delivery (pending, delivered, failed)
+	Status string `json:"status" bson:"status"`
+
+	// Number of attempts made so far
+	Attempts int `json:"attempts" bson:"attempts"`
+
+	// HTTP status returned by the endpoint on the last attempt
+	ResponseStatus int `json:"responseStatus,omitempty" bson:"responseStatus,omitempty"`
+
+	// Reason of the last failed attempt
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+
+	// Timestamp when the event occurred
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// Timestamp of the next attempt of a pending delivery
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" bson:"nextAttemptAt,omitempty"`
+
+	// Timestamp when the endpoint accepted the event
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty" bson:"deliveredAt,omitempty"`
+}
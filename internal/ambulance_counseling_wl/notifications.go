@@ -0,0 +1,303 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
+)
+
+// notification preferences of users
+const (
+	notifyImmediately = "immediate"
+	notifyDailyDigest = "daily_digest"
+	notifyOff         = "off"
+)
+
+var notificationPreferences = map[string]bool{
+	notifyImmediately: true,
+	notifyDailyDigest: true,
+	notifyOff:         true,
+}
+
+// name of the notifier in the records of processed events
+const notifierConsumer = "notifier"
+
+const (
+	notificationReply    = "reply"
+	notificationQuestion = "question"
+)
+
+const (
+	// new questions are batched for doctors who want immediate notifications
+	questionBatchWindow = 15 * time.Minute
+	dailyDigestWindow   = 24 * time.Hour
+	// how often pending notifications are checked for due digests
+	digestCheckInterval = time.Minute
+)
+
+// PendingNotification waits for the next digest of its recipient
+type PendingNotification struct {
+	Id string `bson:"id"`
+
+	UserId string `bson:"userId"`
+
+	// reply or question
+	Type string `bson:"type"`
+
+	QuestionId string `bson:"questionId"`
+
+	// Line describing the event in the digest
+	Text string `bson:"text"`
+
+	CreatedAt time.Time `bson:"createdAt"`
+
+	// immediate notification whose email failed, it is due on the next check
+	Retry bool `bson:"retry,omitempty"`
+}
+
+// Notifier emails users about replies to their questions and doctors about new questions
+// in their specialties, according to the notification preference of each user
+type Notifier struct {
+	userDbService           db_service.DbService[User]
+	notificationDbService   db_service.DbService[PendingNotification]
+	processedEventDbService db_service.DbService[ProcessedEvent]
+	mailer                  mail_service.Mailer
+	replyEventBus           event_service.EventBus[ReplyEvent]
+	questionEventBus        event_service.EventBus[QuestionEvent]
+}
+
+func NewNotifier(userDbService db_service.DbService[User], notificationDbService db_service.DbService[PendingNotification], processedEventDbService db_service.DbService[ProcessedEvent], mailer mail_service.Mailer, replyEventBus event_service.EventBus[ReplyEvent], questionEventBus event_service.EventBus[QuestionEvent]) *Notifier {
+	return &Notifier{
+		userDbService:           userDbService,
+		notificationDbService:   notificationDbService,
+		processedEventDbService: processedEventDbService,
+		mailer:                  mailer,
+		replyEventBus:           replyEventBus,
+		questionEventBus:        questionEventBus,
+	}
+}
+
+// Run delivers notifications of published events and due digests until the context ends
+func (n *Notifier) Run(ctx context.Context) {
+	replies, unsubscribeReplies := n.replyEventBus.Subscribe()
+	defer unsubscribeReplies()
+	questions, unsubscribeQuestions := n.questionEventBus.Subscribe()
+	defer unsubscribeQuestions()
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-replies:
+			n.notifyReply(ctx, event)
+		case event := <-questions:
+			n.notifyQuestion(ctx, event)
+		case now := <-ticker.C:
+			n.sendDigests(ctx, now)
+			forgetProcessedEvents(ctx, n.processedEventDbService, now)
+		}
+	}
+}
+
+// The patient learns about doctor replies, the assigned doctor about replies of the patient
+func (n *Notifier) notifyReply(ctx context.Context, event ReplyEvent) {
+	if event.Type != replyEventCreated {
+		acknowledgeEvent(ctx, n.processedEventDbService, notifierConsumer, event.Id)
+		return
+	}
+	if !claimEvent(ctx, n.processedEventDbService, notifierConsumer, event.Id) {
+		return
+	}
+
+	var err error
+	switch {
+	case event.Reply.UserId == event.PatientId && event.AssignedDoctorId != "":
+		err = n.notify(ctx, event.AssignedDoctorId, notificationReply, event.QuestionId, fmt.Sprintf("The patient replied to the question %q", event.Summary))
+	case event.Reply.UserId != event.PatientId && event.Reply.DoctorName != "":
+		err = n.notify(ctx, event.PatientId, notificationReply, event.QuestionId, fmt.Sprintf("%s answered your question %q", event.Reply.DoctorName, event.Summary))
+	}
+	n.finish(ctx, event.Id, err)
+}
+
+// Doctors handling the category of a new question receive it in their next batch
+func (n *Notifier) notifyQuestion(ctx context.Context, event QuestionEvent) {
+	if event.Type != questionEventCreated {
+		acknowledgeEvent(ctx, n.processedEventDbService, notifierConsumer, event.Id)
+		return
+	}
+	if !claimEvent(ctx, n.processedEventDbService, notifierConsumer, event.Id) {
+		return
+	}
+
+	doctors, err := n.userDbService.FindDocumentsByField(ctx, "type", "doctor")
+	if err != nil {
+		log.Printf("Failed to find doctors to notify about question %s: %v", event.Question.Id, err)
+		n.finish(ctx, event.Id, err)
+		return
+	}
+	var failure error
+	for _, doctor := range doctors {
+		if doctor.ApprovalStatus != doctorApproved || !handlesCategory(doctor.Specialties, event.Question.Category) {
+			continue
+		}
+		if err := n.notify(ctx, doctor.Id, notificationQuestion, event.Question.Id, fmt.Sprintf("New question %q", event.Question.Summary)); err != nil {
+			failure = err
+		}
+	}
+	n.finish(ctx, event.Id, failure)
+}
+
+// Acknowledges the handled event to the outbox. A failed event is released instead, the outbox
+// publishes it again and it is handled anew.
+func (n *Notifier) finish(ctx context.Context, eventId string, err error) {
+	if err != nil {
+		releaseEvent(ctx, n.processedEventDbService, notifierConsumer, eventId)
+		return
+	}
+	acknowledgeEvent(ctx, n.processedEventDbService, notifierConsumer, eventId)
+}
+
+// Mails the notification right away or keeps it for the digest of the user. A failed email is
+// kept for the next check, returns an error when the notification could not be kept either.
+func (n *Notifier) notify(ctx context.Context, userId string, notificationType string, questionId string, text string) error {
+	user, err := n.userDbService.FindDocument(ctx, userId)
+	if err == db_service.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to find user %s to notify: %v", userId, err)
+		return err
+	}
+	preference := notificationPreference(user)
+	if preference == notifyOff || user.Disabled || !user.EmailVerified {
+		return nil
+	}
+
+	retry := false
+	if preference == notifyImmediately && notificationType == notificationReply {
+		err = n.mailer.SendMail(ctx, mail_service.Message{
+			To:      user.Email,
+			Subject: "New reply to your question",
+			Body:    fmt.Sprintf("Hello %s,\n\n%s.\n%s\n", user.Name, text, questionLink(questionId)),
+		})
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to notify user %s, retrying with the next check: %v", user.Id, err)
+		retry = true
+	}
+
+	id, err := generateRandomID()
+	if err != nil {
+		log.Printf("Failed to generate notification ID: %v", err)
+		return err
+	}
+	err = n.notificationDbService.CreateDocument(ctx, id, &PendingNotification{
+		Id:         id,
+		UserId:     user.Id,
+		Type:       notificationType,
+		QuestionId: questionId,
+		Text:       text,
+		CreatedAt:  time.Now(),
+		Retry:      retry,
+	})
+	if err != nil {
+		log.Printf("Failed to store notification for user %s: %v", user.Id, err)
+	}
+	return err
+}
+
+// Mails one digest to every user whose oldest pending notification waited for the whole window
+// of their preference or who has a notification to retry. Notifications of failed deliveries
+// stay pending for the next check.
+func (n *Notifier) sendDigests(ctx context.Context, now time.Time) {
+	pending, err := n.notificationDbService.FindAllDocuments(ctx)
+	if err != nil {
+		log.Printf("Failed to load pending notifications: %v", err)
+		return
+	}
+
+	byUser := map[string][]*PendingNotification{}
+	for _, notification := range pending {
+		byUser[notification.UserId] = append(byUser[notification.UserId], notification)
+	}
+
+	for userId, notifications := range byUser {
+		sort.Slice(notifications, func(i, j int) bool {
+			return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+		})
+
+		user, err := n.userDbService.FindDocument(ctx, userId)
+		if err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to find user %s for the digest: %v", userId, err)
+			continue
+		}
+
+		// preferences changed to off and removed accounts drop what is pending
+		if err == nil && notificationPreference(user) != notifyOff && !user.Disabled {
+			window := questionBatchWindow
+			if notificationPreference(user) == notifyDailyDigest {
+				window = dailyDigestWindow
+			}
+			if !slices.ContainsFunc(notifications, isRetry) && now.Sub(notifications[0].CreatedAt) < window {
+				continue
+			}
+			if err := n.mailer.SendMail(ctx, digestMessage(user, notifications)); err != nil {
+				log.Printf("Failed to send digest to user %s: %v", userId, err)
+				continue
+			}
+		}
+
+		for _, notification := range notifications {
+			if err := n.notificationDbService.DeleteDocument(ctx, notification.Id); err != nil && err != db_service.ErrNotFound {
+				log.Printf("Failed to delete notification %s: %v", notification.Id, err)
+			}
+		}
+	}
+}
+
+func digestMessage(user *User, notifications []*PendingNotification) mail_service.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hello %s,\n\nhere is what happened since the last summary:\n\n", user.Name)
+	for _, notification := range notifications {
+		fmt.Fprintf(&body, "- %s\n  %s\n", notification.Text, questionLink(notification.QuestionId))
+	}
+
+	subject := "New questions in your specialties"
+	for _, notification := range notifications {
+		if notification.Type == notificationReply {
+			subject = "Summary of your questions"
+			break
+		}
+	}
+	return mail_service.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    body.String(),
+	}
+}
+
+func isRetry(notification *PendingNotification) bool {
+	return notification.Retry
+}
+
+func notificationPreference(user *User) string {
+	if user.NotificationPreference == "" {
+		return notifyImmediately
+	}
+	return user.NotificationPreference
+}
+
+func questionLink(questionId string) string {
+	return fmt.Sprintf("%s/questions/%s", webAppUrl, questionId)
+}
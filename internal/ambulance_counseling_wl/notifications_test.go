@@ -0,0 +1,151 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// Creates a notifier of the test server, events are handed to it by the tests
+func (s *testServer) notifier() (*Notifier, db_service.DbService[PendingNotification]) {
+	notificationDbService := db_service.NewMemoryService[PendingNotification]()
+	return NewNotifier(s.userDbService, notificationDbService, s.processedEventDbService, s.mailer, s.replyEventBus, s.questionEventBus), notificationDbService
+}
+
+// Replies as the user and returns the published event
+func (s *testServer) replyEvent(user *User, text string) ReplyEvent {
+	s.t.Helper()
+	events, unsubscribe := s.replyEventBus.Subscribe()
+	defer unsubscribe()
+	expectStatus(s.t, s.do(http.MethodPost, "/questions/question-1/reply", user, Reply{Text: text}), http.StatusCreated)
+	// events of earlier replies may still be on their way from the outbox
+	for event := range events {
+		if event.Reply.Text == text {
+			return event
+		}
+	}
+	return ReplyEvent{}
+}
+
+func pendingNotifications(t *testing.T, notificationDbService db_service.DbService[PendingNotification]) []*PendingNotification {
+	t.Helper()
+	pending, err := notificationDbService.FindAllDocuments(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load notifications: %v", err)
+	}
+	return pending
+}
+
+func TestNotifyAboutReplies(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	notifier, notificationDbService := server.notifier()
+	ctx := context.Background()
+
+	notifier.notifyReply(ctx, server.replyEvent(testDoctor, "Drink more water."))
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].To != testPatient.Email || !strings.Contains(server.mailer.messages[0].Body, "MUDr. Gregory House answered your question \"Headache\"") {
+		t.Fatalf("expected the patient to be notified, got %+v", server.mailer.messages)
+	}
+
+	notifier.notifyReply(ctx, server.replyEvent(testPatient, "Thank you."))
+	if len(server.mailer.messages) != 2 || server.mailer.messages[1].To != testDoctor.Email || !strings.Contains(server.mailer.messages[1].Body, "/questions/question-1") {
+		t.Fatalf("expected the assigned doctor to be notified, got %+v", server.mailer.messages)
+	}
+	if pending := pendingNotifications(t, notificationDbService); len(pending) != 0 {
+		t.Errorf("immediate notifications must not wait for a digest: %+v", pending)
+	}
+}
+
+func TestNotificationRetriedAfterMailFailure(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	notifier, notificationDbService := server.notifier()
+	ctx := context.Background()
+
+	server.mailer.failures = 1
+	event := server.replyEvent(testDoctor, "Drink more water.")
+	notifier.notifyReply(ctx, event)
+	if pending := pendingNotifications(t, notificationDbService); len(server.mailer.messages) != 0 || len(pending) != 1 || !pending[0].Retry {
+		t.Fatalf("expected the failed notification to wait for a retry, sent %+v pending %+v", server.mailer.messages, pending)
+	}
+
+	// the retry does not wait for the window of a digest
+	notifier.sendDigests(ctx, time.Now())
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].To != testPatient.Email || !strings.Contains(server.mailer.messages[0].Body, "answered your question \"Headache\"") {
+		t.Fatalf("expected the retried notification, got %+v", server.mailer.messages)
+	}
+	if pending := pendingNotifications(t, notificationDbService); len(pending) != 0 {
+		t.Errorf("sent notifications must be removed: %+v", pending)
+	}
+
+	// the kept notification completed the event, a redelivery sends nothing
+	notifier.notifyReply(ctx, event)
+	if len(server.mailer.messages) != 1 || len(pendingNotifications(t, notificationDbService)) != 0 {
+		t.Errorf("redelivered event must not notify again: %+v", server.mailer.messages)
+	}
+}
+
+func TestNotificationPreference(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	notifier, notificationDbService := server.notifier()
+	ctx := context.Background()
+
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: testPatient.Name, NotificationPreference: "weekly"}), http.StatusBadRequest)
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: testPatient.Name, NotificationPreference: notifyDailyDigest}), http.StatusOK)
+
+	notifier.notifyReply(ctx, server.replyEvent(testDoctor, "Drink more water."))
+	if len(server.mailer.messages) != 0 || len(pendingNotifications(t, notificationDbService)) != 1 {
+		t.Fatalf("expected the reply to wait for the digest, sent %+v", server.mailer.messages)
+	}
+
+	notifier.sendDigests(ctx, time.Now().Add(time.Hour))
+	if len(server.mailer.messages) != 0 {
+		t.Fatalf("digest sent before the day passed: %+v", server.mailer.messages)
+	}
+	notifier.sendDigests(ctx, time.Now().Add(dailyDigestWindow))
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].Subject != "Summary of your questions" {
+		t.Fatalf("expected the daily digest, got %+v", server.mailer.messages)
+	}
+	if pending := pendingNotifications(t, notificationDbService); len(pending) != 0 {
+		t.Errorf("sent notifications must be removed: %+v", pending)
+	}
+
+	// the profile keeps the preference unless a new one is sent
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: testPatient.Name}), http.StatusOK)
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/reply", testPatient, Reply{Text: "Thank you."}), http.StatusCreated)
+	expectStatus(t, server.do(http.MethodPut, "/profile", testPatient, ProfileForm{Name: testPatient.Name, NotificationPreference: notifyOff}), http.StatusOK)
+	notifier.notifyReply(ctx, server.replyEvent(testDoctor, "You are welcome."))
+	if len(server.mailer.messages) != 1 || len(pendingNotifications(t, notificationDbService)) != 0 {
+		t.Fatalf("notifications switched off must not be sent or stored: %+v", server.mailer.messages)
+	}
+}
+
+func TestNotifyDoctorsAboutNewQuestions(t *testing.T) {
+	server := newTestServer(t)
+	notifier, notificationDbService := server.notifier()
+	ctx := context.Background()
+
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	expectStatus(t, server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Rash", Question: "My arm itches.", Category: "dermatology"}), http.StatusCreated)
+	notifier.notifyQuestion(ctx, <-events)
+
+	// the cardiologist does not handle dermatology, the generalist gets a batch
+	pending := pendingNotifications(t, notificationDbService)
+	if len(pending) != 1 || pending[0].UserId != testDoctor.Id {
+		t.Fatalf("expected a notification for %s only, got %+v", testDoctor.Id, pending)
+	}
+	notifier.sendDigests(ctx, time.Now())
+	if len(server.mailer.messages) != 0 {
+		t.Fatalf("new questions must be batched: %+v", server.mailer.messages)
+	}
+	notifier.sendDigests(ctx, time.Now().Add(questionBatchWindow))
+	if len(server.mailer.messages) != 1 || server.mailer.messages[0].To != testDoctor.Email || !strings.Contains(server.mailer.messages[0].Body, "New question \"Rash\"") {
+		t.Fatalf("expected the batch of new questions, got %+v", server.mailer.messages)
+	}
+}
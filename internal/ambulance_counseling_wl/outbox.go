@@ -0,0 +1,365 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+)
+
+const (
+	// how often the outbox is checked for events without a wake up after a write
+	outboxPollInterval = 5 * time.Second
+	// events whose question write did not succeed within this time are discarded
+	outboxCommitTimeout = time.Minute
+	// dispatched events and processed event records are kept for this long
+	outboxRetention = 24 * time.Hour
+	// identifiers of committed events kept in a question
+	maxQuestionEventIds = 100
+	// how long the dispatcher waits for slow consumers before it retries the event
+	outboxPublishTimeout = 10 * time.Second
+	// how long the dispatcher waits for the consumers to acknowledge a published event before
+	// it publishes the event again, also when a claim of a consumer that stopped is taken over
+	outboxAckTimeout = time.Minute
+)
+
+// OutboxConsumers names the durable consumers of the service, the notifier and the webhook dispatcher
+var OutboxConsumers = []string{notifierConsumer, webhookConsumer}
+
+// OutboxEvent is a domain event stored in the outbox collection until it is dispatched
+type OutboxEvent struct {
+	Id string `bson:"id"`
+
+	// Question whose write produced the event
+	QuestionId string `bson:"questionId"`
+
+	// reply.* or question.*
+	Type string `bson:"type"`
+
+	// Set for reply events
+	ReplyEvent *ReplyEvent `bson:"replyEvent,omitempty"`
+
+	// Set for question events
+	QuestionEvent *QuestionEvent `bson:"questionEvent,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt"`
+
+	// Timestamp when the event was last published to the consumers
+	PublishedAt *time.Time `bson:"publishedAt,omitempty"`
+
+	// Timestamp when every consumer acknowledged the event
+	DispatchedAt *time.Time `bson:"dispatchedAt,omitempty"`
+}
+
+// ProcessedEvent records that a consumer handles an event, so that redelivered events are skipped.
+// Once the consumer finished the event the record acknowledges it to the outbox.
+type ProcessedEvent struct {
+	// consumer and event identifier separated by a colon
+	Id string `bson:"id"`
+
+	ProcessedAt time.Time `bson:"processedAt"`
+
+	Acknowledged bool `bson:"acknowledged"`
+}
+
+// Outbox makes events as durable as the writes producing them. Events are stored in the outbox
+// collection right before the question write, which records their identifiers in the question.
+// The dispatcher publishes an event only after finding its identifier in the question, events of
+// writes that failed or never happened are discarded. An event stays pending and is published
+// again until each of the durable consumers acknowledged it. Events are delivered at least once,
+// consumers skip redelivered events by their identifier.
+type Outbox struct {
+	outboxDbService         db_service.DbService[OutboxEvent]
+	questionDbService       db_service.DbService[Question]
+	processedEventDbService db_service.DbService[ProcessedEvent]
+	replyEventBus           event_service.EventBus[ReplyEvent]
+	questionEventBus        event_service.EventBus[QuestionEvent]
+	// names of the consumers acknowledging every event
+	consumers []string
+	wake      chan struct{}
+}
+
+func NewOutbox(outboxDbService db_service.DbService[OutboxEvent], questionDbService db_service.DbService[Question], processedEventDbService db_service.DbService[ProcessedEvent], replyEventBus event_service.EventBus[ReplyEvent], questionEventBus event_service.EventBus[QuestionEvent], consumers ...string) *Outbox {
+	return &Outbox{
+		outboxDbService:         outboxDbService,
+		questionDbService:       questionDbService,
+		processedEventDbService: processedEventDbService,
+		replyEventBus:           replyEventBus,
+		questionEventBus:        questionEventBus,
+		consumers:               consumers,
+		wake:                    make(chan struct{}, 1),
+	}
+}
+
+// Run dispatches stored events until the context ends
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+			o.dispatch(ctx, time.Now())
+		case now := <-ticker.C:
+			o.dispatch(ctx, now)
+			o.purge(ctx, now)
+		}
+	}
+}
+
+// Stores the pending events of the question and records their identifiers in it, the caller
+// writes the question next. APIs reusing the helpers of the counseling API have no outbox.
+func (o *Outbox) store(ctx context.Context, question *Question) error {
+	if o == nil || len(question.PendingEvents) == 0 {
+		return nil
+	}
+
+	for i := range question.PendingEvents {
+		event := &question.PendingEvents[i]
+		if err := o.outboxDbService.CreateDocument(ctx, event.Id, event); err != nil {
+			o.discard(ctx, &Question{PendingEvents: question.PendingEvents[:i]})
+			return err
+		}
+		question.EventIds = append(question.EventIds, event.Id)
+	}
+	if len(question.EventIds) > maxQuestionEventIds {
+		question.EventIds = question.EventIds[len(question.EventIds)-maxQuestionEventIds:]
+	}
+	return nil
+}
+
+// Removes the stored events of a question write that failed, leftovers are discarded by the dispatcher
+func (o *Outbox) discard(ctx context.Context, question *Question) {
+	if o == nil {
+		return
+	}
+	for _, event := range question.PendingEvents {
+		if err := o.outboxDbService.DeleteDocument(ctx, event.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to discard outbox event %s: %v", event.Id, err)
+		}
+	}
+}
+
+// Wakes the dispatcher after a write stored events
+func (o *Outbox) notify() {
+	if o == nil {
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publishes the committed events in the order they were created and marks them dispatched once
+// every consumer acknowledged them. An event some consumer did not receive or acknowledge in
+// time stays pending and is published again.
+func (o *Outbox) dispatch(ctx context.Context, now time.Time) {
+	events, err := o.outboxDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters:   []db_service.FieldFilter{{Field: "dispatchedAt", Operator: db_service.OpEq, Value: nil}},
+		SortField: "createdAt",
+	})
+	if err != nil {
+		log.Printf("Failed to load outbox events: %v", err)
+		return
+	}
+
+	for _, event := range events {
+		committed, err := o.committed(ctx, event)
+		if err != nil {
+			log.Printf("Failed to check outbox event %s: %v", event.Id, err)
+			continue
+		}
+		if !committed {
+			// the write may still be in progress
+			if now.Sub(event.CreatedAt) >= outboxCommitTimeout {
+				if err := o.outboxDbService.DeleteDocument(ctx, event.Id); err != nil && err != db_service.ErrNotFound {
+					log.Printf("Failed to discard outbox event %s: %v", event.Id, err)
+				}
+			}
+			continue
+		}
+
+		if event.PublishedAt != nil {
+			acknowledged, err := o.acknowledged(ctx, event)
+			if err != nil {
+				log.Printf("Failed to check acknowledgements of outbox event %s: %v", event.Id, err)
+				continue
+			}
+			switch {
+			case acknowledged:
+				o.markDispatched(ctx, event, now)
+				continue
+			case now.Sub(event.CreatedAt) >= outboxRetention:
+				log.Printf("Outbox event %s was not acknowledged by every consumer, giving up", event.Id)
+				o.markDispatched(ctx, event, now)
+				continue
+			case now.Sub(*event.PublishedAt) < outboxAckTimeout:
+				// the consumers may still be processing the event
+				continue
+			}
+			log.Printf("Outbox event %s was not acknowledged by every consumer, retrying", event.Id)
+		}
+
+		if err := o.publish(ctx, event); err != nil {
+			// later events wait as well, so that consumers receive them in order
+			log.Printf("Failed to publish outbox event %s, retrying: %v", event.Id, err)
+			return
+		}
+
+		publishedAt := now
+		event.PublishedAt = &publishedAt
+		if len(o.consumers) == 0 {
+			o.markDispatched(ctx, event, now)
+		} else if err := o.outboxDbService.UpdateDocument(ctx, event.Id, event); err != nil {
+			log.Printf("Failed to mark outbox event %s as published: %v", event.Id, err)
+		}
+	}
+}
+
+func (o *Outbox) markDispatched(ctx context.Context, event *OutboxEvent, now time.Time) {
+	dispatchedAt := now
+	event.DispatchedAt = &dispatchedAt
+	if err := o.outboxDbService.UpdateDocument(ctx, event.Id, event); err != nil {
+		log.Printf("Failed to mark outbox event %s as dispatched: %v", event.Id, err)
+	}
+}
+
+// Reports whether each of the consumers acknowledged the event
+func (o *Outbox) acknowledged(ctx context.Context, event *OutboxEvent) (bool, error) {
+	for _, consumer := range o.consumers {
+		record, err := o.processedEventDbService.FindDocument(ctx, processedEventId(consumer, event.Id))
+		if err == db_service.ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !record.Acknowledged {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Publishes the event to the consumers, fails when one of them did not receive it
+func (o *Outbox) publish(ctx context.Context, event *OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, outboxPublishTimeout)
+	defer cancel()
+
+	switch {
+	case event.ReplyEvent != nil:
+		return o.replyEventBus.Publish(ctx, *event.ReplyEvent)
+	case event.QuestionEvent != nil:
+		return o.questionEventBus.Publish(ctx, *event.QuestionEvent)
+	}
+	return nil
+}
+
+// Reports whether the write of the question producing the event succeeded
+func (o *Outbox) committed(ctx context.Context, event *OutboxEvent) (bool, error) {
+	question, err := o.questionDbService.FindDocument(ctx, event.QuestionId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, id := range question.EventIds {
+		if id == event.Id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Removes events dispatched before the retention period
+func (o *Outbox) purge(ctx context.Context, now time.Time) {
+	events, err := o.outboxDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{{Field: "dispatchedAt", Operator: db_service.OpLt, Value: now.Add(-outboxRetention)}},
+	})
+	if err != nil {
+		log.Printf("Failed to load dispatched outbox events: %v", err)
+		return
+	}
+	for _, event := range events {
+		if err := o.outboxDbService.DeleteDocument(ctx, event.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to delete outbox event %s: %v", event.Id, err)
+		}
+	}
+}
+
+// Identifies the record of the event processed by the consumer
+func processedEventId(consumer string, eventId string) string {
+	return consumer + ":" + eventId
+}
+
+// Records that the consumer handles the event, reports false when it was handled before or is
+// being handled. A claim not acknowledged in time belongs to a consumer that stopped and is taken
+// over. Events are handled when the record cannot be checked, a duplicate beats a lost event.
+func claimEvent(ctx context.Context, processedEventDbService db_service.DbService[ProcessedEvent], consumer string, eventId string) bool {
+	id := processedEventId(consumer, eventId)
+	now := time.Now()
+	err := processedEventDbService.CreateDocument(ctx, id, &ProcessedEvent{
+		Id:          id,
+		ProcessedAt: now,
+	})
+	switch err {
+	case nil:
+		return true
+	case db_service.ErrConflict:
+		record, err := processedEventDbService.FindDocument(ctx, id)
+		if err != nil || record.Acknowledged || now.Sub(record.ProcessedAt) < outboxAckTimeout {
+			return false
+		}
+		record.ProcessedAt = now
+		if err := processedEventDbService.UpdateDocument(ctx, id, record); err != nil {
+			log.Printf("Failed to take over event %s for %s: %v", eventId, consumer, err)
+		}
+		return true
+	default:
+		log.Printf("Failed to record event %s for %s: %v", eventId, consumer, err)
+		return true
+	}
+}
+
+// Records that the consumer finished the event, the outbox publishes it again until it is acknowledged
+func acknowledgeEvent(ctx context.Context, processedEventDbService db_service.DbService[ProcessedEvent], consumer string, eventId string) {
+	id := processedEventId(consumer, eventId)
+	record := &ProcessedEvent{Id: id, ProcessedAt: time.Now(), Acknowledged: true}
+	err := processedEventDbService.UpdateDocument(ctx, id, record)
+	if err == db_service.ErrNotFound {
+		err = processedEventDbService.CreateDocument(ctx, id, record)
+	}
+	if err != nil {
+		log.Printf("Failed to acknowledge event %s for %s: %v", eventId, consumer, err)
+	}
+}
+
+// Removes the claim of the event after the consumer failed it, so that the redelivery of the
+// event is processed again
+func releaseEvent(ctx context.Context, processedEventDbService db_service.DbService[ProcessedEvent], consumer string, eventId string) {
+	if err := processedEventDbService.DeleteDocument(ctx, processedEventId(consumer, eventId)); err != nil && err != db_service.ErrNotFound {
+		log.Printf("Failed to release event %s for %s: %v", eventId, consumer, err)
+	}
+}
+
+// Removes records of events processed before the retention period, redeliveries happen within minutes
+func forgetProcessedEvents(ctx context.Context, processedEventDbService db_service.DbService[ProcessedEvent], now time.Time) {
+	processed, err := processedEventDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{{Field: "processedAt", Operator: db_service.OpLt, Value: now.Add(-outboxRetention)}},
+	})
+	if err != nil {
+		log.Printf("Failed to load processed events: %v", err)
+		return
+	}
+	for _, record := range processed {
+		if err := processedEventDbService.DeleteDocument(ctx, record.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to delete processed event %s: %v", record.Id, err)
+		}
+	}
+}
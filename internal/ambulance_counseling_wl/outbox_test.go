@@ -0,0 +1,281 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// Records the event on the seeded question and stores it in the outbox, commit writes the question as well
+func (s *testServer) storeEvent(outbox *Outbox, eventType string, commit bool) *Question {
+	s.t.Helper()
+	ctx := context.Background()
+	question := s.question("question-1")
+	if err := recordQuestionEvent(question, eventType); err != nil {
+		s.t.Fatalf("failed to record event: %v", err)
+	}
+	if err := outbox.store(ctx, question); err != nil {
+		s.t.Fatalf("failed to store event: %v", err)
+	}
+	if commit {
+		if err := s.questionDbService.UpdateDocumentIfVersion(ctx, question.Id, question.Version, question); err != nil {
+			s.t.Fatalf("failed to update question: %v", err)
+		}
+	}
+	return question
+}
+
+func TestOutboxDispatchesEventsOfWrites(t *testing.T) {
+	server := newTestServer(t)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+
+	recorder := server.do(http.MethodPost, "/questions/new", testPatient, Question{Summary: "Rash", Question: "My arm itches.", Category: "dermatology"})
+	expectStatus(t, recorder, http.StatusCreated)
+	var question Question
+	if err := json.Unmarshal(recorder.Body.Bytes(), &question); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Id == "" || event.Type != questionEventCreated || event.Question.Id != question.Id || event.Question.Version != question.Version {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		if stored := server.question(question.Id); len(stored.EventIds) != 1 || stored.EventIds[0] != event.Id {
+			t.Errorf("expected the question to record event %s, got %v", event.Id, stored.EventIds)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", questionEventCreated)
+	}
+}
+
+func TestOutboxMarksDispatchedEvents(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	ctx := context.Background()
+
+	question := server.storeEvent(server.outbox, questionEventClosed, true)
+	eventId := question.PendingEvents[0].Id
+	server.outbox.dispatch(ctx, time.Now())
+	select {
+	case event := <-events:
+		if event.Id != eventId || event.Type != questionEventClosed {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	default:
+		t.Fatalf("expected the committed event to be dispatched")
+	}
+
+	stored, err := server.outboxDbService.FindDocument(ctx, eventId)
+	if err != nil || stored.DispatchedAt == nil {
+		t.Fatalf("expected the event to be marked dispatched, got %+v, %v", stored, err)
+	}
+	server.outbox.dispatch(ctx, time.Now())
+	select {
+	case event := <-events:
+		t.Fatalf("dispatched events must not be published again: %+v", event)
+	default:
+	}
+
+	// dispatched events are kept for the retention period
+	server.outbox.purge(ctx, time.Now())
+	if _, err := server.outboxDbService.FindDocument(ctx, eventId); err != nil {
+		t.Fatalf("expected the event to be kept: %v", err)
+	}
+	server.outbox.purge(ctx, time.Now().Add(outboxRetention+time.Minute))
+	if _, err := server.outboxDbService.FindDocument(ctx, eventId); err == nil {
+		t.Errorf("expected the event to be purged")
+	}
+}
+
+func TestOutboxRedeliversEventsOfSlowConsumers(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	ctx := context.Background()
+
+	// the consumer fell behind until its buffer is full
+	backlog := 0
+	for {
+		publishCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		err := server.questionEventBus.Publish(publishCtx, QuestionEvent{Type: questionEventCreated})
+		cancel()
+		if err != nil {
+			break
+		}
+		backlog++
+	}
+
+	question := server.storeEvent(server.outbox, questionEventClosed, true)
+	eventId := question.PendingEvents[0].Id
+	dispatchCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	server.outbox.dispatch(dispatchCtx, time.Now())
+	cancel()
+	if stored, err := server.outboxDbService.FindDocument(ctx, eventId); err != nil || stored.DispatchedAt != nil {
+		t.Fatalf("expected the undelivered event to stay pending, got %+v, %v", stored, err)
+	}
+
+	for range backlog {
+		<-events
+	}
+	server.outbox.dispatch(ctx, time.Now())
+	select {
+	case event := <-events:
+		if event.Id != eventId || event.Type != questionEventClosed {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	default:
+		t.Fatalf("expected the pending event to be redelivered")
+	}
+	if stored, err := server.outboxDbService.FindDocument(ctx, eventId); err != nil || stored.DispatchedAt == nil {
+		t.Errorf("expected the event to be marked dispatched, got %+v, %v", stored, err)
+	}
+}
+
+func TestOutboxRedeliversEventsUntilConsumersAcknowledge(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	webhook := server.webhook("https://example.com/hooks", questionEventClosed)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	ctx := context.Background()
+
+	// an outbox of its own, the one of the server does not wait for consumers
+	outboxDbService := db_service.NewMemoryService[OutboxEvent]()
+	outbox := NewOutbox(outboxDbService, server.questionDbService, server.processedEventDbService, server.replyEventBus, server.questionEventBus, webhookConsumer)
+	deliveries := &faultyDbService[WebhookDelivery]{DbService: server.webhookDeliveryDbService, failCreates: true}
+	dispatcher := NewWebhookDispatcher(server.webhookDbService, deliveries, server.processedEventDbService, server.replyEventBus, server.questionEventBus)
+	consume := func() bool {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Type != questionEventClosed {
+				t.Fatalf("unexpected event %+v", event)
+			}
+			dispatcher.consume(ctx, event.Id, event.Type, event)
+			return true
+		default:
+			return false
+		}
+	}
+
+	eventId := server.storeEvent(outbox, questionEventClosed, true).PendingEvents[0].Id
+	now := time.Now()
+	outbox.dispatch(ctx, now)
+	// the delivery cannot be recorded, the event is not acknowledged
+	if !consume() {
+		t.Fatalf("expected the committed event to be published")
+	}
+	if stored, err := outboxDbService.FindDocument(ctx, eventId); err != nil || stored.PublishedAt == nil || stored.DispatchedAt != nil {
+		t.Fatalf("expected the unacknowledged event to stay pending, got %+v, %v", stored, err)
+	}
+	outbox.dispatch(ctx, now.Add(time.Second))
+	if consume() {
+		t.Fatalf("events must not be published again before the acknowledgement timeout")
+	}
+
+	deliveries.failCreates = false
+	outbox.dispatch(ctx, now.Add(outboxAckTimeout))
+	if !consume() {
+		t.Fatalf("expected the unacknowledged event to be published again")
+	}
+	if recorded := server.webhookDeliveries(webhook.Id, ""); len(recorded) != 1 {
+		t.Fatalf("expected the delivery to be recorded on the redelivery, got %+v", recorded)
+	}
+
+	outbox.dispatch(ctx, now.Add(outboxAckTimeout+time.Second))
+	if consume() {
+		t.Fatalf("acknowledged events must not be published again")
+	}
+	if stored, err := outboxDbService.FindDocument(ctx, eventId); err != nil || stored.DispatchedAt == nil {
+		t.Errorf("expected the acknowledged event to be marked dispatched, got %+v, %v", stored, err)
+	}
+}
+
+func TestNotifierReleasesFailedEvents(t *testing.T) {
+	server := newTestServer(t)
+	notifier, _ := server.notifier()
+	ctx := context.Background()
+	event := QuestionEvent{Id: "event-1", Type: questionEventCreated, Question: Question{Id: "question-1", Summary: "Rash", Category: "dermatology"}}
+
+	failing := &faultyDbService[PendingNotification]{DbService: db_service.NewMemoryService[PendingNotification](), failCreates: true}
+	notifier.notificationDbService = failing
+	notifier.notifyQuestion(ctx, event)
+	if _, err := server.processedEventDbService.FindDocument(ctx, processedEventId(notifierConsumer, event.Id)); err != db_service.ErrNotFound {
+		t.Fatalf("expected the failed event to be released, got %v", err)
+	}
+
+	// the redelivered event is handled again
+	failing.failCreates = false
+	notifier.notifyQuestion(ctx, event)
+	record, err := server.processedEventDbService.FindDocument(ctx, processedEventId(notifierConsumer, event.Id))
+	if err != nil || !record.Acknowledged {
+		t.Fatalf("expected the event to be acknowledged, got %+v, %v", record, err)
+	}
+	if pending := pendingNotifications(t, failing); len(pending) == 0 {
+		t.Errorf("expected the doctors to be notified on the redelivery")
+	}
+}
+
+func TestOutboxDiscardsEventsOfFailedWrites(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	events, unsubscribe := server.questionEventBus.Subscribe()
+	defer unsubscribe()
+	ctx := context.Background()
+
+	// the process stopped between storing the event and writing the question
+	question := server.storeEvent(server.outbox, questionEventClosed, false)
+	eventId := question.PendingEvents[0].Id
+
+	server.outbox.dispatch(ctx, time.Now())
+	if _, err := server.outboxDbService.FindDocument(ctx, eventId); err != nil {
+		t.Fatalf("events of writes in progress must be kept: %v", err)
+	}
+	server.outbox.dispatch(ctx, time.Now().Add(outboxCommitTimeout))
+	if _, err := server.outboxDbService.FindDocument(ctx, eventId); err == nil {
+		t.Fatalf("expected the uncommitted event to be discarded")
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("uncommitted events must not be published: %+v", event)
+	default:
+	}
+}
+
+func TestConsumersSkipRedeliveredEvents(t *testing.T) {
+	server := newTestServer(t)
+	server.seedQuestion(testPatient.Id, false)
+	notifier, _ := server.notifier()
+	ctx := context.Background()
+
+	event := server.replyEvent(testDoctor, "Drink more water.")
+	notifier.notifyReply(ctx, event)
+	notifier.notifyReply(ctx, event)
+	if len(server.mailer.messages) != 1 {
+		t.Fatalf("expected a single notification, got %+v", server.mailer.messages)
+	}
+
+	webhook := server.webhook("https://example.com/hooks", replyEventCreated)
+	dispatcher := NewWebhookDispatcher(server.webhookDbService, server.webhookDeliveryDbService, server.processedEventDbService, server.replyEventBus, server.questionEventBus)
+	dispatcher.enqueue(ctx, event.Id, event.Type, event, time.Now())
+	dispatcher.enqueue(ctx, event.Id, event.Type, event, time.Now())
+	if deliveries := server.webhookDeliveries(webhook.Id, ""); len(deliveries) != 1 {
+		t.Fatalf("expected a single delivery, got %+v", deliveries)
+	}
+
+	// records of processed events expire after the retention period
+	forgetProcessedEvents(ctx, server.processedEventDbService, time.Now().Add(outboxRetention+time.Minute))
+	notifier.notifyReply(ctx, event)
+	if len(server.mailer.messages) != 2 {
+		t.Errorf("expected the forgotten event to be handled again, got %+v", server.mailer.messages)
+	}
+}
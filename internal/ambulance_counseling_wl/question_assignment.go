@@ -0,0 +1,42 @@
+package ambulance_counseling_wl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// doctors lose their claim on a question after this long without replying
+const claimInactivityTimeout = time.Hour
+
+var errAssignedToOtherDoctor = errors.New("question is assigned to another doctor")
+
+// Id of the doctor working on the question, empty when nobody claimed it or the claim expired
+func activeAssignee(question *Question, now time.Time) string {
+	if question.AssignedDoctorId == "" || question.ClaimExpiresAt == nil || !question.ClaimExpiresAt.After(now) {
+		return ""
+	}
+	return question.AssignedDoctorId
+}
+
+// Filters of which any matches the questions activeAssignee reports nobody working on
+func unclaimedFilters(now time.Time) []db_service.FieldFilter {
+	return []db_service.FieldFilter{
+		{Field: "assigneddoctorid", Operator: db_service.OpIn, Value: []interface{}{"", nil}},
+		{Field: "claimexpiresat", Operator: db_service.OpEq, Value: nil},
+		{Field: "claimexpiresat", Operator: db_service.OpLte, Value: now},
+	}
+}
+
+// Assigns the question to the doctor, the claim lasts until the doctor stays inactive for claimInactivityTimeout
+func assignQuestion(question *Question, doctorId string, now time.Time) {
+	expiresAt := now.Add(claimInactivityTimeout)
+	question.AssignedDoctorId = doctorId
+	question.ClaimExpiresAt = &expiresAt
+}
+
+func releaseQuestion(question *Question) {
+	question.AssignedDoctorId = ""
+	question.ClaimExpiresAt = nil
+}
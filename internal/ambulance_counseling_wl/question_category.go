@@ -0,0 +1,24 @@
+package ambulance_counseling_wl
+
+import (
+	"regexp"
+	"slices"
+)
+
+// category ids are lowercase slugs, e.g. cardiology or internal-medicine
+var categoryIdPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Helper function to check if a doctor with the specialties answers questions of the category,
+// doctors without specialties and questions stored before categories existed match everything
+func handlesCategory(specialties []string, category string) bool {
+	return len(specialties) == 0 || category == "" || slices.Contains(specialties, category)
+}
+
+// Categories handlesCategory accepts for doctors with specialties, as values of an OpIn filter
+func categoriesOf(specialties []string) []interface{} {
+	categories := []interface{}{"", nil}
+	for _, specialty := range specialties {
+		categories = append(categories, specialty)
+	}
+	return categories
+}
@@ -0,0 +1,93 @@
+package ambulance_counseling_wl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// triage priority of a question, questions stored before priorities existed have none
+// and are treated as normal
+const (
+	priorityLow    = "low"
+	priorityNormal = "normal"
+	priorityHigh   = "high"
+	priorityUrgent = "urgent"
+)
+
+// questionPriorities ranks the priorities, higher ranks are answered first
+var questionPriorities = map[string]int{
+	priorityLow:    0,
+	priorityNormal: 1,
+	priorityHigh:   2,
+	priorityUrgent: 3,
+}
+
+func questionPriorityRank(question *Question) int {
+	if rank, ok := questionPriorities[question.Priority]; ok {
+		return rank
+	}
+	return questionPriorities[priorityNormal]
+}
+
+// Time since the patient waits for a doctor - their oldest message the doctors have not answered yet,
+// follow-ups of the patient do not move the question back in the queue
+func questionWaitingSince(question *Question) time.Time {
+	waitingSince := question.CreatedAt
+	answered := false
+	for _, reply := range question.Replies {
+		if reply.DoctorName != "" {
+			answered = true
+		} else if answered {
+			waitingSince = reply.CreatedAt
+			answered = false
+		}
+	}
+	return waitingSince
+}
+
+// Priorities of the rank as values of an OpIn filter, questions without one are normal
+func prioritiesOfRank(rank int) []interface{} {
+	priorities := []interface{}{}
+	for priority, priorityRank := range questionPriorities {
+		if priorityRank == rank {
+			priorities = append(priorities, priority)
+		}
+	}
+	if rank == questionPriorities[priorityNormal] {
+		priorities = append(priorities, "", nil)
+	}
+	return priorities
+}
+
+// Position in the queue, which is ordered by priority and then by the waiting time, longest first
+type queueCursor struct {
+	Rank         int       `json:"r"`
+	WaitingSince time.Time `json:"v"`
+	Id           string    `json:"id"`
+}
+
+func encodeQueueCursor(question *Question) string {
+	cursor := queueCursor{Rank: questionPriorityRank(question), Id: question.Id}
+	if question.WaitingSince != nil {
+		cursor.WaitingSince = *question.WaitingSince
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeQueueCursor(value string) (*queueCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor queueCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Rank < 0 || cursor.Rank > questionPriorities[priorityUrgent] || cursor.Id == "" {
+		return nil, fmt.Errorf("invalid queue cursor")
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,82 @@
+package ambulance_counseling_wl
+
+import (
+	"errors"
+	"time"
+)
+
+// lifecycle of a question, questions stored before statuses existed have none
+// and get one with their next transition
+const (
+	statusWaitingForDoctor  = "waiting_for_doctor"
+	statusWaitingForPatient = "waiting_for_patient"
+	statusResolved          = "resolved"
+	statusClosed            = "closed"
+)
+
+// questionStatusTransitions lists the statuses reachable from each status
+var questionStatusTransitions = map[string][]string{
+	statusWaitingForDoctor:  {statusWaitingForDoctor, statusWaitingForPatient, statusResolved, statusClosed},
+	statusWaitingForPatient: {statusWaitingForDoctor, statusWaitingForPatient, statusResolved, statusClosed},
+	statusResolved:          {statusWaitingForDoctor},
+	statusClosed:            {statusWaitingForDoctor},
+}
+
+var errInvalidStatusTransition = errors.New("invalid question status transition")
+var errNotAnswered = errors.New("question has not been answered by a doctor")
+
+// Status of the question, derived from the replies for questions stored without one
+func currentQuestionStatus(question *Question) string {
+	if question.Status != "" {
+		return question.Status
+	}
+	if n := len(question.Replies); n > 0 && question.Replies[n-1].DoctorName != "" {
+		return statusWaitingForPatient
+	}
+	return statusWaitingForDoctor
+}
+
+// Helper function to check if the question accepts replies
+func isQuestionOpen(question *Question) bool {
+	status := currentQuestionStatus(question)
+	return status == statusWaitingForDoctor || status == statusWaitingForPatient
+}
+
+// Helper function to check if a doctor took part in the conversation
+func hasDoctorReply(question *Question) bool {
+	for _, reply := range question.Replies {
+		if reply.DoctorName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves the question to the status and records the transition, staying in the
+// same status is allowed but not recorded
+func transitionQuestion(question *Question, status string, changedAt time.Time) error {
+	current := currentQuestionStatus(question)
+	allowed := false
+	for _, next := range questionStatusTransitions[current] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return errInvalidStatusTransition
+	}
+
+	if question.Status == status {
+		return nil
+	}
+	question.Status = status
+	question.StatusHistory = append(question.StatusHistory, QuestionStatusChange{Status: status, ChangedAt: changedAt})
+	// the patient's message moving the question to the doctors is the oldest one they have not answered
+	if status == statusWaitingForDoctor {
+		question.WaitingSince = &changedAt
+	} else {
+		question.WaitingSince = nil
+	}
+	return nil
+}
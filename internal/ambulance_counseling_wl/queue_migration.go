@@ -0,0 +1,50 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"log"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// MigrateQueue completes the open questions stored before the time they wait for a doctor was
+// stored with them, the doctor queue is ordered by it. The waiting time and, for questions stored
+// without a status, the status are derived from the replies. Running the migration again skips
+// migrated questions. Returns the number of migrated questions.
+func MigrateQueue(ctx context.Context, questionDbService db_service.DbService[Question], replyDbService db_service.DbService[Reply]) (int, error) {
+	questions, err := questionDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{
+			{Field: "status", Operator: db_service.OpIn, Value: []interface{}{statusWaitingForDoctor, "", nil}},
+			{Field: "waitingsince", Operator: db_service.OpEq, Value: nil},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, question := range questions {
+		replies, err := replyDbService.FindDocumentsByQuery(ctx, db_service.Query{
+			Filters:   []db_service.FieldFilter{{Field: "questionid", Operator: db_service.OpEq, Value: question.Id}},
+			SortField: "createdat",
+		})
+		if err != nil {
+			return migrated, err
+		}
+		for _, reply := range replies {
+			question.Replies = append(question.Replies, *reply)
+		}
+
+		question.Status = currentQuestionStatus(question)
+		if question.Status == statusWaitingForDoctor {
+			waitingSince := questionWaitingSince(question)
+			question.WaitingSince = &waitingSince
+		}
+		if err := questionDbService.UpdateDocument(ctx, question.Id, question); err != nil {
+			return migrated, err
+		}
+		log.Printf("Migrated queue position of question %s", question.Id)
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,61 @@
+package ambulance_counseling_wl
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	redactedEmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	redactedDatePattern  = regexp.MustCompile(`\b(?:\d{1,2}\.\s?\d{1,2}\.\s?\d{2,4}|\d{1,2}[/-]\d{1,2}[/-]\d{2,4}|\d{4}-\d{1,2}-\d{1,2})\b`)
+	redactedPhonePattern = regexp.MustCompile(`\+?\d[\d \-/()]{5,}\d`)
+	// a capitalized word after a salutation or title is most likely a name
+	redactedTitledNamePattern = regexp.MustCompile(`\b(Mr|Mrs|Ms|Miss|Dr|MUDr|MDDr|Mgr|Ing|Bc|PhDr|prof|doc)\.?\s+\p{Lu}\p{Ll}+(?:\s+\p{Lu}\p{Ll}+)?`)
+	redactedWordPattern       = regexp.MustCompile(`\p{L}+`)
+)
+
+// minimal number of digits for a number to be treated as a phone number, shorter ones are doses or counts
+const minPhoneDigits = 7
+
+// redactor strips personal identifiers from free text before it is published
+type redactor struct {
+	// lowercased words of names known to appear in the text, e.g. the patient's name
+	names map[string]bool
+}
+
+func newRedactor(knownNames ...string) *redactor {
+	r := &redactor{names: map[string]bool{}}
+	for _, name := range knownNames {
+		for _, word := range redactedWordPattern.FindAllString(name, -1) {
+			if len([]rune(word)) > 1 {
+				r.names[strings.ToLower(word)] = true
+			}
+		}
+	}
+	return r
+}
+
+// Redact replaces emails, dates, phone numbers and names with placeholders
+func (r *redactor) Redact(text string) string {
+	text = redactedEmailPattern.ReplaceAllString(text, "[email]")
+	text = redactedDatePattern.ReplaceAllString(text, "[date]")
+	text = redactedPhonePattern.ReplaceAllStringFunc(text, func(match string) string {
+		digits := 0
+		for _, char := range match {
+			if char >= '0' && char <= '9' {
+				digits++
+			}
+		}
+		if digits < minPhoneDigits {
+			return match
+		}
+		return "[phone]"
+	})
+	text = redactedTitledNamePattern.ReplaceAllString(text, "$1 [name]")
+	return redactedWordPattern.ReplaceAllStringFunc(text, func(word string) string {
+		if r.names[strings.ToLower(word)] {
+			return "[name]"
+		}
+		return word
+	})
+}
@@ -0,0 +1,78 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"log"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// EmbeddedReplies reads the copies of replies questions stored before replies were kept
+// only in the reply collection
+type EmbeddedReplies struct {
+	Id string `bson:"id"`
+
+	Replies []Reply `bson:"replies"`
+}
+
+// MigrateReplies moves the replies embedded in questions to the reply collection, references
+// them by their question and rewrites the questions without the copies. Questions are migrated
+// one transaction at a time, running the migration again skips migrated questions.
+// The embedded copies are the only link between earlier replies and their questions, a question
+// written before the migration drops them, so the API service migrates before serving requests.
+// Returns the number of migrated replies.
+func MigrateReplies(ctx context.Context, questionDbService db_service.DbService[Question], embeddedDbService db_service.DbService[EmbeddedReplies], replyDbService db_service.DbService[Reply], transactor db_service.Transactor) (int, error) {
+	legacyQuestions, err := embeddedDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{{Field: "replies", Operator: db_service.OpNe, Value: nil}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, legacy := range legacyQuestions {
+		err := transactor.WithTransaction(ctx, func(ctx context.Context) error {
+			for i := range legacy.Replies {
+				if err := migrateReply(ctx, replyDbService, legacy.Id, &legacy.Replies[i]); err != nil {
+					return err
+				}
+			}
+
+			question, err := questionDbService.FindDocument(ctx, legacy.Id)
+			if err != nil {
+				return err
+			}
+			// questions stored without a status derive it from their replies, which are no longer loaded with them
+			if question.Status == "" {
+				question.Replies = legacy.Replies
+				question.Status = currentQuestionStatus(question)
+			}
+			// the question type does not store replies, so the rewrite drops the copies
+			return questionDbService.UpdateDocument(ctx, question.Id, question)
+		})
+		if err != nil {
+			return migrated, err
+		}
+		log.Printf("Migrated %d replies of question %s", len(legacy.Replies), legacy.Id)
+		migrated += len(legacy.Replies)
+	}
+	return migrated, nil
+}
+
+// Stores the embedded copy of the reply with a reference to its question. The reply collection
+// kept the reply in sync except for the replied to flag, which was set on the copies only.
+func migrateReply(ctx context.Context, replyDbService db_service.DbService[Reply], questionId string, embedded *Reply) error {
+	stored, err := replyDbService.FindDocument(ctx, embedded.Id)
+	switch err {
+	case nil:
+		stored.QuestionId = questionId
+		stored.RepliedTo = stored.RepliedTo || embedded.RepliedTo
+		return replyDbService.UpdateDocument(ctx, stored.Id, stored)
+	case db_service.ErrNotFound:
+		reply := *embedded
+		reply.QuestionId = questionId
+		return replyDbService.CreateDocument(ctx, reply.Id, &reply)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,119 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// sharedQuestionCollection drops the embedded replies on question writes, the question and
+// its embedded replies are views of the same MongoDB document
+type sharedQuestionCollection struct {
+	db_service.DbService[Question]
+	embedded db_service.DbService[EmbeddedReplies]
+}
+
+func (s *sharedQuestionCollection) UpdateDocument(ctx context.Context, id string, document *Question) error {
+	if err := s.DbService.UpdateDocument(ctx, id, document); err != nil {
+		return err
+	}
+	return s.dropEmbedded(ctx, id)
+}
+
+func (s *sharedQuestionCollection) UpdateDocumentIfVersion(ctx context.Context, id string, expectedVersion int64, document *Question) error {
+	if err := s.DbService.UpdateDocumentIfVersion(ctx, id, expectedVersion, document); err != nil {
+		return err
+	}
+	return s.dropEmbedded(ctx, id)
+}
+
+func (s *sharedQuestionCollection) dropEmbedded(ctx context.Context, id string) error {
+	if err := s.embedded.DeleteDocument(ctx, id); err != nil && err != db_service.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func TestMigrateReplies(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// questions stored their replies embedded, the reply collection missed the replied to flag
+	question := &Question{Id: "question-1", PatientId: testPatient.Id, CreatedAt: now}
+	if err := server.questionDbService.CreateDocument(ctx, question.Id, question); err != nil {
+		t.Fatalf("failed to seed question: %v", err)
+	}
+	embedded := []Reply{
+		{Id: "reply-1", UserId: testPatient.Id, Text: "Since Monday.", CreatedAt: now, RepliedTo: true},
+		{Id: "reply-2", UserId: testDoctor.Id, DoctorName: "MUDr. Gregory House", Text: "Drink water.", CreatedAt: now.Add(time.Minute)},
+	}
+	stale := embedded[0]
+	stale.RepliedTo = false
+	if err := server.replyDbService.CreateDocument(ctx, stale.Id, &stale); err != nil {
+		t.Fatalf("failed to seed reply: %v", err)
+	}
+	embeddedDbService := db_service.NewMemoryService[EmbeddedReplies]()
+	if err := embeddedDbService.CreateDocument(ctx, question.Id, &EmbeddedReplies{Id: question.Id, Replies: embedded}); err != nil {
+		t.Fatalf("failed to seed embedded replies: %v", err)
+	}
+
+	migrated, err := MigrateReplies(ctx, server.questionDbService, embeddedDbService, server.replyDbService, server.transactor)
+	if err != nil || migrated != 2 {
+		t.Fatalf("expected 2 migrated replies, got %d: %v", migrated, err)
+	}
+
+	stored := server.question(question.Id)
+	if len(stored.Replies) != 2 || stored.Replies[0].Id != "reply-1" || stored.Replies[1].Id != "reply-2" {
+		t.Fatalf("expected the replies to reference the question, got %+v", stored.Replies)
+	}
+	if !stored.Replies[0].RepliedTo || stored.Replies[1].RepliedTo {
+		t.Errorf("expected the replied to flags of the embedded copies, got %+v", stored.Replies)
+	}
+	// the status was derived from the last reply, which is no longer loaded with the question
+	if stored.Status != statusWaitingForPatient {
+		t.Errorf("expected status %s, got %q", statusWaitingForPatient, stored.Status)
+	}
+}
+
+func TestQuestionWritesKeepRepliesMigratedAtStartup(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// the reply collection of earlier versions did not reference the questions
+	embeddedDbService := db_service.NewMemoryService[EmbeddedReplies]()
+	server.questionFaults.DbService = &sharedQuestionCollection{DbService: server.questionFaults.DbService, embedded: embeddedDbService}
+	question := &Question{Id: "question-1", PatientId: testPatient.Id, Category: "cardiology", CreatedAt: now}
+	if err := server.questionDbService.CreateDocument(ctx, question.Id, question); err != nil {
+		t.Fatalf("failed to seed question: %v", err)
+	}
+	reply := Reply{Id: "reply-1", UserId: testPatient.Id, Text: "Since Monday.", CreatedAt: now}
+	legacyReply := reply
+	if err := server.replyDbService.CreateDocument(ctx, reply.Id, &legacyReply); err != nil {
+		t.Fatalf("failed to seed reply: %v", err)
+	}
+	if err := embeddedDbService.CreateDocument(ctx, question.Id, &EmbeddedReplies{Id: question.Id, Replies: []Reply{reply}}); err != nil {
+		t.Fatalf("failed to seed embedded replies: %v", err)
+	}
+
+	// the service migrates before it serves the first question write
+	if _, err := MigrateReplies(ctx, server.questionDbService, embeddedDbService, server.replyDbService, server.transactor); err != nil {
+		t.Fatalf("failed to migrate replies: %v", err)
+	}
+	expectStatus(t, server.do(http.MethodPost, "/questions/question-1/claim", testDoctor, nil), http.StatusOK)
+
+	recorder := server.do(http.MethodGet, "/questions/question-1", testPatient, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var stored Question
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stored); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(stored.Replies) != 1 || stored.Replies[0].Id != reply.Id {
+		t.Errorf("expected the reply to survive the question write, got %+v", stored.Replies)
+	}
+}
@@ -0,0 +1,37 @@
+package ambulance_counseling_wl
+
+const (
+	// replies nested under the replies they answer
+	replyFormatTree = "tree"
+	// all replies of the question, oldest first
+	replyFormatFlat = "flat"
+)
+
+// Nests the replies, ordered oldest first, under the replies they answer. Every level keeps
+// the order, replies whose parent is not among them are listed at the top level.
+func buildReplyThreads(replies []Reply) []ReplyThread {
+	ids := map[string]bool{}
+	for _, reply := range replies {
+		ids[reply.Id] = true
+	}
+
+	roots := []Reply{}
+	children := map[string][]Reply{}
+	for _, reply := range replies {
+		if reply.ParentReplyId != "" && ids[reply.ParentReplyId] {
+			children[reply.ParentReplyId] = append(children[reply.ParentReplyId], reply)
+		} else {
+			roots = append(roots, reply)
+		}
+	}
+
+	var build func(replies []Reply) []ReplyThread
+	build = func(replies []Reply) []ReplyThread {
+		threads := []ReplyThread{}
+		for _, reply := range replies {
+			threads = append(threads, ReplyThread{Reply: reply, Replies: build(children[reply.Id])})
+		}
+		return threads
+	}
+	return build(roots)
+}
@@ -28,6 +28,56 @@ type Route struct {
 	HandlerFunc gin.HandlerFunc
 }
 
+// publicRoutes lists the routes accessible without the Authorization header
+var publicRoutes = map[string]bool{
+	"UserLogin":               true,
+	"UserRegister":            true,
+	"RefreshToken":            true,
+	"RequestPasswordReset":    true,
+	"ConfirmPasswordReset":    true,
+	"VerifyEmail":             true,
+	"ResendVerificationEmail": true,
+	// the knowledge base serves only anonymized copies
+	"GetKnowledgeBaseArticles":    true,
+	"GetKnowledgeBaseArticleById": true,
+	"GetCategories":               true,
+}
+
+// optionalAuthRoutes lists the routes accessible without the Authorization header,
+// a provided token is verified and decides which questions the caller may read
+var optionalAuthRoutes = map[string]bool{
+	"GetQuestions":           true,
+	"GetQuestionById":        true,
+	"GetRepliesByQuestionId": true,
+	"GetReplyById":           true,
+}
+
+// webSocketRoutes lists the routes opening a WebSocket, they also take the access token
+// from the access_token query parameter
+var webSocketRoutes = map[string]bool{
+	"JoinQuestionChat": true,
+}
+
+// adminRoutes lists the routes accessible only to administrators
+var adminRoutes = map[string]bool{
+	"GetPendingDoctors":    true,
+	"ApproveDoctor":        true,
+	"RejectDoctor":         true,
+	"GetUsers":             true,
+	"ChangeUserType":       true,
+	"DisableUser":          true,
+	"EnableUser":           true,
+	"ResetUserPassword":    true,
+	"CreateCategory":       true,
+	"UpdateCategory":       true,
+	"DeleteCategory":       true,
+	"GetWebhooks":          true,
+	"CreateWebhook":        true,
+	"UpdateWebhook":        true,
+	"DeleteWebhook":        true,
+	"GetWebhookDeliveries": true,
+}
+
 // NewRouter returns a new router.
 func NewRouter(handleFunctions ApiHandleFunctions) *gin.Engine {
 	return NewRouterWithGinEngine(gin.Default(), handleFunctions)
@@ -35,19 +85,35 @@ func NewRouter(handleFunctions ApiHandleFunctions) *gin.Engine {
 
 // NewRouter add routes to existing gin engine.
 func NewRouterWithGinEngine(router *gin.Engine, handleFunctions ApiHandleFunctions) *gin.Engine {
+	// the auth API performs server-side token checks such as revocation
+	validator, _ := handleFunctions.AmbulanceCounselingAuthAPI.(TokenValidator)
+
+	optionalAuth := router.Group("/")
+	optionalAuth.Use(OptionalJWTAuthMiddleware(validator))
+
 	protected := router.Group("/")
-	protected.Use(JWTAuthMiddleware())
+	protected.Use(JWTAuthMiddleware(validator))
+
+	webSocket := router.Group("/")
+	webSocket.Use(WebSocketTokenMiddleware(), JWTAuthMiddleware(validator))
+
+	admin := protected.Group("/")
+	admin.Use(RequireUserType("admin"))
 
 	for _, route := range getRoutes(handleFunctions) {
 		if route.HandlerFunc == nil {
 			route.HandlerFunc = DefaultHandleFunc
 		}
 
-		isPublicRoute := route.Name == "UserLogin" || route.Name == "UserRegister" || route.Name == "GetQuestions" || route.Name == "GetQuestionById" || route.Name == "GetRepliesByQuestionId"
-
 		var routeGroup *gin.RouterGroup
-		if isPublicRoute {
+		if publicRoutes[route.Name] {
 			routeGroup = &router.RouterGroup
+		} else if optionalAuthRoutes[route.Name] {
+			routeGroup = optionalAuth
+		} else if webSocketRoutes[route.Name] {
+			routeGroup = webSocket
+		} else if adminRoutes[route.Name] {
+			routeGroup = admin
 		} else {
 			routeGroup = protected
 		}
@@ -77,18 +143,96 @@ type ApiHandleFunctions struct {
 
 	// Routes for the AmbulanceCounselingAPI part of the API
 	AmbulanceCounselingAPI AmbulanceCounselingAPI
+	// Routes for the AmbulanceCounselingAdminAPI part of the API
+	AmbulanceCounselingAdminAPI AmbulanceCounselingAdminAPI
+	// Routes for the AmbulanceCounselingAssignmentAPI part of the API
+	AmbulanceCounselingAssignmentAPI AmbulanceCounselingAssignmentAPI
+	// Routes for the AmbulanceCounselingAttachmentAPI part of the API
+	AmbulanceCounselingAttachmentAPI AmbulanceCounselingAttachmentAPI
 	// Routes for the AmbulanceCounselingAuthAPI part of the API
 	AmbulanceCounselingAuthAPI AmbulanceCounselingAuthAPI
+	// Routes for the AmbulanceCounselingCategoryAPI part of the API
+	AmbulanceCounselingCategoryAPI AmbulanceCounselingCategoryAPI
+	// Routes for the AmbulanceCounselingChatAPI part of the API
+	AmbulanceCounselingChatAPI AmbulanceCounselingChatAPI
+	// Routes for the AmbulanceCounselingEventsAPI part of the API
+	AmbulanceCounselingEventsAPI AmbulanceCounselingEventsAPI
+	// Routes for the AmbulanceCounselingKnowledgeBaseAPI part of the API
+	AmbulanceCounselingKnowledgeBaseAPI AmbulanceCounselingKnowledgeBaseAPI
+	// Routes for the AmbulanceCounselingProfileAPI part of the API
+	AmbulanceCounselingProfileAPI AmbulanceCounselingProfileAPI
+	// Routes for the AmbulanceCounselingWebhookAPI part of the API
+	AmbulanceCounselingWebhookAPI AmbulanceCounselingWebhookAPI
 }
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 	return []Route{
+		{
+			"ApproveDoctor",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/doctors/:userId/approve",
+			handleFunctions.AmbulanceCounselingAdminAPI.ApproveDoctor,
+		},
+		{
+			"AssignQuestion",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/questions/:questionId/assignee",
+			handleFunctions.AmbulanceCounselingAssignmentAPI.AssignQuestion,
+		},
+		{
+			"ChangeUserType",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/admin/users/:userId/type",
+			handleFunctions.AmbulanceCounselingAdminAPI.ChangeUserType,
+		},
+		{
+			"ClaimQuestion",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/claim",
+			handleFunctions.AmbulanceCounselingAssignmentAPI.ClaimQuestion,
+		},
+		{
+			"CloseQuestion",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/close",
+			handleFunctions.AmbulanceCounselingAPI.CloseQuestion,
+		},
+		{
+			"ConfirmPasswordReset",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/password-reset/confirm",
+			handleFunctions.AmbulanceCounselingAuthAPI.ConfirmPasswordReset,
+		},
+		{
+			"CreateCategory",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/categories",
+			handleFunctions.AmbulanceCounselingCategoryAPI.CreateCategory,
+		},
 		{
 			"CreateQuestion",
 			http.MethodPost,
 			"/ak-ambulance-counseling-api/questions/new",
 			handleFunctions.AmbulanceCounselingAPI.CreateQuestion,
 		},
+		{
+			"CreateWebhook",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/webhooks",
+			handleFunctions.AmbulanceCounselingWebhookAPI.CreateWebhook,
+		},
+		{
+			"DeleteAttachment",
+			http.MethodDelete,
+			"/ak-ambulance-counseling-api/questions/:questionId/attachments/:attachmentId",
+			handleFunctions.AmbulanceCounselingAttachmentAPI.DeleteAttachment,
+		},
+		{
+			"DeleteCategory",
+			http.MethodDelete,
+			"/ak-ambulance-counseling-api/admin/categories/:categoryId",
+			handleFunctions.AmbulanceCounselingCategoryAPI.DeleteCategory,
+		},
 		{
 			"DeleteQuestionById",
 			http.MethodDelete,
@@ -101,6 +245,66 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/ak-ambulance-counseling-api/delete/reply/:replyId",
 			handleFunctions.AmbulanceCounselingAPI.DeleteReplyById,
 		},
+		{
+			"DeleteWebhook",
+			http.MethodDelete,
+			"/ak-ambulance-counseling-api/admin/webhooks/:webhookId",
+			handleFunctions.AmbulanceCounselingWebhookAPI.DeleteWebhook,
+		},
+		{
+			"DisableUser",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/users/:userId/disable",
+			handleFunctions.AmbulanceCounselingAdminAPI.DisableUser,
+		},
+		{
+			"DownloadAttachment",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/questions/:questionId/attachments/:attachmentId",
+			handleFunctions.AmbulanceCounselingAttachmentAPI.DownloadAttachment,
+		},
+		{
+			"EnableUser",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/users/:userId/enable",
+			handleFunctions.AmbulanceCounselingAdminAPI.EnableUser,
+		},
+		{
+			"GetCategories",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/categories",
+			handleFunctions.AmbulanceCounselingCategoryAPI.GetCategories,
+		},
+		{
+			"GetEvents",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/events",
+			handleFunctions.AmbulanceCounselingEventsAPI.GetEvents,
+		},
+		{
+			"GetKnowledgeBaseArticleById",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/knowledge-base/:articleId",
+			handleFunctions.AmbulanceCounselingKnowledgeBaseAPI.GetKnowledgeBaseArticleById,
+		},
+		{
+			"GetKnowledgeBaseArticles",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/knowledge-base",
+			handleFunctions.AmbulanceCounselingKnowledgeBaseAPI.GetKnowledgeBaseArticles,
+		},
+		{
+			"GetPendingDoctors",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/admin/doctors/pending",
+			handleFunctions.AmbulanceCounselingAdminAPI.GetPendingDoctors,
+		},
+		{
+			"GetProfile",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/profile",
+			handleFunctions.AmbulanceCounselingProfileAPI.GetProfile,
+		},
 		{
 			"GetQuestionById",
 			http.MethodGet,
@@ -113,6 +317,12 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/ak-ambulance-counseling-api/questions",
 			handleFunctions.AmbulanceCounselingAPI.GetQuestions,
 		},
+		{
+			"GetQueue",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/queue",
+			handleFunctions.AmbulanceCounselingAPI.GetQueue,
+		},
 		{
 			"GetRepliesByQuestionId",
 			http.MethodGet,
@@ -125,30 +335,162 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/ak-ambulance-counseling-api/questions/:questionId/reply/:replyId",
 			handleFunctions.AmbulanceCounselingAPI.GetReplyById,
 		},
+		{
+			"GetUsers",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/admin/users",
+			handleFunctions.AmbulanceCounselingAdminAPI.GetUsers,
+		},
+		{
+			"GetWebhookDeliveries",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/admin/webhooks/:webhookId/deliveries",
+			handleFunctions.AmbulanceCounselingWebhookAPI.GetWebhookDeliveries,
+		},
+		{
+			"GetWebhooks",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/admin/webhooks",
+			handleFunctions.AmbulanceCounselingWebhookAPI.GetWebhooks,
+		},
+		{
+			"JoinQuestionChat",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/questions/:questionId/chat",
+			handleFunctions.AmbulanceCounselingChatAPI.JoinQuestionChat,
+		},
+		{
+			"PublishQuestion",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/publish",
+			handleFunctions.AmbulanceCounselingKnowledgeBaseAPI.PublishQuestion,
+		},
+		{
+			"RefreshToken",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/refresh",
+			handleFunctions.AmbulanceCounselingAuthAPI.RefreshToken,
+		},
+		{
+			"RejectDoctor",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/doctors/:userId/reject",
+			handleFunctions.AmbulanceCounselingAdminAPI.RejectDoctor,
+		},
+		{
+			"ReleaseQuestion",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/release",
+			handleFunctions.AmbulanceCounselingAssignmentAPI.ReleaseQuestion,
+		},
+		{
+			"ReopenQuestion",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/reopen",
+			handleFunctions.AmbulanceCounselingAPI.ReopenQuestion,
+		},
 		{
 			"ReplyToQuestion",
 			http.MethodPost,
 			"/ak-ambulance-counseling-api/questions/:questionId/reply",
 			handleFunctions.AmbulanceCounselingAPI.ReplyToQuestion,
 		},
+		{
+			"RequestPasswordReset",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/password-reset/request",
+			handleFunctions.AmbulanceCounselingAuthAPI.RequestPasswordReset,
+		},
+		{
+			"ResetUserPassword",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/admin/users/:userId/password-reset",
+			handleFunctions.AmbulanceCounselingAdminAPI.ResetUserPassword,
+		},
+		{
+			"ResendVerificationEmail",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/verify-email/resend",
+			handleFunctions.AmbulanceCounselingAuthAPI.ResendVerificationEmail,
+		},
+		{
+			"UnpublishQuestion",
+			http.MethodDelete,
+			"/ak-ambulance-counseling-api/questions/:questionId/publish",
+			handleFunctions.AmbulanceCounselingKnowledgeBaseAPI.UnpublishQuestion,
+		},
+		{
+			"UpdateCategory",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/admin/categories/:categoryId",
+			handleFunctions.AmbulanceCounselingCategoryAPI.UpdateCategory,
+		},
+		{
+			"UpdateProfile",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/profile",
+			handleFunctions.AmbulanceCounselingProfileAPI.UpdateProfile,
+		},
 		{
 			"UpdateQuestionById",
 			http.MethodPut,
 			"/ak-ambulance-counseling-api/update/question/:questionId",
 			handleFunctions.AmbulanceCounselingAPI.UpdateQuestionById,
 		},
+		{
+			"UpdateQuestionPriority",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/update/question/:questionId/priority",
+			handleFunctions.AmbulanceCounselingAPI.UpdateQuestionPriority,
+		},
+		{
+			"UpdateQuestionVisibility",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/update/question/:questionId/visibility",
+			handleFunctions.AmbulanceCounselingAPI.UpdateQuestionVisibility,
+		},
 		{
 			"UpdateReplyById",
 			http.MethodPut,
 			"/ak-ambulance-counseling-api/update/reply/:replyId",
 			handleFunctions.AmbulanceCounselingAPI.UpdateReplyById,
 		},
+		{
+			"UpdateWebhook",
+			http.MethodPut,
+			"/ak-ambulance-counseling-api/admin/webhooks/:webhookId",
+			handleFunctions.AmbulanceCounselingWebhookAPI.UpdateWebhook,
+		},
+		{
+			"UploadQuestionAttachment",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/attachments",
+			handleFunctions.AmbulanceCounselingAttachmentAPI.UploadQuestionAttachment,
+		},
+		{
+			"UploadReplyAttachment",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/questions/:questionId/reply/:replyId/attachments",
+			handleFunctions.AmbulanceCounselingAttachmentAPI.UploadReplyAttachment,
+		},
+		{
+			"VerifyEmail",
+			http.MethodGet,
+			"/ak-ambulance-counseling-api/verify-email",
+			handleFunctions.AmbulanceCounselingAuthAPI.VerifyEmail,
+		},
 		{
 			"UserLogin",
 			http.MethodPost,
 			"/ak-ambulance-counseling-api/login",
 			handleFunctions.AmbulanceCounselingAuthAPI.UserLogin,
 		},
+		{
+			"UserLogout",
+			http.MethodPost,
+			"/ak-ambulance-counseling-api/logout",
+			handleFunctions.AmbulanceCounselingAuthAPI.UserLogout,
+		},
 		{
 			"UserRegister",
 			http.MethodPost,
@@ -0,0 +1,132 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/mail_service"
+)
+
+// base URL of the web application, used in links sent by email
+var webAppUrl = strings.TrimSuffix(os.Getenv("AMBULANCE_COUNSELING_WEB_APP_URL"), "/")
+
+const (
+	passwordResetTokenTTL     = time.Hour
+	emailVerificationTokenTTL = 48 * time.Hour
+)
+
+// approval states of self-registered doctors
+const (
+	doctorPending  = "pending"
+	doctorApproved = "approved"
+	doctorRejected = "rejected"
+)
+
+// userAccounts bundles the account operations shared by the auth and admin APIs
+type userAccounts struct {
+	userDbService         db_service.DbService[User]
+	refreshTokenDbService db_service.DbService[RefreshToken]
+	mailer                mail_service.Mailer
+}
+
+// Stores a new email verification token for the user and mails it to them
+func (a *userAccounts) sendEmailVerification(ctx context.Context, user *User) error {
+	token, err := generateRandomID()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(emailVerificationTokenTTL)
+	user.EmailVerificationTokenHash = hashToken(token)
+	user.EmailVerificationExpiresAt = &expiresAt
+	if err := a.userDbService.UpdateDocument(ctx, user.Id, user); err != nil {
+		return err
+	}
+
+	return a.mailer.SendMail(ctx, mail_service.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hello %s,\n\nplease confirm your email address by opening the following link:\n%s/verify-email?token=%s\n\n"+
+				"The link expires in %v.\n",
+			user.Name, webAppUrl, token, emailVerificationTokenTTL,
+		),
+	})
+}
+
+// Stores a new password reset token for the user and mails it to them
+func (a *userAccounts) sendPasswordReset(ctx context.Context, user *User) error {
+	token, err := generateRandomID()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(passwordResetTokenTTL)
+	user.PasswordResetTokenHash = hashToken(token)
+	user.PasswordResetExpiresAt = &expiresAt
+	if err := a.userDbService.UpdateDocument(ctx, user.Id, user); err != nil {
+		return err
+	}
+
+	return a.mailer.SendMail(ctx, mail_service.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf(
+			"Hello %s,\n\nuse the following link to set a new password:\n%s/reset-password?token=%s\n\n"+
+				"The link expires in %v. If you did not ask for a password reset, ignore this email.\n",
+			user.Name, webAppUrl, token, passwordResetTokenTTL,
+		),
+	})
+}
+
+// Informs the doctor about the result of the credential verification
+func (a *userAccounts) sendApprovalDecision(ctx context.Context, user *User) error {
+	body := fmt.Sprintf(
+		"Hello %s,\n\nyour doctor account has been approved, you can now answer patient questions.\n", user.Name,
+	)
+	if user.ApprovalStatus == doctorRejected {
+		body = fmt.Sprintf("Hello %s,\n\nwe could not verify your doctor credentials.\n", user.Name)
+		if user.RejectionReason != "" {
+			body += fmt.Sprintf("\nReason: %s\n", user.RejectionReason)
+		}
+	}
+
+	return a.mailer.SendMail(ctx, mail_service.Message{
+		To:      user.Email,
+		Subject: "Doctor account review",
+		Body:    body,
+	})
+}
+
+// Ends all sessions of the user by deleting their refresh tokens
+func (a *userAccounts) revokeUserSessions(ctx context.Context, userId string) {
+	tokens, err := a.refreshTokenDbService.FindDocumentsByField(ctx, "userId", userId)
+	if err != nil {
+		log.Printf("Failed to find refresh tokens of user %s: %v", userId, err)
+		return
+	}
+	for _, token := range tokens {
+		if err := a.refreshTokenDbService.DeleteDocument(ctx, token.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to delete refresh token of user %s: %v", userId, err)
+		}
+	}
+}
+
+// Deletes all refresh tokens rotated from the same login
+func (a *userAccounts) revokeTokenFamily(ctx context.Context, familyId string) {
+	tokens, err := a.refreshTokenDbService.FindDocumentsByField(ctx, "familyId", familyId)
+	if err != nil {
+		log.Printf("Failed to find refresh tokens of family %s: %v", familyId, err)
+		return
+	}
+	for _, token := range tokens {
+		if err := a.refreshTokenDbService.DeleteDocument(ctx, token.Id); err != nil && err != db_service.ErrNotFound {
+			log.Printf("Failed to delete refresh token of family %s: %v", familyId, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"log"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+)
+
+// StoredUserFields reads the account fields users stored before they were introduced lack,
+// the fields missing in a stored account are nil
+type StoredUserFields struct {
+	Id string `bson:"id"`
+
+	Type string `bson:"type"`
+
+	EmailVerified *bool `bson:"emailVerified"`
+
+	ApprovalStatus *string `bson:"approvalStatus"`
+}
+
+// MigrateUsers completes the accounts stored before email verification was required and
+// before doctor credentials were reviewed. Their owners could log in and doctors could answer
+// questions already, so the accounts are marked as verified and the doctors as approved.
+// Running the migration again skips migrated accounts. Returns the number of migrated accounts.
+func MigrateUsers(ctx context.Context, userDbService db_service.DbService[User], storedDbService db_service.DbService[StoredUserFields]) (int, error) {
+	unverifiedUsers, err := storedDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{{Field: "emailVerified", Operator: db_service.OpEq, Value: nil}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	// doctors registered since the review was introduced always have a status
+	unreviewedDoctors, err := storedDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{
+			{Field: "type", Operator: db_service.OpEq, Value: "doctor"},
+			{Field: "approvalStatus", Operator: db_service.OpEq, Value: nil},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	seen := map[string]bool{}
+	for _, legacy := range append(unverifiedUsers, unreviewedDoctors...) {
+		if seen[legacy.Id] {
+			continue
+		}
+		seen[legacy.Id] = true
+
+		user, err := userDbService.FindDocument(ctx, legacy.Id)
+		if err == db_service.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		if legacy.EmailVerified == nil {
+			user.EmailVerified = true
+		}
+		if user.Type == "doctor" && user.ApprovalStatus == "" {
+			user.ApprovalStatus = doctorApproved
+		}
+		if err := userDbService.UpdateDocument(ctx, user.Id, user); err != nil {
+			return migrated, err
+		}
+		log.Printf("Migrated account of user %s", user.Id)
+		migrated++
+	}
+	return migrated, nil
+}
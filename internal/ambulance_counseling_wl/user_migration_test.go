@@ -0,0 +1,91 @@
+package ambulance_counseling_wl
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMigrateUsers(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	// the account was stored before email verification, the unverified one after it
+	legacy := &User{Id: "legacy-1", Name: "Lena Legacy", Email: "lena@example.com", Type: "patient"}
+	unverified := &User{Id: "unverified-1", Name: "Una Unverified", Email: "una@example.com", Type: "patient"}
+	server.seedUser(legacy, testPassword)
+	server.seedUser(unverified, testPassword)
+	notVerified := false
+	storedDbService := db_service.NewMemoryService[StoredUserFields]()
+	for _, stored := range []*StoredUserFields{{Id: legacy.Id}, {Id: unverified.Id, EmailVerified: &notVerified}} {
+		if err := storedDbService.CreateDocument(ctx, stored.Id, stored); err != nil {
+			t.Fatalf("failed to seed stored fields: %v", err)
+		}
+	}
+
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: legacy.Email, Password: testPassword}), http.StatusForbidden)
+
+	migrated, err := MigrateUsers(ctx, server.userDbService, storedDbService)
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated account, got %d: %v", migrated, err)
+	}
+
+	server.login(legacy.Email, testPassword)
+	expectStatus(t, server.do(http.MethodPost, "/login", nil, LoginForm{Email: unverified.Email, Password: testPassword}), http.StatusForbidden)
+}
+
+func TestMigrateUsersApprovesDoctors(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	// the doctor was stored before credentials were reviewed, the pending one after it
+	legacy := &User{Id: "legacy-doctor", Name: "Lisa Cuddy", Email: "cuddy@example.com", Type: "doctor", EmailVerified: true}
+	pending := &User{Id: "pending-doctor", Name: "Robert Chase", Email: "chase@example.com", Type: "doctor", ApprovalStatus: doctorPending, EmailVerified: true}
+	server.seedUser(legacy, testPassword)
+	server.seedUser(pending, testPassword)
+	verified, pendingStatus := true, doctorPending
+	storedDbService := db_service.NewMemoryService[StoredUserFields]()
+	for _, stored := range []*StoredUserFields{
+		{Id: legacy.Id, Type: "doctor", EmailVerified: &verified},
+		{Id: pending.Id, Type: "doctor", EmailVerified: &verified, ApprovalStatus: &pendingStatus},
+	} {
+		if err := storedDbService.CreateDocument(ctx, stored.Id, stored); err != nil {
+			t.Fatalf("failed to seed stored fields: %v", err)
+		}
+	}
+
+	expectStatus(t, server.send(http.MethodGet, "/queue", server.login(legacy.Email, testPassword).Token, nil), http.StatusForbidden)
+
+	migrated, err := MigrateUsers(ctx, server.userDbService, storedDbService)
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated account, got %d: %v", migrated, err)
+	}
+
+	expectStatus(t, server.send(http.MethodGet, "/queue", server.login(legacy.Email, testPassword).Token, nil), http.StatusOK)
+	expectStatus(t, server.send(http.MethodGet, "/queue", server.login(pending.Email, testPassword).Token, nil), http.StatusForbidden)
+}
+
+func TestTokenWithoutEmailVerifiedClaim(t *testing.T) {
+	server := newTestServer(t)
+
+	// tokens issued before email verification was required carry no claim
+	claims := JWTClaims{
+		UserId:   testPatient.Id,
+		UserName: testPatient.Name,
+		UserType: testPatient.Type,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "legacy-token",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecretKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	expectStatus(t, server.send(http.MethodPost, "/questions/new", token, Question{Summary: "Cough", Category: "cardiology"}), http.StatusCreated)
+}
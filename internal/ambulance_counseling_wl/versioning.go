@@ -0,0 +1,55 @@
+package ambulance_counseling_wl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maximal number of read-modify-write attempts before giving up on a concurrent update
+const maxUpdateAttempts = 3
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+func (q *Question) GetVersion() int64 {
+	return q.Version
+}
+
+func (q *Question) SetVersion(version int64) {
+	q.Version = version
+}
+
+func (r *Reply) GetVersion() int64 {
+	return r.Version
+}
+
+func (r *Reply) SetVersion(version int64) {
+	r.Version = version
+}
+
+func versionETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// Helper function to check if request carries an If-Match precondition
+func hasIfMatch(c *gin.Context) bool {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	return value != "" && value != "*"
+}
+
+// Helper function to check the If-Match header against the current document version
+func ifMatchSatisfied(c *gin.Context, version int64) bool {
+	if !hasIfMatch(c) {
+		return true
+	}
+	current := versionETag(version)
+	for _, tag := range strings.Split(c.GetHeader("If-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package ambulance_counseling_wl
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+// who besides the patient and the doctors taking part may read a question, questions stored
+// before visibility existed have none and are private
+const (
+	visibilityPrivate    = "private"
+	visibilityPublic     = "public"
+	visibilityAnonymized = "anonymized"
+)
+
+var questionVisibilities = map[string]bool{
+	visibilityPrivate:    true,
+	visibilityPublic:     true,
+	visibilityAnonymized: true,
+}
+
+// Helper function to check if the caller is signed in, read routes accept anonymous requests
+func isAuthenticated(c *gin.Context) bool {
+	return c.GetString("userId") != ""
+}
+
+// Helper function to check if the caller takes part in the question - the patient who asked it
+// and doctors it is assigned to or who may take it from their queue
+func isParticipant(c *gin.Context, question *Question) bool {
+	if isCreator(c, question.PatientId) {
+		return true
+	}
+	if !isDoctor(c) {
+		return false
+	}
+	if question.AssignedDoctorId == c.GetString("userId") {
+		return true
+	}
+	return currentQuestionStatus(question) == statusWaitingForDoctor && activeAssignee(question, time.Now()) == "" &&
+		handlesCategory(c.GetStringSlice("userSpecialties"), question.Category)
+}
+
+// Helper function to check if the caller may read the question and its replies
+func canReadQuestion(c *gin.Context, question *Question) bool {
+	if isParticipant(c, question) {
+		return true
+	}
+	return question.Visibility == visibilityPublic || question.Visibility == visibilityAnonymized
+}
+
+// Helper function to check if the caller may download the attachments of the question. Files
+// may identify the patient, so they stay with the participants even when anybody may read the question
+func canReadAttachments(c *gin.Context, question *Question) bool {
+	return isParticipant(c, question)
+}
+
+// Restricts a question listing to the questions the caller may read, the same ones
+// canReadQuestion allows. Listings of another patient's questions leave out the anonymized
+// ones the caller does not take part in, the filter would link them to the patient.
+func restrictQuestionQuery(c *gin.Context, query *db_service.Query) {
+	userId := c.GetString("userId")
+	query.AnyOfGroups = [][]db_service.FieldFilter{
+		{{Field: "visibility", Operator: db_service.OpEq, Value: visibilityPublic}},
+	}
+	if patientId := c.Query("patientId"); patientId == "" || patientId == userId {
+		query.AnyOfGroups = append(query.AnyOfGroups, []db_service.FieldFilter{{Field: "visibility", Operator: db_service.OpEq, Value: visibilityAnonymized}})
+	}
+	if isAuthenticated(c) {
+		query.AnyOfGroups = append(query.AnyOfGroups, []db_service.FieldFilter{{Field: "patientid", Operator: db_service.OpEq, Value: userId}})
+	}
+	if !isDoctor(c) {
+		return
+	}
+
+	query.AnyOfGroups = append(query.AnyOfGroups, []db_service.FieldFilter{{Field: "assigneddoctorid", Operator: db_service.OpEq, Value: userId}})
+	// questions in the queue of the doctor, see isParticipant
+	queue := []db_service.FieldFilter{
+		{Field: "status", Operator: db_service.OpIn, Value: []interface{}{statusWaitingForDoctor, "", nil}},
+	}
+	if specialties := c.GetStringSlice("userSpecialties"); len(specialties) > 0 {
+		queue = append(queue, db_service.FieldFilter{Field: "category", Operator: db_service.OpIn, Value: categoriesOf(specialties)})
+	}
+	for _, unclaimed := range unclaimedFilters(time.Now()) {
+		query.AnyOfGroups = append(query.AnyOfGroups, append(slices.Clone(queue), unclaimed))
+	}
+}
+
+// Removes the patient identity from an anonymized question unless the caller
+// takes part in it, the question is modified in place
+func redactQuestion(c *gin.Context, question *Question) {
+	if question.Visibility != visibilityAnonymized || isParticipant(c, question) {
+		return
+	}
+	redactAttachments(question.PatientId, question.Attachments)
+	redactReplies(question.PatientId, question.Replies)
+	question.PatientId = ""
+}
+
+// Removes the patient identity from their own replies and the files they uploaded
+func redactReplies(patientId string, replies []Reply) {
+	for i := range replies {
+		redactAttachments(patientId, replies[i].Attachments)
+		if replies[i].UserId == patientId {
+			replies[i].UserId = ""
+		}
+	}
+}
+
+// Removes the patient identity from the files they uploaded, original file names often
+// carry the name of the patient so only the extension is kept
+func redactAttachments(patientId string, attachments []Attachment) {
+	for i := range attachments {
+		if attachments[i].UploadedBy != patientId {
+			continue
+		}
+		attachments[i].UploadedBy = ""
+		attachments[i].FileName = "attachment" + strings.ToLower(path.Ext(attachments[i].FileName))
+	}
+}
@@ -0,0 +1,264 @@
+package ambulance_counseling_wl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/event_service"
+)
+
+// webhookEventTypes lists the events endpoints can subscribe to
+var webhookEventTypes = map[string]bool{
+	questionEventCreated:  true,
+	questionEventClosed:   true,
+	questionEventResolved: true,
+	questionEventReopened: true,
+	replyEventCreated:     true,
+	replyEventUpdated:     true,
+	replyEventDeleted:     true,
+}
+
+// states of webhook deliveries
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryFailed    = "failed"
+)
+
+const (
+	webhookMaxAttempts = 8
+	// the wait before a retry doubles with every failed attempt
+	webhookInitialBackoff = 30 * time.Second
+	webhookRequestTimeout = 10 * time.Second
+	// how often pending deliveries are checked for due retries
+	webhookRetryCheckInterval = 5 * time.Second
+)
+
+// name of the webhook dispatcher in the records of processed events
+const webhookConsumer = "webhooks"
+
+// webhookPayload is the JSON body posted to the endpoints
+type webhookPayload struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDispatcher posts question and reply events to the subscribed endpoints. Every event
+// is recorded as a delivery first, so that failed deliveries are retried and can be inspected.
+type WebhookDispatcher struct {
+	webhookDbService         db_service.DbService[Webhook]
+	webhookDeliveryDbService db_service.DbService[WebhookDelivery]
+	processedEventDbService  db_service.DbService[ProcessedEvent]
+	replyEventBus            event_service.EventBus[ReplyEvent]
+	questionEventBus         event_service.EventBus[QuestionEvent]
+	client                   *http.Client
+}
+
+func NewWebhookDispatcher(webhookDbService db_service.DbService[Webhook], webhookDeliveryDbService db_service.DbService[WebhookDelivery], processedEventDbService db_service.DbService[ProcessedEvent], replyEventBus event_service.EventBus[ReplyEvent], questionEventBus event_service.EventBus[QuestionEvent]) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		webhookDbService:         webhookDbService,
+		webhookDeliveryDbService: webhookDeliveryDbService,
+		processedEventDbService:  processedEventDbService,
+		replyEventBus:            replyEventBus,
+		questionEventBus:         questionEventBus,
+		client:                   &http.Client{Timeout: webhookRequestTimeout},
+	}
+}
+
+// Run records published events and delivers them until the context ends
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	replies, unsubscribeReplies := d.replyEventBus.Subscribe()
+	defer unsubscribeReplies()
+	questions, unsubscribeQuestions := d.questionEventBus.Subscribe()
+	defer unsubscribeQuestions()
+
+	// slow endpoints must not hold up the recording of new events
+	wake := make(chan struct{}, 1)
+	go d.deliverLoop(ctx, wake)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-replies:
+			d.consume(ctx, event.Id, event.Type, event)
+		case event := <-questions:
+			d.consume(ctx, event.Id, event.Type, event)
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliverLoop(ctx context.Context, wake <-chan struct{}) {
+	ticker := time.NewTicker(webhookRetryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+		d.deliverDue(ctx, time.Now())
+	}
+}
+
+// Records the deliveries of a published event and acknowledges it to the outbox, an event whose
+// deliveries were not all recorded is published again
+func (d *WebhookDispatcher) consume(ctx context.Context, eventId string, eventType string, data interface{}) {
+	if err := d.enqueue(ctx, eventId, eventType, data, time.Now()); err != nil {
+		return
+	}
+	acknowledgeEvent(ctx, d.processedEventDbService, webhookConsumer, eventId)
+}
+
+// Records a pending delivery of the event for every webhook subscribed to its type. Deliveries are
+// identified by the event and the webhook, so a redelivered event is recorded only once.
+func (d *WebhookDispatcher) enqueue(ctx context.Context, eventId string, eventType string, data interface{}, now time.Time) error {
+	webhooks, err := d.webhookDbService.FindDocumentsByField(ctx, "eventTypes", eventType)
+	if err != nil {
+		log.Printf("Failed to find webhooks for %s: %v", eventType, err)
+		return err
+	}
+
+	var failure error
+	for _, webhook := range webhooks {
+		id := eventId + "-" + webhook.Id
+		payload, err := json.Marshal(webhookPayload{Id: id, Type: eventType, CreatedAt: now, Data: data})
+		if err != nil {
+			log.Printf("Failed to encode %s for webhook %s: %v", eventType, webhook.Id, err)
+			continue
+		}
+
+		delivery := &WebhookDelivery{
+			Id:            id,
+			WebhookId:     webhook.Id,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        webhookDeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: &now,
+		}
+		if err := d.webhookDeliveryDbService.CreateDocument(ctx, delivery.Id, delivery); err != nil && err != db_service.ErrConflict {
+			log.Printf("Failed to record %s for webhook %s: %v", eventType, webhook.Id, err)
+			failure = err
+		}
+	}
+	return failure
+}
+
+// Attempts every pending delivery whose next attempt is due, the oldest first
+func (d *WebhookDispatcher) deliverDue(ctx context.Context, now time.Time) {
+	deliveries, err := d.webhookDeliveryDbService.FindDocumentsByQuery(ctx, db_service.Query{
+		Filters: []db_service.FieldFilter{
+			{Field: "status", Operator: db_service.OpEq, Value: webhookDeliveryPending},
+			{Field: "nextAttemptAt", Operator: db_service.OpLte, Value: now},
+		},
+		SortField: "nextAttemptAt",
+	})
+	if err != nil {
+		log.Printf("Failed to load pending webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.attempt(ctx, delivery, now)
+		if err := d.webhookDeliveryDbService.UpdateDocument(ctx, delivery.Id, delivery); err != nil {
+			log.Printf("Failed to update webhook delivery %s: %v", delivery.Id, err)
+		}
+	}
+}
+
+// Posts the payload once and records the outcome on the delivery
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *WebhookDelivery, now time.Time) {
+	delivery.Attempts++
+
+	webhook, err := d.webhookDbService.FindDocument(ctx, delivery.WebhookId)
+	if err != nil {
+		if err == db_service.ErrNotFound {
+			delivery.Status = webhookDeliveryFailed
+			delivery.NextAttemptAt = nil
+			delivery.Error = "Webhook has been deleted"
+			return
+		}
+		d.retryLater(delivery, now, "Failed to load the webhook")
+		return
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		d.retryLater(delivery, now, err.Error())
+		return
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "ambulance-counseling-webhooks")
+	request.Header.Set("X-Webhook-Event", delivery.EventType)
+	request.Header.Set("X-Webhook-Delivery", delivery.Id)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", signWebhookPayload(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		delivery.ResponseStatus = 0
+		d.retryLater(delivery, now, err.Error())
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	response.Body.Close()
+
+	delivery.ResponseStatus = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		d.retryLater(delivery, now, fmt.Sprintf("Endpoint responded with %d", response.StatusCode))
+		return
+	}
+
+	delivery.Status = webhookDeliveryDelivered
+	delivery.Error = ""
+	delivery.NextAttemptAt = nil
+	delivery.DeliveredAt = &now
+}
+
+// Schedules the next attempt with exponential backoff, gives up after the last attempt
+func (d *WebhookDispatcher) retryLater(delivery *WebhookDelivery, now time.Time, reason string) {
+	delivery.Error = reason
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = webhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		return
+	}
+	nextAttemptAt := now.Add(webhookBackoff(delivery.Attempts))
+	delivery.NextAttemptAt = &nextAttemptAt
+}
+
+// Wait after the given number of failed attempts - 30s, 1m, 2m and so on, the last one about half an hour
+func webhookBackoff(attempts int) time.Duration {
+	return webhookInitialBackoff << (attempts - 1)
+}
+
+// Signature of the payload sent in the X-Webhook-Signature header. Receivers compute the
+// HMAC-SHA256 of the timestamp header, a dot and the raw body with the shared secret.
+func signWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
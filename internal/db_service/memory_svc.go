@@ -0,0 +1,566 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memorySvc keeps documents in process memory. Documents are stored BSON encoded,
+// so field names, dotted lookups and comparisons behave the same way as with MongoDB
+// and callers never share memory with the stored copies.
+type memorySvc[DocType interface{}] struct {
+	lock      sync.RWMutex
+	documents map[string]bson.Raw
+	// insertion order, mirrors the natural order of a MongoDB collection
+	order []string
+}
+
+func NewMemoryService[DocType interface{}]() DbService[DocType] {
+	return &memorySvc[DocType]{
+		documents: map[string]bson.Raw{},
+	}
+}
+
+func (m *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.documents[id]; exists {
+		return ErrConflict
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.remember(ctx, id)
+	m.documents[id] = raw
+	m.order = append(m.order, id)
+	return nil
+}
+
+func (m *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, exists := m.documents[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(storedVersion(stored) + 1)
+	}
+
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.remember(ctx, id)
+	m.documents[id] = raw
+	return nil
+}
+
+func (m *memorySvc[DocType]) UpdateDocumentIfVersion(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	versioned, ok := any(document).(Versioned)
+	if !ok {
+		return ErrNotVersioned
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored, exists := m.documents[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if storedVersion(stored) != expectedVersion {
+		return ErrVersionMismatch
+	}
+
+	versioned.SetVersion(expectedVersion + 1)
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		versioned.SetVersion(expectedVersion)
+		return err
+	}
+	m.remember(ctx, id)
+	m.documents[id] = raw
+	return nil
+}
+
+func (m *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.documents[id]; !exists {
+		return ErrNotFound
+	}
+	m.remember(ctx, id)
+	delete(m.documents, id)
+	m.removeFromOrder(id)
+	return nil
+}
+
+func (m *memorySvc[DocType]) removeFromOrder(id string) {
+	for i, orderedId := range m.order {
+		if orderedId == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Records how to restore the document when the unit of work of the context fails, call it
+// with the lock held right before the document changes
+func (m *memorySvc[DocType]) remember(ctx context.Context, id string) {
+	previous, existed := m.documents[id]
+	position := -1
+	for i, orderedId := range m.order {
+		if orderedId == id {
+			position = i
+			break
+		}
+	}
+
+	recordUndo(ctx, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		if !existed {
+			delete(m.documents, id)
+			m.removeFromOrder(id)
+			return
+		}
+		if _, exists := m.documents[id]; !exists {
+			position = min(position, len(m.order))
+			m.order = append(m.order[:position], append([]string{id}, m.order[position:]...)...)
+		}
+		m.documents[id] = previous
+	})
+}
+
+func (m *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	raw, exists := m.documents[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	var document *DocType
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (m *memorySvc[DocType]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	return m.FindDocumentsByQuery(ctx, Query{})
+}
+
+func (m *memorySvc[DocType]) FindDocumentsByField(ctx context.Context, fieldName string, fieldValue interface{}) ([]*DocType, error) {
+	return m.FindDocumentsByQuery(ctx, Query{
+		Filters: []FieldFilter{{Field: fieldName, Operator: OpEq, Value: fieldValue}},
+	})
+}
+
+func (m *memorySvc[DocType]) FindDocumentsByQuery(ctx context.Context, query Query) ([]*DocType, error) {
+	filters, err := normalizeFilters(query.Filters)
+	if err != nil {
+		return nil, err
+	}
+	anyOf, err := normalizeFilters(query.AnyOf)
+	if err != nil {
+		return nil, err
+	}
+	anyOfGroups := make([][]FieldFilter, len(query.AnyOfGroups))
+	for i, group := range query.AnyOfGroups {
+		if anyOfGroups[i], err = normalizeFilters(group); err != nil {
+			return nil, err
+		}
+	}
+
+	var after *QueryCursor
+	if query.After != nil && query.SortField != "" {
+		value, err := normalizeValue(query.After.SortValue)
+		if err != nil {
+			return nil, err
+		}
+		after = &QueryCursor{SortValue: value, Id: query.After.Id}
+	}
+
+	m.lock.RLock()
+	var matches []bson.M
+	for _, id := range m.order {
+		var document bson.M
+		if err := bson.Unmarshal(m.documents[id], &document); err != nil {
+			m.lock.RUnlock()
+			return nil, err
+		}
+		if matchesFilters(document, filters) && (len(anyOf) == 0 || matchesAnyFilter(document, anyOf)) && (len(anyOfGroups) == 0 || matchesAnyGroup(document, anyOfGroups)) {
+			matches = append(matches, document)
+		}
+	}
+	m.lock.RUnlock()
+
+	if query.SortField != "" {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return compareForSort(matches[i], matches[j], query.SortField, query.SortDescending) < 0
+		})
+	}
+
+	results := []*DocType{}
+	for _, document := range matches {
+		if after != nil && !isAfterCursor(document, after, query.SortField, query.SortDescending) {
+			continue
+		}
+		if query.Limit > 0 && int64(len(results)) >= query.Limit {
+			break
+		}
+		for _, field := range query.ExcludeFields {
+			removeField(document, strings.Split(field, "."))
+		}
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		var result *DocType
+		if err := bson.Unmarshal(raw, &result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Documents are scanned on every query, there is nothing to index
+func (m *memorySvc[DocType]) EnsureIndex(ctx context.Context, fields ...string) error {
+	return nil
+}
+
+func (m *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func storedVersion(raw bson.Raw) int64 {
+	value, err := raw.LookupErr("version")
+	if err != nil {
+		// documents stored before versioning was introduced
+		return 0
+	}
+	if version, ok := value.AsInt64OK(); ok {
+		return version
+	}
+	if version, ok := value.Int32OK(); ok {
+		return int64(version)
+	}
+	return 0
+}
+
+func normalizeFilters(filters []FieldFilter) ([]FieldFilter, error) {
+	normalized := make([]FieldFilter, len(filters))
+	for i, filter := range filters {
+		value, err := normalizeValue(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Operator == OpRegex {
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("regular expression for field %v must be a string", filter.Field)
+			}
+			if value, err = regexp.Compile(pattern); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := value.(bson.A); filter.Operator == OpIn && !ok {
+			return nil, fmt.Errorf("values for field %v must be a slice", filter.Field)
+		}
+		normalized[i] = FieldFilter{Field: filter.Field, Operator: filter.Operator, Value: value}
+	}
+	return normalized, nil
+}
+
+// Converts a Go value into the representation it has after a BSON round trip
+func normalizeValue(value interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return nil, err
+	}
+	var wrapper bson.M
+	if err := bson.Unmarshal(raw, &wrapper); err != nil {
+		return nil, err
+	}
+	return wrapper["v"], nil
+}
+
+// Resolves a dotted path the way MongoDB does - arrays along the path are traversed
+// and every element is a candidate value. Missing fields yield no candidates.
+func lookupValues(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if array, ok := value.(bson.A); ok {
+			// an array field matches both as a whole and through any of its elements
+			return append([]interface{}{array}, array...)
+		}
+		return []interface{}{value}
+	}
+
+	switch typed := value.(type) {
+	case bson.M:
+		child, exists := typed[path[0]]
+		if !exists {
+			return nil
+		}
+		return lookupValues(child, path[1:])
+	case bson.A:
+		var values []interface{}
+		for _, element := range typed {
+			values = append(values, lookupValues(element, path)...)
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func matchesFilters(document bson.M, filters []FieldFilter) bool {
+	for _, filter := range filters {
+		if !matchesFilter(document, filter) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAnyFilter(document bson.M, filters []FieldFilter) bool {
+	for _, filter := range filters {
+		if matchesFilter(document, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyGroup(document bson.M, groups [][]FieldFilter) bool {
+	for _, group := range groups {
+		if matchesFilters(document, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesFilter(document bson.M, filter FieldFilter) bool {
+	candidates := lookupValues(document, strings.Split(filter.Field, "."))
+
+	if filter.Operator == OpNe {
+		return !matchesFilter(document, FieldFilter{Field: filter.Field, Operator: OpEq, Value: filter.Value})
+	}
+
+	if filter.Operator == OpIn {
+		values, _ := filter.Value.(bson.A)
+		for _, value := range values {
+			if matchesFilter(document, FieldFilter{Field: filter.Field, Operator: OpEq, Value: value}) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if filter.Operator == OpEq && filter.Value == nil && len(candidates) == 0 {
+		// missing fields are equal to null in MongoDB
+		return true
+	}
+
+	if filter.Operator == OpRegex {
+		pattern := filter.Value.(*regexp.Regexp)
+		for _, candidate := range candidates {
+			if text, ok := candidate.(string); ok && pattern.MatchString(text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, candidate := range candidates {
+		result, comparable := compareValues(candidate, filter.Value)
+		if !comparable {
+			continue
+		}
+		switch filter.Operator {
+		case OpEq:
+			if result == 0 {
+				return true
+			}
+		case OpGt:
+			if result > 0 {
+				return true
+			}
+		case OpGte:
+			if result >= 0 {
+				return true
+			}
+		case OpLt:
+			if result < 0 {
+				return true
+			}
+		case OpLte:
+			if result <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compares two normalized values, reports false when they are of incomparable types
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			return compareOrdered(x, y), true
+		}
+		return 0, false
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareOrdered(int64(x), int64(y)), true
+		}
+	case bson.A:
+		if y, ok := b.(bson.A); ok && len(x) == len(y) {
+			for i := range x {
+				if result, comparable := compareValues(x[i], y[i]); !comparable || result != 0 {
+					return 0, false
+				}
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | float64](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Orders documents by the sort field and then by id, missing values sort first
+func compareForSort(a bson.M, b bson.M, sortField string, descending bool) int {
+	result := compareSortValues(firstValue(a, sortField), firstValue(b, sortField))
+	if result == 0 {
+		result = compareSortValues(a["id"], b["id"])
+	}
+	if descending {
+		return -result
+	}
+	return result
+}
+
+func compareSortValues(a interface{}, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	result, _ := compareValues(a, b)
+	return result
+}
+
+func isAfterCursor(document bson.M, after *QueryCursor, sortField string, descending bool) bool {
+	cursorDocument := bson.M{"id": after.Id}
+	setField(cursorDocument, strings.Split(sortField, "."), after.SortValue)
+	return compareForSort(document, cursorDocument, sortField, descending) > 0
+}
+
+func firstValue(document bson.M, field string) interface{} {
+	values := lookupValues(document, strings.Split(field, "."))
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func setField(document bson.M, path []string, value interface{}) {
+	if len(path) == 1 {
+		document[path[0]] = value
+		return
+	}
+	child, ok := document[path[0]].(bson.M)
+	if !ok {
+		child = bson.M{}
+		document[path[0]] = child
+	}
+	setField(child, path[1:], value)
+}
+
+func removeField(document bson.M, path []string) {
+	if len(path) == 1 {
+		delete(document, path[0])
+		return
+	}
+	switch child := document[path[0]].(type) {
+	case bson.M:
+		removeField(child, path[1:])
+	case bson.A:
+		for _, element := range child {
+			if nested, ok := element.(bson.M); ok {
+				removeField(nested, path[1:])
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package db_service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAuthor struct {
+	Name string
+}
+
+type testDocument struct {
+	Id        string
+	Title     string
+	Score     int
+	Tags      []string
+	Author    testAuthor
+	CreatedAt time.Time
+	Version   int64
+}
+
+func (d *testDocument) GetVersion() int64 {
+	return d.Version
+}
+
+func (d *testDocument) SetVersion(version int64) {
+	d.Version = version
+}
+
+// Seeds the documents in the given order and returns the service holding them
+func newTestService(t *testing.T, documents ...testDocument) DbService[testDocument] {
+	t.Helper()
+	service := NewMemoryService[testDocument]()
+	for i := range documents {
+		if err := service.CreateDocument(context.Background(), documents[i].Id, &documents[i]); err != nil {
+			t.Fatalf("failed to seed document: %v", err)
+		}
+	}
+	return service
+}
+
+func documentIds(documents []*testDocument) string {
+	ids := []string{}
+	for _, document := range documents {
+		ids = append(ids, document.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestMemoryServiceQueryFilters(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t,
+		testDocument{Id: "a", Title: "Headache", Score: 1, Tags: []string{"pain"}, Author: testAuthor{Name: "Jane"}, CreatedAt: createdAt},
+		testDocument{Id: "b", Title: "Back pain", Score: 5, Tags: []string{"pain", "back"}, Author: testAuthor{Name: "John"}, CreatedAt: createdAt.Add(time.Hour)},
+		testDocument{Id: "c", Title: "Cough", Score: 3, Author: testAuthor{Name: "Jane"}, CreatedAt: createdAt.Add(2 * time.Hour)},
+	)
+
+	cases := []struct {
+		name  string
+		query Query
+		ids   string
+	}{
+		{"no filters", Query{}, "a,b,c"},
+		{"equal", Query{Filters: []FieldFilter{{Field: "score", Operator: OpEq, Value: 5}}}, "b"},
+		{"not equal", Query{Filters: []FieldFilter{{Field: "score", Operator: OpNe, Value: 5}}}, "a,c"},
+		{"range", Query{Filters: []FieldFilter{{Field: "score", Operator: OpGt, Value: 1}, {Field: "score", Operator: OpLte, Value: 3}}}, "c"},
+		{"time", Query{Filters: []FieldFilter{{Field: "createdat", Operator: OpGte, Value: createdAt.Add(time.Hour)}}}, "b,c"},
+		{"dotted field", Query{Filters: []FieldFilter{{Field: "author.name", Operator: OpEq, Value: "Jane"}}}, "a,c"},
+		{"array element", Query{Filters: []FieldFilter{{Field: "tags", Operator: OpEq, Value: "back"}}}, "b"},
+		{"missing array", Query{Filters: []FieldFilter{{Field: "tags", Operator: OpEq, Value: nil}}}, "c"},
+		{"regex", Query{Filters: []FieldFilter{{Field: "title", Operator: OpRegex, Value: "(?i)^c"}}}, "c"},
+		{"any of", Query{AnyOf: []FieldFilter{{Field: "score", Operator: OpEq, Value: 1}, {Field: "author.name", Operator: OpEq, Value: "John"}}}, "a,b"},
+		{"all and any of", Query{
+			Filters: []FieldFilter{{Field: "author.name", Operator: OpEq, Value: "Jane"}},
+			AnyOf:   []FieldFilter{{Field: "score", Operator: OpEq, Value: 3}, {Field: "score", Operator: OpEq, Value: 5}},
+		}, "c"},
+		{"in", Query{Filters: []FieldFilter{{Field: "score", Operator: OpIn, Value: []int{1, 3}}}}, "a,c"},
+		{"in with missing", Query{Filters: []FieldFilter{{Field: "tags", Operator: OpIn, Value: []interface{}{"back", nil}}}}, "b,c"},
+		{"any of groups", Query{AnyOfGroups: [][]FieldFilter{
+			{{Field: "author.name", Operator: OpEq, Value: "Jane"}, {Field: "score", Operator: OpGt, Value: 2}},
+			{{Field: "author.name", Operator: OpEq, Value: "John"}, {Field: "score", Operator: OpLt, Value: 2}},
+		}}, "c"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			documents, err := service.FindDocumentsByQuery(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if ids := documentIds(documents); ids != tc.ids {
+				t.Errorf("expected %v, got %v", tc.ids, ids)
+			}
+		})
+	}
+
+	if _, err := service.FindDocumentsByQuery(context.Background(), Query{Filters: []FieldFilter{{Field: "title", Operator: OpRegex, Value: "("}}}); err == nil {
+		t.Error("expected invalid regular expression to fail")
+	}
+	if _, err := service.FindDocumentsByQuery(context.Background(), Query{Filters: []FieldFilter{{Field: "score", Operator: OpIn, Value: 1}}}); err == nil {
+		t.Error("expected in without a slice to fail")
+	}
+}
+
+func TestMemoryServiceQuerySortAndLimit(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// equal sort values are ordered by id
+	service := newTestService(t,
+		testDocument{Id: "c", Score: 2, CreatedAt: createdAt},
+		testDocument{Id: "a", Score: 2, CreatedAt: createdAt},
+		testDocument{Id: "d", Score: 1, CreatedAt: createdAt.Add(time.Hour)},
+		testDocument{Id: "b", Score: 3, CreatedAt: createdAt.Add(-time.Hour)},
+	)
+
+	cases := []struct {
+		name  string
+		query Query
+		ids   string
+	}{
+		{"ascending", Query{SortField: "score"}, "d,a,c,b"},
+		{"descending", Query{SortField: "score", SortDescending: true}, "b,c,a,d"},
+		{"limit", Query{SortField: "createdat", Limit: 2}, "b,a"},
+		{"after cursor", Query{SortField: "createdat", After: &QueryCursor{SortValue: createdAt, Id: "a"}}, "c,d"},
+		{"after cursor descending", Query{SortField: "score", SortDescending: true, After: &QueryCursor{SortValue: 2, Id: "c"}, Limit: 1}, "a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			documents, err := service.FindDocumentsByQuery(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if ids := documentIds(documents); ids != tc.ids {
+				t.Errorf("expected %v, got %v", tc.ids, ids)
+			}
+		})
+	}
+}
+
+func TestMemoryServiceExcludeFields(t *testing.T) {
+	service := newTestService(t, testDocument{Id: "a", Title: "Headache", Author: testAuthor{Name: "Jane"}})
+
+	documents, err := service.FindDocumentsByQuery(context.Background(), Query{ExcludeFields: []string{"title", "author.name"}})
+	if err != nil || len(documents) != 1 {
+		t.Fatalf("expected the document, got %v: %v", documents, err)
+	}
+	if documents[0].Title != "" || documents[0].Author.Name != "" {
+		t.Errorf("expected excluded fields to be empty, got %+v", documents[0])
+	}
+	if stored, _ := service.FindDocument(context.Background(), "a"); stored.Title != "Headache" {
+		t.Errorf("excluding fields must not change the stored document, got %+v", stored)
+	}
+}
+
+func TestMemoryServiceDocumentErrors(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t, testDocument{Id: "a", Title: "Headache"})
+
+	if err := service.CreateDocument(ctx, "a", &testDocument{Id: "a"}); err != ErrConflict {
+		t.Errorf("expected %v for an existing id, got %v", ErrConflict, err)
+	}
+	if _, err := service.FindDocument(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected %v on find, got %v", ErrNotFound, err)
+	}
+	if err := service.UpdateDocument(ctx, "missing", &testDocument{Id: "missing"}); err != ErrNotFound {
+		t.Errorf("expected %v on update, got %v", ErrNotFound, err)
+	}
+	if err := service.UpdateDocumentIfVersion(ctx, "missing", 1, &testDocument{Id: "missing"}); err != ErrNotFound {
+		t.Errorf("expected %v on versioned update, got %v", ErrNotFound, err)
+	}
+	if err := service.DeleteDocument(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected %v on delete, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryServiceVersions(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t, testDocument{Id: "a", Title: "Headache"})
+
+	document, err := service.FindDocument(ctx, "a")
+	if err != nil || document.Version != 1 {
+		t.Fatalf("expected version 1 after create, got %+v: %v", document, err)
+	}
+
+	document.Title = "Migraine"
+	if err := service.UpdateDocumentIfVersion(ctx, "a", 1, document); err != nil || document.Version != 2 {
+		t.Fatalf("expected version 2 after update, got %d: %v", document.Version, err)
+	}
+
+	stale := &testDocument{Id: "a", Title: "Stale"}
+	if err := service.UpdateDocumentIfVersion(ctx, "a", 1, stale); err != ErrVersionMismatch {
+		t.Errorf("expected %v for a stale version, got %v", ErrVersionMismatch, err)
+	}
+	if stored, _ := service.FindDocument(ctx, "a"); stored.Title != "Migraine" || stored.Version != 2 {
+		t.Errorf("stale update must not be stored, got %+v", stored)
+	}
+
+	// unconditional updates still increment the version
+	if err := service.UpdateDocument(ctx, "a", stale); err != nil || stale.Version != 3 {
+		t.Errorf("expected version 3 after update, got %d: %v", stale.Version, err)
+	}
+
+	unversioned := NewMemoryService[testAuthor]()
+	if err := unversioned.UpdateDocumentIfVersion(ctx, "a", 1, &testAuthor{}); err != ErrNotVersioned {
+		t.Errorf("expected %v, got %v", ErrNotVersioned, err)
+	}
+}
+
+func TestMemoryTransactorRollsBack(t *testing.T) {
+	ctx := context.Background()
+	service := newTestService(t, testDocument{Id: "a", Title: "Headache"}, testDocument{Id: "b", Title: "Cough"})
+	transactor := NewMemoryTransactor()
+
+	err := transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := service.UpdateDocument(ctx, "a", &testDocument{Id: "a", Title: "Migraine"}); err != nil {
+			return err
+		}
+		if err := service.DeleteDocument(ctx, "b"); err != nil {
+			return err
+		}
+		if err := service.CreateDocument(ctx, "c", &testDocument{Id: "c"}); err != nil {
+			return err
+		}
+		return ErrConflict
+	})
+	if err != ErrConflict {
+		t.Fatalf("expected the error of the unit of work, got %v", err)
+	}
+
+	documents, err := service.FindAllDocuments(ctx)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if ids := documentIds(documents); ids != "a,b" || documents[0].Title != "Headache" {
+		t.Errorf("expected the documents before the unit of work, got %v %+v", ids, documents[0])
+	}
+}
@@ -0,0 +1,41 @@
+package db_service
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDatabase hands out the database of the server shared with the document services,
+// for storage the services do not cover such as GridFS buckets
+type MongoDatabase interface {
+	Database(ctx context.Context) (*mongo.Database, error)
+
+	Disconnect(ctx context.Context) error
+}
+
+type mongoDatabase struct {
+	MongoServiceConfig
+	connection *mongoConnection
+}
+
+// NewMongoDatabase returns the database of the config on the connection the services of
+// the same server use, the settings missing in the config are read from the environment
+func NewMongoDatabase(config MongoServiceConfig) MongoDatabase {
+	database := &mongoDatabase{}
+	database.MongoServiceConfig = withMongoDefaults(config)
+	database.connection = acquireMongoConnection(database.MongoServiceConfig)
+	return database
+}
+
+func (d *mongoDatabase) Database(ctx context.Context) (*mongo.Database, error) {
+	client, err := d.connection.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Database(d.DbName), nil
+}
+
+func (d *mongoDatabase) Disconnect(ctx context.Context) error {
+	return d.connection.release(ctx)
+}
@@ -18,17 +18,31 @@ import (
 type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
+	UpdateDocumentIfVersion(ctx context.Context, id string, expectedVersion int64, document *DocType) error
 	DeleteDocument(ctx context.Context, id string) error
 
 	FindDocument(ctx context.Context, id string) (*DocType, error)
 	FindAllDocuments(ctx context.Context) ([]*DocType, error)
 	FindDocumentsByField(ctx context.Context, fieldName string, fieldValue interface{}) ([]*DocType, error)
+	FindDocumentsByQuery(ctx context.Context, query Query) ([]*DocType, error)
+
+	// Creates an ascending index on the (possibly dotted) fields unless it exists
+	EnsureIndex(ctx context.Context, fields ...string) error
 
 	Disconnect(ctx context.Context) error
 }
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
+var ErrVersionMismatch = fmt.Errorf("conflict: document version mismatch")
+var ErrNotVersioned = fmt.Errorf("document type does not support versioning")
+
+// Versioned is implemented by documents whose version is maintained by the service.
+// The version starts at 1 on creation and is incremented on every update.
+type Versioned interface {
+	GetVersion() int64
+	SetVersion(version int64)
+}
 
 type MongoServiceConfig struct {
 	ServerHost string
@@ -38,15 +52,34 @@ type MongoServiceConfig struct {
 	DbName     string
 	Collection string
 	Timeout    time.Duration
+	// Units of work run without a transaction on a standalone server instead of failing
+	AllowNonAtomicWrites bool
 }
 
 type mongoSvc[DocType interface{}] struct {
 	MongoServiceConfig
-	client     atomic.Pointer[mongo.Client]
-	clientLock sync.Mutex
+	connection   *mongoConnection
+	disconnected atomic.Bool
 }
 
 func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
+	svc := &mongoSvc[DocType]{}
+	svc.MongoServiceConfig = withMongoDefaults(config)
+	svc.connection = acquireMongoConnection(svc.MongoServiceConfig)
+
+	log.Printf(
+		"MongoDB config: //%v@%v:%v/%v/%v",
+		svc.UserName,
+		svc.ServerHost,
+		svc.ServerPort,
+		svc.DbName,
+		svc.Collection,
+	)
+	return svc
+}
+
+// Fills the settings missing in the config from the environment
+func withMongoDefaults(config MongoServiceConfig) MongoServiceConfig {
 	enviro := func(name string, defaultValue string) string {
 		if value, ok := os.LookupEnv(name); ok {
 			return value
@@ -54,58 +87,50 @@ func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[D
 		return defaultValue
 	}
 
-	svc := &mongoSvc[DocType]{}
-	svc.MongoServiceConfig = config
-
-	if svc.ServerHost == "" {
-		svc.ServerHost = enviro("AMBULANCE_COUNSELING_API_MONGODB_HOST", "localhost")
+	if config.ServerHost == "" {
+		config.ServerHost = enviro("AMBULANCE_COUNSELING_API_MONGODB_HOST", "localhost")
 	}
 
-	if svc.ServerPort == 0 {
+	if config.ServerPort == 0 {
 		port := enviro("AMBULANCE_COUNSELING_API_MONGODB_PORT", "27017")
 		if port, err := strconv.Atoi(port); err == nil {
-			svc.ServerPort = port
+			config.ServerPort = port
 		} else {
 			log.Printf("Invalid port value: %v", port)
-			svc.ServerPort = 27017
+			config.ServerPort = 27017
 		}
 	}
 
-	if svc.UserName == "" {
-		svc.UserName = enviro("AMBULANCE_COUNSELING_API_MONGODB_USERNAME", "")
+	if config.UserName == "" {
+		config.UserName = enviro("AMBULANCE_COUNSELING_API_MONGODB_USERNAME", "")
 	}
 
-	if svc.Password == "" {
-		svc.Password = enviro("AMBULANCE_COUNSELING_API_MONGODB_PASSWORD", "")
+	if config.Password == "" {
+		config.Password = enviro("AMBULANCE_COUNSELING_API_MONGODB_PASSWORD", "")
 	}
 
-	if svc.DbName == "" {
-		svc.DbName = enviro("AMBULANCE_COUNSELING_API_MONGODB_DATABASE", "ak-ambulance-counseling-wl")
+	if config.DbName == "" {
+		config.DbName = enviro("AMBULANCE_COUNSELING_API_MONGODB_DATABASE", "ak-ambulance-counseling-wl")
 	}
 
-	if svc.Collection == "" {
-		svc.Collection = enviro("AMBULANCE_COUNSELING_API_MONGODB_COLLECTION", "counseling")
+	if config.Collection == "" {
+		config.Collection = enviro("AMBULANCE_COUNSELING_API_MONGODB_COLLECTION", "counseling")
 	}
 
-	if svc.Timeout == 0 {
+	if config.Timeout == 0 {
 		seconds := enviro("AMBULANCE_COUNSELING_API_MONGODB_TIMEOUT_SECONDS", "10")
 		if seconds, err := strconv.Atoi(seconds); err == nil {
-			svc.Timeout = time.Duration(seconds) * time.Second
+			config.Timeout = time.Duration(seconds) * time.Second
 		} else {
 			log.Printf("Invalid timeout value: %v", seconds)
-			svc.Timeout = 10 * time.Second
+			config.Timeout = 10 * time.Second
 		}
 	}
 
-	log.Printf(
-		"MongoDB config: //%v@%v:%v/%v/%v",
-		svc.UserName,
-		svc.ServerHost,
-		svc.ServerPort,
-		svc.DbName,
-		svc.Collection,
-	)
-	return svc
+	if !config.AllowNonAtomicWrites {
+		config.AllowNonAtomicWrites = enviro("AMBULANCE_COUNSELING_API_MONGODB_ALLOW_NON_ATOMIC_WRITES", "") == "true"
+	}
+	return config
 }
 
 func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
@@ -127,6 +152,10 @@ func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, docum
 		return result.Err()
 	}
 
+	if versioned, ok := any(document).(Versioned); ok {
+		versioned.SetVersion(1)
+	}
+
 	_, err = collection.InsertOne(ctx, document)
 	return err
 }
@@ -148,10 +177,61 @@ func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, docum
 	default: // other errors - return them
 		return result.Err()
 	}
+
+	if versioned, ok := any(document).(Versioned); ok {
+		var stored *DocType
+		if err := result.Decode(&stored); err != nil {
+			return err
+		}
+		versioned.SetVersion(any(stored).(Versioned).GetVersion() + 1)
+	}
+
 	_, err = collection.ReplaceOne(ctx, bson.D{bson.E{Key: "id", Value: id}}, document)
 	return err
 }
 
+func (m *mongoSvc[DocType]) UpdateDocumentIfVersion(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	versioned, ok := any(document).(Versioned)
+	if !ok {
+		return ErrNotVersioned
+	}
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	filter := bson.D{bson.E{Key: "id", Value: id}, bson.E{Key: "version", Value: expectedVersion}}
+	if expectedVersion == 0 {
+		// documents stored before versioning was introduced have no version field
+		filter = bson.D{bson.E{Key: "id", Value: id}, bson.E{Key: "version", Value: bson.D{bson.E{Key: "$in", Value: bson.A{0, nil}}}}}
+	}
+
+	versioned.SetVersion(expectedVersion + 1)
+	result, err := collection.ReplaceOne(ctx, filter, document)
+	if err != nil {
+		versioned.SetVersion(expectedVersion)
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	versioned.SetVersion(expectedVersion)
+	switch err := collection.FindOne(ctx, bson.D{bson.E{Key: "id", Value: id}}).Err(); err {
+	case nil:
+		return ErrVersionMismatch
+	case mongo.ErrNoDocuments:
+		return ErrNotFound
+	default:
+		return err
+	}
+}
+
 func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
 	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
 	defer contextCancel()
@@ -253,52 +333,214 @@ func (m *mongoSvc[DocType]) FindDocumentsByField(ctx context.Context, fieldName
 	return results, nil
 }
 
+func (m *mongoSvc[DocType]) EnsureIndex(ctx context.Context, fields ...string) error {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+	// creating an index with the same keys again is a no-op
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+	return err
+}
+
+func (m *mongoSvc[DocType]) FindDocumentsByQuery(ctx context.Context, query Query) ([]*DocType, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	findOptions := options.Find()
+	if query.SortField != "" {
+		direction := 1
+		if query.SortDescending {
+			direction = -1
+		}
+		findOptions.SetSort(bson.D{
+			bson.E{Key: query.SortField, Value: direction},
+			bson.E{Key: "id", Value: direction},
+		})
+	}
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit)
+	}
+	if len(query.ExcludeFields) > 0 {
+		projection := bson.D{}
+		for _, field := range query.ExcludeFields {
+			projection = append(projection, bson.E{Key: field, Value: 0})
+		}
+		findOptions.SetProjection(projection)
+	}
+
+	cursor, err := collection.Find(ctx, buildQueryFilter(query), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []*DocType
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func buildQueryFilter(query Query) bson.D {
+	conditions := bson.A{}
+	for _, filter := range query.Filters {
+		conditions = append(conditions, buildFieldFilter(filter))
+	}
+
+	if len(query.AnyOf) > 0 {
+		alternatives := bson.A{}
+		for _, filter := range query.AnyOf {
+			alternatives = append(alternatives, buildFieldFilter(filter))
+		}
+		conditions = append(conditions, bson.D{bson.E{Key: "$or", Value: alternatives}})
+	}
+
+	if len(query.AnyOfGroups) > 0 {
+		alternatives := bson.A{}
+		for _, group := range query.AnyOfGroups {
+			groupConditions := bson.A{}
+			for _, filter := range group {
+				groupConditions = append(groupConditions, buildFieldFilter(filter))
+			}
+			alternatives = append(alternatives, bson.D{bson.E{Key: "$and", Value: groupConditions}})
+		}
+		conditions = append(conditions, bson.D{bson.E{Key: "$or", Value: alternatives}})
+	}
+
+	// keyset pagination - continue strictly after the cursor position
+	if query.After != nil && query.SortField != "" {
+		operator := "$gt"
+		if query.SortDescending {
+			operator = "$lt"
+		}
+		conditions = append(conditions, bson.D{bson.E{Key: "$or", Value: bson.A{
+			bson.D{bson.E{Key: query.SortField, Value: bson.D{bson.E{Key: operator, Value: query.After.SortValue}}}},
+			bson.D{
+				bson.E{Key: query.SortField, Value: query.After.SortValue},
+				bson.E{Key: "id", Value: bson.D{bson.E{Key: operator, Value: query.After.Id}}},
+			},
+		}}})
+	}
+
+	if len(conditions) == 0 {
+		return bson.D{}
+	}
+	return bson.D{bson.E{Key: "$and", Value: conditions}}
+}
+
+func buildFieldFilter(filter FieldFilter) bson.D {
+	return bson.D{
+		bson.E{Key: filter.Field, Value: bson.D{bson.E{Key: string(filter.Operator), Value: filter.Value}}},
+	}
+}
+
 func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error) {
+	return m.connection.connect(ctx)
+}
+
+func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
+	if m.disconnected.Swap(true) {
+		return nil
+	}
+	return m.connection.release(ctx)
+}
+
+// mongoConnection holds the client shared by the services and transactors of a server.
+// A transaction spans several collections and MongoDB accepts its session only from the
+// client that started it.
+type mongoConnection struct {
+	uri     string
+	timeout time.Duration
+	client  atomic.Pointer[mongo.Client]
+	lock    sync.Mutex
+	// services and transactors that have not disconnected yet
+	users int
+}
+
+var mongoConnections = struct {
+	sync.Mutex
+	byUri map[string]*mongoConnection
+}{byUri: map[string]*mongoConnection{}}
+
+// Returns the connection to the server of the config, the caller releases it on disconnect
+func acquireMongoConnection(config MongoServiceConfig) *mongoConnection {
+	var uri = fmt.Sprintf("mongodb://%v:%v", config.ServerHost, config.ServerPort)
+
+	if len(config.UserName) != 0 {
+		uri = fmt.Sprintf("mongodb://%v:%v@%v:%v", config.UserName, config.Password, config.ServerHost, config.ServerPort)
+	}
+
+	mongoConnections.Lock()
+	defer mongoConnections.Unlock()
+	connection, exists := mongoConnections.byUri[uri]
+	if !exists {
+		connection = &mongoConnection{uri: uri, timeout: config.Timeout}
+		mongoConnections.byUri[uri] = connection
+	}
+	connection.users++
+	return connection
+}
+
+func (c *mongoConnection) connect(ctx context.Context) (*mongo.Client, error) {
 	// optimistic check
-	client := m.client.Load()
+	client := c.client.Load()
 	if client != nil {
 		return client, nil
 	}
 
-	m.clientLock.Lock()
-	defer m.clientLock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	// pesimistic check
-	client = m.client.Load()
+	client = c.client.Load()
 	if client != nil {
 		return client, nil
 	}
 
-	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	ctx, contextCancel := context.WithTimeout(ctx, c.timeout)
 	defer contextCancel()
 
-	var uri = fmt.Sprintf("mongodb://%v:%v", m.ServerHost, m.ServerPort)
-
-	if len(m.UserName) != 0 {
-		uri = fmt.Sprintf("mongodb://%v:%v@%v:%v", m.UserName, m.Password, m.ServerHost, m.ServerPort)
-	}
-
-	if client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetConnectTimeout(10*time.Second)); err != nil {
+	// the configured server is the only one used, also when it is a member of a replica set
+	// whose member addresses are not reachable from the service
+	if client, err := mongo.Connect(ctx, options.Client().ApplyURI(c.uri).SetDirect(true).SetConnectTimeout(10*time.Second)); err != nil {
 		return nil, err
 	} else {
-		m.client.Store(client)
+		c.client.Store(client)
 		return client, nil
 	}
 }
 
-func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
-	client := m.client.Load()
+// Disconnects the client once the last user of the connection released it
+func (c *mongoConnection) release(ctx context.Context) error {
+	mongoConnections.Lock()
+	defer mongoConnections.Unlock()
 
-	if client != nil {
-		m.clientLock.Lock()
-		defer m.clientLock.Unlock()
-
-		client = m.client.Load()
-		defer m.client.Store(nil)
-		if client != nil {
-			if err := client.Disconnect(ctx); err != nil {
-				return err
-			}
-		}
+	c.users--
+	if c.users > 0 {
+		return nil
+	}
+	delete(mongoConnections.byUri, c.uri)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if client := c.client.Swap(nil); client != nil {
+		return client.Disconnect(ctx)
 	}
 	return nil
 }
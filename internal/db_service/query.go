@@ -0,0 +1,45 @@
+package db_service
+
+type FilterOperator string
+
+const (
+	OpEq  FilterOperator = "$eq"
+	OpNe  FilterOperator = "$ne"
+	OpGt  FilterOperator = "$gt"
+	OpGte FilterOperator = "$gte"
+	OpLt  FilterOperator = "$lt"
+	OpLte FilterOperator = "$lte"
+	// Value is a regular expression string, inline flags like (?i) are supported by both services
+	OpRegex FilterOperator = "$regex"
+	// Value is a slice, the field equals one of its elements, a nil element matches missing fields
+	OpIn FilterOperator = "$in"
+)
+
+// FieldFilter is a single condition on a (possibly dotted) document field.
+type FieldFilter struct {
+	Field    string
+	Operator FilterOperator
+	Value    interface{}
+}
+
+// QueryCursor marks the last document of the previous page for keyset pagination.
+type QueryCursor struct {
+	SortValue interface{}
+	Id        string
+}
+
+// Query describes a filtered, sorted and limited lookup. All filters must match
+// and, when AnyOf is not empty, at least one of its filters must match as well.
+// When AnyOfGroups is not empty, all filters of at least one of its groups must match.
+// Results are ordered by SortField and then by document id, so that the
+// cursor always identifies a unique position.
+type Query struct {
+	Filters        []FieldFilter
+	AnyOf          []FieldFilter
+	AnyOfGroups    [][]FieldFilter
+	SortField      string
+	SortDescending bool
+	After          *QueryCursor
+	Limit          int64
+	ExcludeFields  []string
+}
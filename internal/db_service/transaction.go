@@ -0,0 +1,177 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrTransactionsUnsupported is returned when the server cannot run units of work atomically
+var ErrTransactionsUnsupported = fmt.Errorf("transactions need a replica set or a sharded cluster")
+
+// Transactor runs units of work. The writes services make with the context passed to the
+// function are committed together when it returns nil and discarded when it fails.
+// Calls made within a unit of work join it.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Verify fails when units of work cannot run, services check it before serving requests
+	Verify(ctx context.Context) error
+
+	Disconnect(ctx context.Context) error
+}
+
+type mongoTransactor struct {
+	MongoServiceConfig
+	connection *mongoConnection
+
+	supportLock sync.Mutex
+	// nil until the server was asked whether it supports transactions
+	supported *bool
+}
+
+// NewMongoTransactor returns a transactor for the services of the same server. Transactions
+// need a replica set, on a standalone server units of work fail unless AllowNonAtomicWrites
+// is set, in which case their writes are not atomic.
+func NewMongoTransactor(config MongoServiceConfig) Transactor {
+	transactor := &mongoTransactor{}
+	transactor.MongoServiceConfig = withMongoDefaults(config)
+	transactor.connection = acquireMongoConnection(transactor.MongoServiceConfig)
+	return transactor
+}
+
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	client, err := t.connection.connect(ctx)
+	if err != nil {
+		return err
+	}
+	supported, err := t.supportsTransactions(ctx, client)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		if !t.AllowNonAtomicWrites {
+			return ErrTransactionsUnsupported
+		}
+		return fn(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// the driver retries the function on transient errors, it must be safe to run again
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+func (t *mongoTransactor) Verify(ctx context.Context) error {
+	ctx, contextCancel := context.WithTimeout(ctx, t.Timeout)
+	defer contextCancel()
+	client, err := t.connection.connect(ctx)
+	if err != nil {
+		return err
+	}
+	supported, err := t.supportsTransactions(ctx, client)
+	if err != nil {
+		return err
+	}
+	if !supported && !t.AllowNonAtomicWrites {
+		return fmt.Errorf("MongoDB at %v:%v: %w", t.ServerHost, t.ServerPort, ErrTransactionsUnsupported)
+	}
+	return nil
+}
+
+// Reports whether the server is a replica set member or a sharded cluster router
+func (t *mongoTransactor) supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	t.supportLock.Lock()
+	defer t.supportLock.Unlock()
+	if t.supported != nil {
+		return *t.supported, nil
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	supported := hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !supported && t.AllowNonAtomicWrites {
+		log.Printf("MongoDB at %v:%v is not a replica set, multi-document writes are not atomic", t.ServerHost, t.ServerPort)
+	}
+	t.supported = &supported
+	return supported, nil
+}
+
+func (t *mongoTransactor) Disconnect(ctx context.Context) error {
+	return t.connection.release(ctx)
+}
+
+type memoryTransactionKey struct{}
+
+// memoryTransaction collects the changes undoing the writes of a unit of work
+type memoryTransaction struct {
+	undo []func()
+}
+
+// Undoes the writes in reverse order
+func (tx *memoryTransaction) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+// Registers the undo function when the context belongs to a unit of work
+func recordUndo(ctx context.Context, undo func()) {
+	if tx, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
+type memoryTransactor struct {
+	lock sync.Mutex
+}
+
+// NewMemoryTransactor returns a transactor for the memory services. Units of work run one at a
+// time and their writes are undone when they fail, other readers may see uncommitted writes.
+func NewMemoryTransactor() Transactor {
+	return &memoryTransactor{}
+}
+
+func (t *memoryTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTransactionKey{}) != nil {
+		return fn(ctx)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	tx := &memoryTransaction{}
+	if err := fn(context.WithValue(ctx, memoryTransactionKey{}, tx)); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+func (t *memoryTransactor) Verify(ctx context.Context) error {
+	return nil
+}
+
+func (t *memoryTransactor) Disconnect(ctx context.Context) error {
+	return nil
+}
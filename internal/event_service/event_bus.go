@@ -0,0 +1,22 @@
+package event_service
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotDelivered is returned when a subscriber did not receive a published event
+var ErrNotDelivered = errors.New("event not delivered to every subscriber")
+
+// EventBus delivers published events to every current subscriber
+type EventBus[EventType interface{}] interface {
+	// Publish waits until the context ends for subscribers whose buffer is full and returns
+	// ErrNotDelivered when one of them did not receive the event, live subscribers are never waited for
+	Publish(ctx context.Context, event EventType) error
+	// Subscribe returns the channel receiving the events published from now on,
+	// the returned function ends the subscription and closes the channel
+	Subscribe() (<-chan EventType, func())
+	// SubscribeLive is Subscribe for consumers showing live updates, which reload their state
+	// on reconnect. Events are dropped for them when they fall behind.
+	SubscribeLive() (<-chan EventType, func())
+}
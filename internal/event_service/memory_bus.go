@@ -0,0 +1,78 @@
+package event_service
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Events waiting for a slow subscriber before publishers wait for it
+const subscriberBufferSize = 64
+
+// memoryBus delivers events within the process, subscribers of other instances of the service
+// do not receive them
+type memoryBus[EventType interface{}] struct {
+	lock sync.RWMutex
+	// subscriber channels, true for live subscribers
+	subscribers map[chan EventType]bool
+}
+
+func NewMemoryBus[EventType interface{}]() EventBus[EventType] {
+	return &memoryBus[EventType]{
+		subscribers: map[chan EventType]bool{},
+	}
+}
+
+func (b *memoryBus[EventType]) Publish(ctx context.Context, event EventType) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	delivered := true
+	for subscriber, live := range b.subscribers {
+		select {
+		case subscriber <- event:
+			continue
+		default:
+		}
+		if live {
+			// a full buffer loses the event, live subscribers reload on reconnect
+			log.Printf("Event dropped for a slow live subscriber")
+			continue
+		}
+		select {
+		case subscriber <- event:
+		case <-ctx.Done():
+			delivered = false
+		}
+	}
+	if !delivered {
+		return ErrNotDelivered
+	}
+	return nil
+}
+
+func (b *memoryBus[EventType]) Subscribe() (<-chan EventType, func()) {
+	return b.subscribe(false)
+}
+
+func (b *memoryBus[EventType]) SubscribeLive() (<-chan EventType, func()) {
+	return b.subscribe(true)
+}
+
+func (b *memoryBus[EventType]) subscribe(live bool) (<-chan EventType, func()) {
+	subscriber := make(chan EventType, subscriberBufferSize)
+
+	b.lock.Lock()
+	b.subscribers[subscriber] = live
+	b.lock.Unlock()
+
+	var once sync.Once
+	return subscriber, func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, subscriber)
+			b.lock.Unlock()
+			close(subscriber)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package file_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type DiskStoreConfig struct {
+	// Directory receiving one file per id
+	Directory string
+}
+
+// diskStore keeps the files on the local disk, it suits a single replica and local development
+type diskStore struct {
+	DiskStoreConfig
+}
+
+func NewDiskStore(config DiskStoreConfig) FileStore {
+	store := &diskStore{}
+	store.DiskStoreConfig = config
+
+	if store.Directory == "" {
+		store.Directory = enviro("AMBULANCE_COUNSELING_API_FILE_DIRECTORY", "attachments")
+	}
+
+	log.Printf("File store config: files are written to %v", store.Directory)
+	return store
+}
+
+func (s *diskStore) SaveFile(ctx context.Context, id string, name string, content io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Directory, 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrConflict
+		}
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func (s *diskStore) OpenFile(ctx context.Context, id string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *diskStore) DeleteFile(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *diskStore) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+// Path of the file with the id, ids must not escape the directory
+func (s *diskStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid file id %q", id)
+	}
+	return filepath.Join(s.Directory, id), nil
+}
@@ -0,0 +1,28 @@
+package file_service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileStore keeps the content of uploaded files, their metadata is stored by the caller
+type FileStore interface {
+	// SaveFile stores the content under the id, an existing file is never overwritten
+	SaveFile(ctx context.Context, id string, name string, content io.Reader) error
+	OpenFile(ctx context.Context, id string) (io.ReadCloser, error)
+	DeleteFile(ctx context.Context, id string) error
+
+	Disconnect(ctx context.Context) error
+}
+
+var ErrNotFound = fmt.Errorf("file not found")
+var ErrConflict = fmt.Errorf("conflict: file already exists")
+
+func enviro(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return defaultValue
+}
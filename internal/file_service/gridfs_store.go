@@ -0,0 +1,117 @@
+package file_service
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/AKoricansky/wac-be-xkoricansky/internal/db_service"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type GridFsStoreConfig struct {
+	// Database on the connection shared with the document services
+	Database db_service.MongoDatabase
+	Bucket   string
+	Timeout  time.Duration
+}
+
+// gridFsStore keeps the files in a MongoDB GridFS bucket shared by all replicas
+type gridFsStore struct {
+	GridFsStoreConfig
+}
+
+func NewGridFsStore(config GridFsStoreConfig) FileStore {
+	store := &gridFsStore{}
+	store.GridFsStoreConfig = config
+
+	if store.Bucket == "" {
+		store.Bucket = enviro("AMBULANCE_COUNSELING_API_GRIDFS_BUCKET", "attachments")
+	}
+
+	if store.Timeout == 0 {
+		seconds := enviro("AMBULANCE_COUNSELING_API_GRIDFS_TIMEOUT_SECONDS", "60")
+		if seconds, err := strconv.Atoi(seconds); err == nil {
+			store.Timeout = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid timeout value: %v", seconds)
+			store.Timeout = 60 * time.Second
+		}
+	}
+
+	log.Printf("GridFS config: bucket %v", store.Bucket)
+	return store
+}
+
+func (s *gridFsStore) SaveFile(ctx context.Context, id string, name string, content io.Reader) error {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return err
+	}
+
+	// GridFS does not enforce unique ids of the files
+	ctx, contextCancel := context.WithTimeout(ctx, s.Timeout)
+	defer contextCancel()
+	count, err := bucket.GetFilesCollection().CountDocuments(ctx, bson.D{bson.E{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrConflict
+	}
+
+	if err := bucket.SetWriteDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return err
+	}
+	return bucket.UploadFromStreamWithID(id, name, content)
+}
+
+func (s *gridFsStore) OpenFile(ctx context.Context, id string) (io.ReadCloser, error) {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bucket.SetReadDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return nil, err
+	}
+	stream, err := bucket.OpenDownloadStream(id)
+	if err == gridfs.ErrFileNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *gridFsStore) DeleteFile(ctx context.Context, id string) error {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, contextCancel := context.WithTimeout(ctx, s.Timeout)
+	defer contextCancel()
+	err = bucket.DeleteContext(ctx, id)
+	if err == gridfs.ErrFileNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *gridFsStore) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	database, err := s.Database.Database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gridfs.NewBucket(database, options.GridFSBucket().SetName(s.Bucket))
+}
+
+func (s *gridFsStore) Disconnect(ctx context.Context) error {
+	return s.Database.Disconnect(ctx)
+}
@@ -0,0 +1,60 @@
+package mail_service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+type FileMailerConfig struct {
+	// Directory receiving one .eml file per message, messages are only logged when empty
+	Directory string
+	From      string
+}
+
+// fileMailer is meant for local development and testing, it never delivers anything
+type fileMailer struct {
+	FileMailerConfig
+	sequence atomic.Int64
+}
+
+func NewFileMailer(config FileMailerConfig) Mailer {
+	mailer := &fileMailer{}
+	mailer.FileMailerConfig = config
+
+	if mailer.Directory == "" {
+		mailer.Directory = enviro("AMBULANCE_COUNSELING_API_MAIL_DIRECTORY", "")
+	}
+
+	if mailer.From == "" {
+		mailer.From = enviro("AMBULANCE_COUNSELING_API_SMTP_FROM", "no-reply@ambulance-counseling.local")
+	}
+
+	if mailer.Directory == "" {
+		log.Printf("Mail config: messages are written to the log")
+	} else {
+		log.Printf("Mail config: messages are written to %v", mailer.Directory)
+	}
+	return mailer
+}
+
+func (m *fileMailer) SendMail(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.Directory == "" {
+		log.Printf("Mail to %v: %v\n%v", message.To, message.Subject, message.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Directory, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%03d.eml", time.Now().Format("20060102-150405.000"), m.sequence.Add(1)%1000)
+	return os.WriteFile(filepath.Join(m.Directory, name), formatMessage(m.From, message), 0o644)
+}
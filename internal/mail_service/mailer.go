@@ -0,0 +1,23 @@
+package mail_service
+
+import (
+	"context"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	SendMail(ctx context.Context, message Message) error
+}
+
+func enviro(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return defaultValue
+}
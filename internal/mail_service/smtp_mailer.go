@@ -0,0 +1,154 @@
+package mail_service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SmtpMailerConfig struct {
+	ServerHost string
+	ServerPort int
+	UserName   string
+	Password   string
+	From       string
+	// Limit of the whole exchange with the server, a hung server fails the send
+	Timeout time.Duration
+}
+
+type smtpMailer struct {
+	SmtpMailerConfig
+}
+
+func NewSmtpMailer(config SmtpMailerConfig) Mailer {
+	mailer := &smtpMailer{}
+	mailer.SmtpMailerConfig = config
+
+	if mailer.ServerHost == "" {
+		mailer.ServerHost = enviro("AMBULANCE_COUNSELING_API_SMTP_HOST", "localhost")
+	}
+
+	if mailer.ServerPort == 0 {
+		port := enviro("AMBULANCE_COUNSELING_API_SMTP_PORT", "587")
+		if port, err := strconv.Atoi(port); err == nil {
+			mailer.ServerPort = port
+		} else {
+			log.Printf("Invalid SMTP port value: %v", port)
+			mailer.ServerPort = 587
+		}
+	}
+
+	if mailer.UserName == "" {
+		mailer.UserName = enviro("AMBULANCE_COUNSELING_API_SMTP_USERNAME", "")
+	}
+
+	if mailer.Password == "" {
+		mailer.Password = enviro("AMBULANCE_COUNSELING_API_SMTP_PASSWORD", "")
+	}
+
+	if mailer.From == "" {
+		mailer.From = enviro("AMBULANCE_COUNSELING_API_SMTP_FROM", "no-reply@ambulance-counseling.local")
+	}
+
+	if mailer.Timeout == 0 {
+		seconds := enviro("AMBULANCE_COUNSELING_API_SMTP_TIMEOUT_SECONDS", "10")
+		if seconds, err := strconv.Atoi(seconds); err == nil {
+			mailer.Timeout = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid SMTP timeout value: %v", seconds)
+			mailer.Timeout = 10 * time.Second
+		}
+	}
+
+	log.Printf("SMTP config: //%v@%v:%v from %v", mailer.UserName, mailer.ServerHost, mailer.ServerPort, mailer.From)
+	return mailer
+}
+
+func (m *smtpMailer) SendMail(ctx context.Context, message Message) error {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+
+	address := fmt.Sprintf("%v:%v", m.ServerHost, m.ServerPort)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	// the deadline bounds every read and write, cancelling the context ends the exchange early
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	client, err := smtp.NewClient(conn, m.ServerHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	return m.send(client, message)
+}
+
+// Runs the exchange of smtp.SendMail over the client
+func (m *smtpMailer) send(client *smtp.Client, message Message) error {
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.ServerHost}); err != nil {
+			return err
+		}
+	}
+	if len(m.UserName) != 0 {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(smtp.PlainAuth("", m.UserName, m.Password, m.ServerHost)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(formatMessage(m.From, message)); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Builds a plain text RFC 5322 message
+func formatMessage(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + headerValue(from) + "\r\n")
+	builder.WriteString("To: " + headerValue(message.To) + "\r\n")
+	builder.WriteString("Subject: " + headerValue(message.Subject) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
+
+// Line breaks in header values would allow injecting additional headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -29,6 +29,9 @@ switch ($command) {
             mongo down
         }
     }
+    "migrate" {
+        go run ${ProjectRoot}/cmd/ambulance-counseling-migrate
+    }
     "mongo" {
         mongo up
     }